@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// inode always returns false since Windows doesn't expose the file index
+// through os.FileInfo.
+func inode(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// linkCount always returns false since Windows doesn't expose the number of
+// hard links through os.FileInfo.
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// https://learn.microsoft.com/en-us/windows/win32/api/fileapi/nf-fileapi-lockfileex
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// lockFile locks the first byte of the file with LockFileEx, which is enough
+// since all the prowdig processes lock the same byte. When block is false and
+// another process holds a conflicting lock, errLocked is returned right away.
+func lockFile(f *os.File, exclusive, block bool) error {
+	var flags uintptr
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	if !block {
+		flags |= lockfileFailImmediately
+	}
+	ol := new(syscall.Overlapped)
+	r1, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r1 != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errLocked
+	}
+	return err
+}
@@ -7,11 +7,13 @@ require (
 	github.com/alecthomas/kong v0.2.22
 	github.com/fatih/color v1.13.0
 	github.com/joshdk/go-junit v0.0.0-20210226021600-6145f504ca0d
+	github.com/klauspost/compress v1.15.15
 	github.com/mattn/go-isatty v0.0.14
 	github.com/onsi/gomega v1.26.0
 	github.com/schollz/progressbar/v3 v3.8.5
 	github.com/stretchr/testify v1.7.0
 	google.golang.org/api v0.63.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -47,5 +49,4 @@ require (
 	google.golang.org/grpc v1.40.1 // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
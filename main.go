@@ -1,39 +1,78 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"hash"
 	"hash/crc32"
+	"html"
 	"io"
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
+	"text/template"
 	"time"
+	_ "time/tzdata" // So that --timezone works on machines without tzdata.
 
 	"cloud.google.com/go/storage"
 	"github.com/alecthomas/kong"
 	"github.com/fatih/color"
 	"github.com/joshdk/go-junit"
+	"github.com/klauspost/compress/zstd"
 	"github.com/mattn/go-isatty"
 	pb "github.com/schollz/progressbar/v3"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	bucketName = "jetstack-logs"
 
+	// Either "gs" when the bucket is in GCS, "s3" when it is in S3 or in
+	// an S3-compatible store such as MinIO, or "http" and "https" when the
+	// artifacts are served by an HTTP artifact server such as gcsweb. See
+	// newObjectStore.
+	storageScheme = "gs"
+
+	// The URL of the bucket on the HTTP artifact server, e.g.
+	// "https://gcsweb.k8s.io/gcs/kubernetes-jenkins". Only used when
+	// storageScheme is "http" or "https".
+	artifactsURL = ""
+
+	// (optional) The URL of the S3-compatible store, e.g.
+	// "http://minio.example.com:9000". Only used when storageScheme is "s3".
+	// When empty, the AWS endpoint of the region is used.
+	s3Endpoint = ""
+
 	// In order to find which jobs are currently running in Prow:
 	//
 	//  curl -s https://prow.build-infra.jetstack.net/prowjobs.js\?var\=allBuilds\&omit\=annotations | sed -e 's/^var allBuilds = //' -e 's/;$//g' | jq '.items[].status.url | select(.)' -r | sed 's@https://prow.build-infra.jetstack.net/view/gs/jetstack-logs/@@' | grep ^logs/ci- | sed 's@/[0-9]*$@@' | sort | uniq
@@ -84,22 +123,148 @@ var (
 		"logs/ci-cert-manager-venafi",
 	}
 
-	cacheDir = os.Getenv("HOME") + "/.cache/prowdig/" + bucketName
+	// When a GitHub organization is renamed, Prow starts storing the builds
+	// of the same repository under a new prefix. The PR numbers don't change,
+	// so we treat the old prefix as an alias of the new one. The key is the
+	// alias, the value is the canonical prefix.
+	prefixAliases = map[string]string{
+		"pr-logs/pull/jetstack_cert-manager": "pr-logs/pull/cert-manager_cert-manager",
+	}
+
+	// The URL of Deck, the Prow UI. Used to know which builds are currently
+	// known to Prow.
+	deckURL = "https://prow.build-infra.jetstack.net"
+
+	// The GitHub repository that the Prow jobs are testing, e.g.
+	// "cert-manager/cert-manager".
+	githubRepo = "cert-manager/cert-manager"
+
+	// The base URL of the GitHub REST API. Changed in the tests.
+	githubAPI = "https://api.github.com"
+
+	// The directory under which each bucket (or each profile) gets its own
+	// cache directory. Can be changed with --cache-dir.
+	cacheRoot  = homeDir() + "/.cache/prowdig"
+	cacheDir   = cacheRoot + "/" + bucketName
+	configFile = homeDir() + "/.config/prowdig/config.yaml"
 
 	endsWithPRNumber    = regexp.MustCompile(`/(\d+)/?$`)
-	rmAnsiColors        = regexp.MustCompile(`\x1B\[([0-9]{1,3}(;[0-9]{1,2})?)?[mGK]`)
-	reGingkoBlockHeader = regexp.MustCompile(`• (Failure|Failure in Spec Setup.*) \[(\d+)\.\d+ `)
+	rmAnsiColors        = regexp.MustCompile(`\x1B\[[0-9;]*[mGK]`)
+	reGingkoBlockHeader = regexp.MustCompile(`•(?: |! |\.\.\. )(Failure|Failure in Spec Setup.*|Panic|Panic in Spec Setup.*|Timeout) \[(\d+\.\d+) `)
 	isParen             = regexp.MustCompile(" *}$")
 	isJunitFile         = regexp.MustCompile(`junit__.*\.xml$`)
 	isBuildLogFile      = regexp.MustCompile(`build-log\.txt$`)
-	isToBeDownloaded    = regexp.MustCompile("(" + isJunitFile.String() + "|" + isBuildLogFile.String() + ")")
-	reObjectName        = regexp.MustCompile(`/(\d+)\/([^\/]+)\/(\d+)\/`)
+	isProwJobFile       = regexp.MustCompile(`prowjob\.json$`)
+
+	// The Prow pod utilities upload whatever the job writes to $ARTIFACTS
+	// under artifacts/, and the junit files can be anywhere in that subtree,
+	// e.g. artifacts/e2e/junit_runner.xml.
+	isArtifactsJunitFile = regexp.MustCompile(`/artifacts/(.+/)?junit[^/]*\.xml$`)
+	isToBeDownloaded     = regexp.MustCompile("(" + isJunitFile.String() + "|" + isArtifactsJunitFile.String() + "|" + isBuildLogFile.String() + ")")
+
+	// The rules that extract the dimensions from the test names, see
+	// GinkgoResult.Dimensions. The key is the name of the dimension, and the
+	// first group of the regular expression is its value. The built-in rules
+	// work for the cert-manager test names, e.g.:
+	//
+	//	[Conformance] Certificates with issuer type ACME HTTP01 ClusterIssuer (Gateway) should issue a basic, defaulted certificate for a single distinct DNS Name
+	//	                                            <-issuer--->               <variant>
+	dimensions = map[string]*regexp.Regexp{
+		"issuer":  regexp.MustCompile(`with issuer type (.+?) (?:Cluster)?Issuer`),
+		"variant": regexp.MustCompile(`\((Ingress|Gateway)\)`),
+	}
+
+	// The rules that tell who owns each test, see GinkgoResult.Owner. Set
+	// with 'owners' in the profile. There is no built-in rule.
+	owners []ownerRule
 
 	red   = color.New(color.FgRed).SprintFunc()
 	green = color.New(color.FgGreen).SprintFunc()
 	blue  = color.New(color.FgBlue).SprintFunc()
 	gray  = color.New(color.FgHiBlack).SprintFunc()
 
+	// Where the progress bars are written to. Set to io.Discard when stderr
+	// isn't a terminal or when --plain is given.
+	progressOut io.Writer = os.Stderr
+
+	// Whether the test statuses are shown as words instead of emojis. Set when
+	// stdout isn't a terminal or when --plain is given.
+	asciiIcons bool
+
+	// The timezone in which the timestamps are displayed, set with
+	// --timezone.
+	timezone = time.UTC
+
+	// Set with --mass-failure-threshold.
+	massFailureThreshold = 0.3
+
+	// The builds that started before this time are ignored. Set with --days
+	// or --since. The zero value means that no build is ignored.
+	since time.Time
+
+	// The builds that started at or after this time are ignored. Set with
+	// --until. The zero value means that no build is ignored.
+	until time.Time
+
+	// The builds that aren't in this set are ignored. Set with --build and
+	// --builds-file. Nil means that no build is ignored.
+	onlyBuilds map[int]struct{}
+
+	// The builds of the jobs that don't match this regular expression are
+	// ignored. Set with --job. Nil means that no job is ignored.
+	onlyJobs *regexp.Regexp
+
+	// In bytes, set with --max-cache-size. The zero value means that the
+	// cache is not limited.
+	maxCacheSize int64
+
+	// In bytes, set with --tail-bytes. Only the end of the build-log.txt
+	// files bigger than this is downloaded. The zero value means that the
+	// whole files are downloaded.
+	tailBytes int64
+
+	// When true, parseGinkgoResultsFromCache fails as soon as one of the
+	// artifacts fails to parse. Set with --strict.
+	strict bool
+
+	// When true, parseGinkgoResultsFromCache and parseBuildsFromCache pass
+	// the error messages and URLs through anonymize. Set with --anonymize.
+	anonymized bool
+
+	// The tests that don't match nameRegex or that match excludeName are
+	// ignored. Set with --name-regex and --exclude-name. Nil means that no
+	// test is ignored.
+	nameRegex, excludeName *regexp.Regexp
+
+	// The tests that aren't owned by this owner are ignored. Set with
+	// --owner. The empty string means that no test is ignored.
+	ownerFilter string
+
+	// The fraction of the builds analyzed, between 0 (excluded) and 1, and
+	// the seed used to pick them. Set with --sample and --seed.
+	sampleFraction = 1.0
+	sampleSeed     int64
+
+	// When true, parseGinkgoResultsFromCache keeps the duplicate results.
+	// Set with --allow-duplicates.
+	allowDuplicates bool
+
+	// When true, parseArtifactsFromCache prints the artifacts that it would
+	// parse and returns errExplained. Set with --explain.
+	explain bool
+
+	// When set, parseGinkgoResultsFromCache reads the results from this JSON
+	// file instead of parsing the cache. Set with --from-json.
+	fromJSON string
+
+	// When true, parseArtifactsFromCache parses every artifact again instead
+	// of using the index of the parsed results. Set with --no-index.
+	noIndex bool
+
+	// The index of the parsed results, loaded the first time that
+	// parseArtifactsFromCache runs.
+	resultsIndex *artifactIndex
+
 	theme = pb.Theme{Saucer: "[green]=[reset]", SaucerHead: "[green]>[reset]", SaucerPadding: " ", BarStart: "[", BarEnd: "]"}
 )
 
@@ -111,22 +276,42 @@ const (
 
 	// When the test setup failed, e.g. during BeforeEach.
 	statusError status = "error"
+
+	// When the test hit its timeout or panicked. They are failures too, but
+	// are told apart so that the timeouts can be separated from the
+	// assertion failures.
+	statusTimedOut status = "timedout"
+	statusPanicked status = "panicked"
 )
 
-// Watch out, one test case outcome may appear twice in the array of testcases.
-// We do not do de-duplication yet.
+// isFailed tells whether the status is one of the failure statuses: "failed",
+// "timedout", or "panicked". The "error" status doesn't count as a failure
+// since the test itself didn't get to run.
+func (s status) isFailed() bool {
+	return s == statusFailed || s == statusTimedOut || s == statusPanicked
+}
+
+// A test case outcome is identified by its job, build, name, and attempt, see
+// resultKey. The same outcome may be found twice, e.g. when a build is stored
+// under two aliased prefixes or when two junit files of a build contain the
+// same test case. These duplicates are removed by dedupResults. A failure is
+// usually found both in a junit file and in build-log.txt; these two records
+// are merged into one by mergeSources.
 type GinkgoResult struct {
 	// The Name of the ginkgo result is of the form:
 	//  [Conformance] Certificates with issuer type External ClusterIssuer should issue a cert with wildcard DNS Name
 	// Note that the string '[It]' does not appear in the test Name.
 	Name string `json:"name"`
 
-	// The Status of the gingko test result. Can be "failed", "error", or
-	// "passed". The "skipped" statuses are not dealt with in prowdig.
+	// The Status of the gingko test result. Can be "failed", "timedout",
+	// "panicked", "error", or "passed". The "skipped" statuses are not dealt
+	// with in prowdig.
 	Status status `json:"status"`
 
-	// The Duration of the test case in seconds.
-	Duration int `json:"duration"`
+	// The Duration of the test case, with a millisecond precision when the
+	// junit file or the ginkgo block gives it. In JSON, it is a number of
+	// seconds, e.g. 1.234, see MarshalJSON.
+	Duration time.Duration `json:"duration"`
 
 	// (optional) The error message shown right before the keyword 'occurred' at
 	// the bottom of the ginkgo block.
@@ -151,17 +336,116 @@ type GinkgoResult struct {
 
 	// (optional) The Prow job build number.
 	Build int `json:"build"`
+
+	// (optional) When the build started. It is derived from the build
+	// number, see buildStarted.
+	Started time.Time `json:"started"`
+
+	// (optional) The content of the <system-out> and <system-err> elements
+	// of the test case, and the properties of its test suite (e.g., the
+	// Ginkgo seed). Only available for the results parsed
+	// from junit files.
+	SystemOut  string            `json:"systemOut,omitempty"`
+	SystemErr  string            `json:"systemErr,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+
+	// (optional) The number of times the test ran in the build, including the
+	// re-runs. A test that passed with more than one attempt is a flake.
+	// Only available for the results parsed from junit files.
+	Attempts int `json:"attempts,omitempty"`
+
+	// The position of this run among the runs of the same test in the same
+	// junit or build-log.txt file, starting at 1. It tells apart the re-runs
+	// of a test within a build.
+	Attempt int `json:"attempt"`
+
+	// (optional) The dimensions extracted from the test name, e.g.
+	// {"issuer": "Vault AppRole"}, see extractDimensions.
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+
+	// (optional) The owner of the test, e.g. the team in charge of it, see
+	// findOwner.
+	Owner string `json:"owner,omitempty"`
+
+	// Whether the build in which this result was found is a mass-failure
+	// build, i.e., a build in which an unusually large fraction of the tests
+	// failed, see tagMassFailures.
+	MassFailure bool `json:"massFailure"`
+
+	// (optional) The bucket prefix under which the build was found, e.g.
+	// "pr-logs/pull/cert-manager_cert-manager". The prefix aliases are
+	// applied, meaning that two builds of the same PR stored under two
+	// aliased prefixes have the same Prefix.
+	Prefix string `json:"prefix"`
+}
+
+// MarshalJSON encodes the duration as a number of seconds instead of
+// nanoseconds. The durations used to be whole seconds, which means that the
+// JSON written by older versions of prowdig can still be read.
+func (res GinkgoResult) MarshalJSON() ([]byte, error) {
+	type plain GinkgoResult
+	return json.Marshal(struct {
+		plain
+		Duration float64 `json:"duration"`
+	}{plain(res), res.Duration.Seconds()})
+}
+
+// UnmarshalJSON is the counterpart of MarshalJSON.
+func (res *GinkgoResult) UnmarshalJSON(data []byte) error {
+	type plain GinkgoResult
+	var decoded struct {
+		plain
+		Duration float64 `json:"duration"`
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*res = GinkgoResult(decoded.plain)
+	res.Duration = fromSeconds(decoded.Duration)
+	return nil
+}
+
+// fromSeconds turns a number of seconds into a duration rounded to the
+// millisecond.
+func fromSeconds(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds*1000)) * time.Millisecond
 }
 
 var CLI struct {
+	Init struct {
+		Force    bool `help:"Overwrite the config file if it already exists."`
+		Download bool `help:"Download the artifacts of the last build to check that you have access to the GCS bucket."`
+	} `cmd:"" help:"Creates the cache directory ~/.cache/prowdig and writes a starter config file ~/.config/prowdig/config.yaml containing the cert-manager profile, which you can copy to add your own profiles."`
 	Download struct {
 		Limit int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
 		Regex string `help:"Only download the files that match the given regex." kind:"regexflag"`
 	} `cmd:"" help:"Download the test artifacts from the GCS bucket into ~/cache/prowdig. Not all artifacts are downloaded, only the ones that match the regex given with --regex."`
+	Mirror struct {
+		Dest   string `help:"Where to copy the artifacts to. Can be a GCS bucket with an optional prefix, e.g. 'gs://my-archive-bucket/prowdig', or a local directory." required:""`
+		Limit  int    `help:"Limit the number of Prow builds for which we copy the artifacts." default:"20"`
+		Regex  string `help:"Only copy the files that match the given regex." kind:"regexflag"`
+		Output string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+	} `cmd:"" help:"Copies the artifacts of the last Prow builds to another GCS bucket or to a local directory, preserving the layout of the source bucket. Artifacts that were already copied are skipped. Useful to keep the artifacts around for longer than the retention policy of the source bucket."`
+	Sync struct {
+		Limit              int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		Output             string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		PubsubSubscription string `help:"Instead of listing the bucket, download the objects announced by the OBJECT_FINALIZE notifications of the bucket that are waiting in the given Pub/Sub subscription, e.g. 'projects/my-project/subscriptions/prowdig', and acknowledge them. The notifications must carry the JSON payload, e.g. 'gsutil notification create -t prowdig -f json -e OBJECT_FINALIZE gs://<bucket>'. --limit is ignored."`
+	} `cmd:"" help:"Lists the last Prow builds in the GCS bucket, downloads the artifacts that are missing or outdated in ~/.cache/prowdig, parses them into the index of the parsed results, and prints a summary of what changed. Running it twice in a row is harmless: the second run does not download anything. Meant to be run from cron before running the other commands with --no-download."`
 	Tests struct {
-		Output    string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
-		ParseLogs struct {
-			FileOrURL string `arg:"" help:"Log file or URL to be parsed for Ginkgo blocks."`
+		Output               string  `help:"Output format. Can be either 'text', 'json', 'junit', 'dot', or 'custom:<name>'. The 'junit' format is only supported by parse-logs. The 'dot' format is a Graphviz graph, e.g. to be rendered with 'dot -Tsvg', and is only supported by co-failures and clusters. The 'custom:<name>' format renders the data shown by 'json' with the Go template <name>.tmpl found in --templates-dir." short:"o" default:"text"`
+		Anonymize            bool    `help:"Scrub the namespace names, IP addresses, and URLs from the error messages and sources so that the output can be shared publicly."`
+		ErrorWidth           int     `help:"Maximum number of characters of the error messages shown in the text output. Longer error messages are truncated with an ellipsis, unless --wrap is given. The default, 0, shows the error messages in full."`
+		Wrap                 bool    `help:"Wrap the error messages that are longer than --error-width onto multiple lines instead of truncating them. Requires --error-width."`
+		MassFailureThreshold float64 `help:"A build is considered to be a mass-failure build when the fraction of its tests that failed or errored is greater or equal to this threshold." default:"0.3"`
+		ExcludeMassFailures  bool    `help:"Ignore the results of the mass-failure builds (see --mass-failure-threshold) in max-duration, most-failures, co-failures, and pick, so that a build in which the whole cluster fell over doesn't add a failure to nearly every test."`
+		NameRegex            string  `help:"Only consider the tests whose name matches the given regular expression, e.g. 'Vault' to focus on the Vault tests."`
+		ExcludeName          string  `help:"Ignore the tests whose name matches the given regular expression, e.g. 'Conformance' to exclude the conformance suite."`
+		FromJSON             string  `name:"from-json" help:"Read the test results from the given JSON file instead of parsing the cache, e.g. a file written with 'prowdig tests list -ojson'. Parsing the cache once and replaying several commands over the same file is much faster. Nothing is downloaded. The results are selected with --limit, --days, --since, --until, --sample, --job, and --build like the cached builds are." type:"path"`
+		Owner                string  `help:"Only consider the tests owned by the given owner, e.g. 'team-vault'. The owners are assigned to the tests with 'owners' in the profile, see ~/.config/prowdig/config.yaml."`
+		Wide                 bool    `help:"Show the job name, PR number, build number, and start time of the build of each test result in the text output of parse-logs and list."`
+		ParseLogs            struct {
+			FileOrURL string `arg:"" help:"Log file or URL to be parsed for Ginkgo blocks. The URL can also be the Spyglass page of a build in the Prow UI or a gcsweb page, in which case its build-log.txt is parsed."`
 		} `cmd:"" help:"Parse the Ginkgo failure blocks from a given file or URL."`
 
 		List struct {
@@ -176,35 +460,293 @@ var CLI struct {
 		} `cmd:"" help:"Lists the maximum 'passed' duration vs. maximum 'failed' duration of each test order by name. The logs are fetched from the bucket."`
 
 		MostFailures struct {
-			Limit      int  `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
-			NoDownload bool `help:"Only use the local cache, do not download anything from the GCS bucket."`
+			Limit       int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			NoDownload  bool   `help:"Only use the local cache, do not download anything from the GCS bucket."`
+			Sort        string `help:"How the tests are sorted. Can be 'count' (count of failures), 'rate' (failure rate), or 'lower-bound' (lower bound of the 95% confidence interval of the failure rate, which ranks a test failing 20 times out of 200 above one failing 1 time out of 3)." enum:"count,rate,lower-bound" default:"count"`
+			PerJob      bool   `help:"Show a matrix of the count of failures of each test in each job instead, which tells you whether a test only fails in some jobs. In the text output, the jobs are numbered and listed at the top; a dot means that the test passed every time in that job, and a dash means that the test didn't run in that job."`
+			MinFailures int    `help:"Ignore the tests that failed fewer times than this, e.g. to hide the tests with a single spurious failure. Doesn't apply to --per-job." default:"1"`
+			MinRuns     int    `help:"Ignore the tests that ran (passed or failed) fewer times than this. Doesn't apply to --per-job." default:"0"`
+			GroupBy     string `help:"Count the failures per value of the given dimension instead of per test, e.g. 'issuer' to tell which issuer type fails the most. The dimensions are extracted from the test names, see 'dimensions' in ~/.config/prowdig/config.yaml. The built-in dimensions are 'issuer' and 'variant' (Ingress or Gateway)."`
 		} `cmd:"" help:"Lists the test names that fail the most. Two numbers are shown: the count of passed and the count of failed tests. The last error message is shown right after the test name. The list is sorted in descending order by the count of failed tests."`
+
+		Pick struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		} `cmd:"" help:"Opens a fuzzy finder (fzf) over the names of the tests found in the last builds, and then shows the history of the selected test: how many times it passed and failed, and the details of each run. Requires fzf to be installed."`
+
+		Clusters struct {
+			Limit     int     `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			Threshold float64 `help:"Two error messages are put in the same cluster when their similarity is greater or equal to this threshold. The similarity goes from 0 (no word in common) to 1 (same words)." default:"0.8"`
+		} `cmd:"" help:"Groups the error messages of the 'failed' and 'error' tests into clusters of similar messages, e.g., messages that only differ by a random namespace suffix or a count. The clusters are sorted by the number of occurrences in descending order."`
+
+		Triage struct {
+			Limit    int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			Examples int `help:"Number of example builds shown for each bucket, the most recent first." default:"3"`
+		} `cmd:"" help:"Groups the error messages of the 'failed' and 'error' tests into buckets of identical messages once their variable parts are normalized: namespaces, IP addresses, URLs, UUIDs, timestamps, and generated resource names such as 'cert-manager-webhook-7d9f8b6c5-x2vqk'. Each bucket is shown with its count, the tests that failed with it, and example builds. The buckets are sorted by count in descending order."`
+
+		CoFailures struct {
+			Limit       int     `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			MinTogether int     `help:"Only show the pairs of tests that failed together in at least this many builds." default:"2"`
+			MinLift     float64 `help:"Only show the pairs of tests that failed together at least this many times more often than if they were failing independently of each other." default:"2"`
+		} `cmd:"" help:"Lists the pairs of tests that tend to fail in the same builds far more often than chance, which usually indicates a shared fixture or a shared infrastructure dependency. The pairs are sorted by the number of builds in which both tests failed."`
+
+		Flaky struct {
+			Limit           int           `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			RateWeight      float64       `help:"Weight of the failure rate in the flake score." default:"1"`
+			RecencyWeight   float64       `help:"Weight of the recency of the failures in the flake score." default:"1"`
+			SpreadWeight    float64       `help:"Weight of the spread of the failures across jobs and PRs in the flake score." default:"1"`
+			DiversityWeight float64       `help:"Weight of the diversity of the error messages in the flake score." default:"1"`
+			HalfLife        time.Duration `help:"A failure that is this old counts half as much as a failure in the most recent build when computing the recency." default:"168h"`
+		} `cmd:"" help:"Lists the tests that failed at least once, sorted by flake score. The flake score goes from 0 to 1 and is the weighted average of the failure rate, the recency of the failures, the spread of the failures across jobs and PRs, and the diversity of the error messages. The tests with the highest score are shown last."`
+
+		Flakes struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		} `cmd:"" help:"Lists the tests that both failed and passed within the same build, e.g. when retried with Ginkgo's --flake-attempts. The flake rate is the fraction of the builds in which the test ran that saw it flake. Unlike 'tests flaky', which guesses from the failures across builds, only the retries are looked at. The tests with the highest flake rate are shown last."`
+
+		Slowdowns struct {
+			Limit   int     `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"50"`
+			Recent  int     `help:"Number of most recent builds that are compared to the baseline made of the other builds." default:"5"`
+			Factor  float64 `help:"Report the tests for which the median duration in the recent builds is greater or equal to the median duration in the baseline builds multiplied by this factor." default:"1.5"`
+			MinRuns int     `help:"Ignore the tests that passed fewer times than this in the baseline builds, since their baseline isn't meaningful." default:"3"`
+		} `cmd:"" help:"Lists the tests that got slower: the median duration of their 'passed' runs in the most recent builds is compared to the median duration in the older builds. Useful to catch the tests that are about to hit the suite timeout. The tests that slowed down the most are shown last."`
+
+		Compare struct {
+			Limit     int     `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"100"`
+			Before    string  `help:"Time window of the builds compared against, e.g. '2024-05-01..2024-05-15' or '30d..14d'. Each side is an age, a date, or an RFC3339 time, see --since; a side can be left empty, e.g. '..2024-05-15'."`
+			After     string  `help:"Time window of the builds that are compared, e.g. '2024-05-16..2024-05-31' or '14d..'."`
+			BeforeJob string  `help:"Only use the builds of the jobs matching this regular expression as the 'before' builds, e.g. 'pull-cert-manager-e2e-v1-23'. Can be combined with --before."`
+			AfterJob  string  `help:"Only use the builds of the jobs matching this regular expression as the 'after' builds, e.g. 'pull-cert-manager-e2e-v1-24'. Can be combined with --after."`
+			Factor    float64 `help:"Report the tests for which the median duration of the 'after' builds is greater or equal to the median duration of the 'before' builds multiplied by this factor." default:"1.5"`
+			MinRuns   int     `help:"Ignore the tests that ran fewer times than this in the builds they are compared against, e.g. a test newly flaky needs this many runs without failure in the 'before' builds." default:"3"`
+		} `cmd:"" help:"Compares the test results of two sets of builds, either two time windows given with --before and --after or two jobs given with --before-job and --after-job, and lists the tests that got newly flaky (no failure before, failures after), the tests that got fixed (failures before, no failure after), and the tests that got slower. The newly flaky tests are shown last."`
+
+		Attribute struct {
+			PR            int     `arg:"" help:"Number of the PR whose failures are looked at."`
+			Limit         int     `help:"Limit the number of builds of the PR for which we fetch the logs in the GCS bucket." default:"20"`
+			BaselineLimit int     `help:"Limit the number of periodic builds that give the baseline failure rates." default:"100"`
+			MinRuns       int     `help:"A failure is only attributed when the test ran at least this many times in the periodic builds." default:"5"`
+			Threshold     float64 `help:"A failure is likely introduced by the PR when the chance of the test failing as many times as it did in the PR, given its baseline failure rate, is lower than this." default:"0.05"`
+			Repo          string  `help:"GitHub repository of the PR, e.g. 'cert-manager/cert-manager'. Defaults to the GitHub repository of the profile."`
+			Token         string  `help:"GitHub token used to fetch the files changed by the PR. The failures whose location (errLoc) is in a changed file or in the package of a changed file are flagged. Without a token, the changed files aren't looked at." env:"GITHUB_TOKEN"`
+		} `cmd:"" help:"Tells, for each test that failed in the presubmits of the given PR, whether the failure is likely a pre-existing flake or likely introduced by the PR. The failures of the PR are compared to the failure rate of the test in the recent periodic builds: the chance of seeing at least as many failures as in the PR given that rate is shown along with the counts. With --token, the failures located in the files changed by the PR, or in their packages, are flagged as well. The presubmits are looked up under --pr-prefixes and the periodics under --ci-prefixes. The failures most likely introduced by the PR are shown last."`
+
+		MassFailures struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		} `cmd:"" help:"Lists the mass-failure builds, i.e., the builds in which a fraction of the tests greater or equal to --mass-failure-threshold failed, usually because the cluster fell over. The most common error cluster of each build is shown."`
+
+		Summary struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		} `cmd:"" help:"Shows the high-level numbers for the last builds: number of builds analyzed, number of test runs, count of passed, failed, and errored tests, failure rate, number of distinct failing tests, and the most common error."`
+
+		FileIssues struct {
+			Limit  int      `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			Repo   string   `help:"GitHub repository in which the issues are filed, e.g. 'cert-manager/cert-manager'. Defaults to the GitHub repository of the profile."`
+			Top    int      `help:"Number of most-failing tests for which an issue is created or updated." default:"10"`
+			Label  []string `help:"Label added to the created issues, e.g. 'kind/flake'. Can be repeated."`
+			DryRun bool     `help:"Only show which issues would be created or updated. The existing issues are still looked up."`
+			Token  string   `help:"GitHub token used to look up, create, and update the issues. Required unless --dry-run is given." env:"GITHUB_TOKEN"`
+		} `cmd:"" help:"Creates a GitHub issue for each of the tests that fail the most, or updates the issue when it already exists. The issue contains the failure counts, the last error, and links to the builds in which the test failed. The existing issues are found using a slug of the test name that is written in the issue body, which means that the issues can be renamed."`
 	} `cmd:"" help:"Everything related to individual test cases."`
 	Builds struct {
-		Output string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
-		List   struct {
+		Output    string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		Anonymize bool   `help:"Scrub the namespace names, IP addresses, and URLs from the error messages and URLs so that the output can be shared publicly."`
+		List      struct {
 			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
 		} `cmd:"" help:"Lists all the builds."`
+		MostFailures struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"100"`
+		} `cmd:"" help:"Lists, for each job, the number of passed and failed builds, the failure rate, the average duration of the builds, and the most common description of the failed builds as given by Prow. The jobs with the highest failure rate are shown last."`
+		Durations struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		} `cmd:"" help:"Shows, for each job, how long its builds waited to be scheduled and how long they ran. The wait is the time between the creation of the ProwJob and the time its pod was scheduled (pendingTime in prowjob.json). A long wait is often mistaken for slow tests. The jobs that waited the longest are shown last."`
+		Retests struct {
+			Limit int `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"100"`
+		} `cmd:"" help:"Shows, for each PR, how many times its presubmits had to be re-run (e.g., with /retest) and which tests failed in the builds that were re-run, followed by the number of re-runs and the CI time they cost for each month. A re-run is a failed build followed by another build of the same job on the same commit. The presubmits are looked up under --pr-prefixes. The PRs that were re-run the most are shown last."`
+		Storms struct {
+			Limit      int           `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"100"`
+			MaxRetests int           `help:"A job of a PR that was re-run more than this many times within --window is a storm." default:"3"`
+			Window     time.Duration `help:"The time window in which the re-runs are counted." default:"24h"`
+		} `cmd:"" help:"Shows the jobs of a PR that were re-run (e.g., with /retest) more than --max-retests times within --window, along with the tests that failed in the builds that were re-run. These PRs are the ones whose authors are likely to be fed up with the flakes. A re-run is a failed build followed by another build of the same job on the same commit. The presubmits are looked up under --pr-prefixes. The storms with the most re-runs are shown last."`
+		Analyze struct {
+			URL string `arg:"" help:"Link to the build, i.e. its Spyglass page in the Prow UI, e.g. https://prow.build-infra.jetstack.net/view/gs/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688, or a gcsweb page."`
+		} `cmd:"" help:"Downloads the build-log.txt, junit, and prowjob.json files of a single build and shows everything about it: the status of the build, the count of passed and failed tests, the failed tests with their error messages, and the failures grouped by error message (see 'tests triage'). The bucket is taken from the link, which means that the link can point to any bucket."`
 	} `cmd:"" help:"Everything related to jobs."`
-	NoDownload bool   `help:"If a command is meant to fetch from GCS, only use the local cache, do not download anything."`
-	Color      string `help:"Change the coloring behavior. Can be one of auto, never, or always." enum:"auto,never,always" default:"auto"`
-	Debug      bool   `help:"Print debug information."`
+	Jobs struct {
+		Coverage struct {
+			Limit  int    `help:"Limit the number of Prow builds looked at for each prefix." default:"20"`
+			Output string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		} `cmd:"" help:"Shows, for each prefix, how many builds exist in the GCS bucket, how many of them are in ~/.cache/prowdig, and how many of them gave at least one test result. Tells whether 'no failures' means that the CI is green or that the data is missing. With --no-download, the GCS bucket isn't listed and the number of builds in the bucket is unknown."`
+		Suites struct {
+			Limit  int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			Output string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		} `cmd:"" help:"Shows, for each job, how long the Ginkgo suites took according to the 'Ran X of Y Specs in Z seconds' lines of build-log.txt, compared to the sum of the durations of the specs found in the junit files. The difference is the time spent outside of the specs, e.g. in the suite setup and teardown. When the specs run in parallel, the sum of the durations is bigger than the suite duration and the overhead is negative. The jobs with the biggest overhead are shown last."`
+		Health struct {
+			Limit         int     `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			Recent        int     `help:"Number of most recent builds of each job whose median duration is compared to the median duration of the older builds to compute the duration trend." default:"5"`
+			FailureWeight float64 `help:"Weight of the build failure rate in the health score." default:"1"`
+			InfraWeight   float64 `help:"Weight of the infra-failure rate in the health score." default:"1"`
+			TrendWeight   float64 `help:"Weight of the duration trend in the health score." default:"1"`
+			FlakeWeight   float64 `help:"Weight of the flake rate in the health score." default:"1"`
+			Output        string  `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		} `cmd:"" help:"Shows a health score per job, from 0 (unhealthy) to 100 (healthy). It combines four rates, each between 0 and 1: the fraction of failed builds; the fraction of infra failures, i.e. failed builds in which no test failed or mass-failure builds; the duration trend, i.e. how much slower the recent builds are, 1 meaning twice as slow or more; and the fraction of builds in which a test failed and then passed when retried. The score is 100 minus the weighted average of the four rates. The least healthy jobs are shown last."`
+	} `cmd:"" help:"Everything related to the jobs as a whole."`
+	Errors struct {
+		History struct {
+			Fingerprint string  `arg:"" help:"Fingerprint of the error cluster, as shown by 'prowdig tests clusters'."`
+			Limit       int     `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket. Raise it (or use --days) to look further back." default:"20"`
+			Threshold   float64 `help:"An error message belongs to the cluster when its similarity with the error message that has the given fingerprint is greater or equal to this threshold. Same as the --threshold of 'prowdig tests clusters'." default:"0.8"`
+			Output      string  `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		} `cmd:"" help:"Shows when the errors of a cluster happened: when they were seen first and last, how many times per day, and which jobs and tests they affect. Useful to confirm that a fix made an error go away."`
+	} `cmd:"" help:"Everything related to the error messages."`
+	Cache struct {
+		Export struct {
+			File string `arg:"" help:"Path to the tarball to be written. The compression is picked from the extension: .tar.zst, .tar.gz (or .tgz), or .tar."`
+		} `cmd:"" help:"Exports the content of ~/.cache/prowdig as a tarball, e.g. so that a teammate without access to the GCS bucket can analyze the same dataset."`
+		Import struct {
+			File string `arg:"" help:"Path to a tarball previously written with 'prowdig cache export'. The compression is picked from the extension."`
+		} `cmd:"" help:"Imports a tarball previously written with 'prowdig cache export' into ~/.cache/prowdig. Files already present in the cache are overwritten."`
+		Info struct {
+			Output string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		} `cmd:"" help:"Shows the number of builds and artifacts in the cache, the space they take on disk, and how much space is saved by storing the identical artifacts once."`
+		Size struct {
+		} `cmd:"" help:"Prints the space that the cache takes on disk, e.g. to be used in scripts. See 'prowdig cache info' for more details."`
+		Prune struct {
+			OlderThan string `help:"Remove the builds that started before this age, e.g. '30d' or '12h'." required:""`
+		} `cmd:"" help:"Removes the builds that are older than --older-than from the cache. Whole builds are removed so that the analysis commands never see half of a build. See also --max-cache-size."`
+		Clear struct {
+		} `cmd:"" help:"Removes everything from the cache directory. The next command will download the builds again."`
+		Migrate struct {
+		} `cmd:"" help:"Upgrades the cache directory to the layout used by this version of prowdig, e.g. so that the identical artifacts are stored once, instead of downloading everything again. The layout is recorded in the file .cache.json of the cache directory. Does nothing when the cache is already up to date."`
+	} `cmd:"" help:"Everything related to the cache directory ~/.cache/prowdig."`
+	Export struct {
+		Series struct {
+			Limit int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+			Test  string `help:"Only export the points of the test with this exact name. By default, the points of all the tests are exported."`
+		} `cmd:"" help:"Prints a JSON array of points, one per test run, with the fields name, timestamp, status, duration (in seconds), build, and job. The points are sorted by timestamp. Meant for custom analysis with pandas or R."`
+	} `cmd:"" help:"Everything related to exporting the raw data."`
+	Snapshot struct {
+		Dir   string `help:"Directory in which the snapshot is written. The file name is the current date, e.g. 2022-07-01T210340Z.json." default:"." type:"path"`
+		Limit int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+	} `cmd:"" help:"Writes a dated JSON dump of the stats computed over the last builds: the summary and the per-test counts. Two snapshots can then be compared with 'prowdig diff'."`
+	Diff struct {
+		Old    string `arg:"" help:"Snapshot taken first." type:"existingfile"`
+		New    string `arg:"" help:"Snapshot taken last." type:"existingfile"`
+		Output string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+	} `cmd:"" help:"Compares two snapshots written by 'prowdig snapshot' and lists the tests for which the failure rate changed, the tests that got worse being shown last."`
+	Report struct {
+		AllProfiles bool   `help:"Report on every profile: the built-in cert-manager profile and the profiles defined in ~/.config/prowdig/config.yaml. By default, only the current profile is reported on."`
+		Limit       int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket, for each profile." default:"20"`
+		Output      string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+	} `cmd:"" help:"Downloads the artifacts of the last builds and shows the numbers of 'prowdig tests summary' for one or several profiles at once, with one section per profile. The profiles are processed concurrently, each in its own prowdig process. Useful to watch the CI health of several projects."`
+	ParseErrors struct {
+		Limit              int    `help:"Limit the number of Prow builds for which we fetch the logs in the GCS bucket." default:"20"`
+		Output             string `help:"Output format. Can be either 'text' or 'json'." short:"o" default:"text" enum:"text,json"`
+		PubsubSubscription string `help:"Instead of listing the bucket, download the objects announced by the OBJECT_FINALIZE notifications of the bucket that are waiting in the given Pub/Sub subscription, e.g. 'projects/my-project/subscriptions/prowdig', and acknowledge them. The notifications must carry the JSON payload, e.g. 'gsutil notification create -t prowdig -f json -e OBJECT_FINALIZE gs://<bucket>'. --limit is ignored."`
+	} `cmd:"" help:"Lists the junit and build-log.txt files of the last builds that failed to parse, and why. These files are skipped by the other commands, unless --strict is given."`
+	Completion struct {
+		Shell string `arg:"" help:"Shell for which the completion script is printed. Can be either 'bash' or 'zsh'." enum:"bash,zsh"`
+	} `cmd:"" help:"Prints the shell completion script. The values of --name and --job are completed using the test and job names found in ~/.cache/prowdig. To enable it, add 'source <(prowdig completion bash)' to your ~/.bashrc, or 'source <(prowdig completion zsh)' to your ~/.zshrc."`
+	Complete struct {
+		Kind  string `arg:"" enum:"names,jobs"`
+		Limit int    `default:"20"`
+	} `cmd:"" hidden:"" help:"Prints the test or job names found in the cache, one per line. Used by the completion script."`
+	Days            int      `help:"Only consider the builds that started in the last N days, both when downloading and when analyzing. The --limit of each command still caps the number of builds, so raise it when the jobs run often." xor:"since"`
+	Since           string   `help:"Only consider the builds that started after this time, both when downloading and when analyzing. Either an age such as '7d' or '12h', a date such as '2024-05-01' (UTC), or an RFC3339 time such as '2024-05-01T10:00:00Z'." xor:"since"`
+	Until           string   `help:"Only consider the builds that started before this time. Same format as --since. A date includes the whole day, e.g. --since=2024-05-01 --until=2024-05-07 covers a week."`
+	Build           []string `help:"Only download and analyze the build with this build ID, e.g. 1542891685103538176. A link to the build, such as its Spyglass page in the Prow UI or a gcsweb page, can be given instead of the build ID. Can be repeated, e.g. to analyze all the builds of a PR in isolation. The --limit of each command still caps the number of builds."`
+	BuildsFile      string   `help:"Only download and analyze the builds whose build IDs or links are listed in this file, one per line. The empty lines and the lines starting with # are ignored. Can be combined with --build." type:"path"`
+	Job             string   `help:"Only download and analyze the builds of the jobs whose name matches this regular expression, e.g. 'pull-cert-manager-e2e-v1-2[34]'. Use ^ and $ to match the whole job name."`
+	NoDownload      bool     `help:"If a command is meant to fetch from GCS, only use the local cache, do not download anything."`
+	Config          string   `help:"Path to the config file in which the profiles are defined, instead of ~/.config/prowdig/config.yaml." type:"path"`
+	TemplatesDir    string   `help:"Directory containing the templates used with --output=custom:<name>, instead of the 'templates' directory next to the config file, e.g. ~/.config/prowdig/templates." type:"path"`
+	Profile         string   `help:"Use the bucket, prefixes, Deck URL, and GitHub repository of the given profile. The profiles are defined in ~/.config/prowdig/config.yaml. Each profile gets its own cache directory under ~/.cache/prowdig. When no profile is given, the built-in cert-manager settings are used."`
+	OutputFile      string   `help:"Write the output to the given file instead of the standard output. The file is written atomically: it is either fully written or left untouched, even if prowdig is killed halfway through." type:"path"`
+	ProwConfig      string   `help:"Location of the Prow config.yaml containing the job definitions, e.g. 'gs://my-bucket/config.yaml', 'https://raw.githubusercontent.com/org/repo/master/config.yaml', or a local path. The bucket and the prefixes are derived from the presubmits, postsubmits, and periodics found in it instead of being listed by hand."`
+	Bucket          string   `help:"Name of the GCS bucket in which Prow uploads the artifacts, e.g. 'kubernetes-jenkins'. Takes precedence over the bucket of the profile and of --prow-config. Each bucket gets its own cache directory." xor:"bucket"`
+	Storage         string   `help:"Location of the bucket in which Prow uploads the artifacts, either 'gs://<bucket>' for GCS, 's3://<bucket>' for S3 and the S3-compatible stores such as MinIO, or the URL of the bucket on an HTTP artifact server such as gcsweb, e.g. 'https://gcsweb.k8s.io/gcs/kubernetes-jenkins', when the bucket itself isn't accessible, or the URL of the job history of the bucket on Deck, e.g. 'https://prow.k8s.io/job-history/gs/kubernetes-jenkins', when only Deck is accessible, in which case --ci-prefixes must be the prefixes of jobs and the results come from the build-log.txt files only. The builds still running are skipped with both. The S3 credentials and region are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, and AWS_REGION; without credentials, the requests are anonymous. Takes precedence over the bucket of the profile and of --prow-config." xor:"bucket"`
+	S3Endpoint      string   `name:"s3-endpoint" help:"URL of the S3-compatible store, e.g. 'http://minio.example.com:9000'. Defaults to AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, and then to the AWS endpoint of the region. Takes precedence over the 's3Endpoint' of the profile."`
+	PRPrefixes      []string `name:"pr-prefixes" help:"Comma-separated prefixes under which the presubmit builds are stored in the bucket, e.g. 'pr-logs/pull/kubernetes_kubernetes'. Takes precedence over the prefixes of the profile and of --prow-config."`
+	CIPrefixes      []string `name:"ci-prefixes" help:"Comma-separated prefixes under which the periodic builds are stored in the bucket, e.g. 'logs/ci-kubernetes-e2e-gci-gce'. Takes precedence over the prefixes of the profile and of --prow-config."`
+	CacheDir        string   `help:"Directory in which the artifacts are cached instead of ~/.cache/prowdig. Useful when running prowdig as a Kubernetes CronJob, e.g. with an emptyDir volume." env:"PROWDIG_CACHE_DIR" type:"path"`
+	WaitForLock     bool     `help:"When another prowdig process is using the cache directory, e.g. a cron job running 'prowdig sync', wait for it to finish instead of failing."`
+	ReadOnlyCache   bool     `help:"Never write to the cache directory, e.g. when --cache-dir points to a shared cache mounted read-only that a nightly 'prowdig sync' keeps up to date. Implies --no-download."`
+	TailBytes       string   `help:"Only download the last bytes of the build-log.txt files that are bigger than this size, e.g. '5MB'. Most failures are printed at the end of the logs, which makes triaging the builds with huge logs much faster. The truncated files start with a line that tells so, and the line numbers of the links are relative to the downloaded part. Run 'prowdig sync' without this flag to download the whole files. By default, the whole files are downloaded."`
+	MaxCacheSize    string   `help:"Maximum size of the cache directory, e.g. '10GB' or '500MiB'. When the cache grows bigger after a download, the builds that were the least recently downloaded or found up to date are removed from the cache. The builds of the current download are never removed. Can also be set with 'maxCacheSize' in ~/.config/prowdig/config.yaml. By default, the cache is not limited."`
+	Links           bool     `help:"Append to each row of the text output the URL of the underlying evidence: the storage.googleapis.com URL of the build-log.txt or junit file for the rows about tests and errors, and the Spyglass URL for the rows about builds."`
+	NoProgress      bool     `help:"Do not show the progress bars. The progress bars are written to the standard error, and are already hidden when the standard error is not a terminal."`
+	Strict          bool     `help:"Fail as soon as a junit or build-log.txt file fails to parse instead of skipping it. Useful in CI to check that the parser still understands the logs." xor:"parse-mode"`
+	Lenient         bool     `help:"Skip the junit and build-log.txt files that fail to parse and print a warning for each of them. This is the default." xor:"parse-mode"`
+	AllowDuplicates bool     `help:"Keep the test results found twice, e.g. when a build is stored under two aliased prefixes or when two junit files of a build contain the same test case. By default, the results that have the same job, build, test name, and attempt are counted once, and a failure found both in a junit file and in build-log.txt is counted once with the richer error message of the two."`
+	Sample          string   `help:"Only analyze a random sample of the builds, e.g. '20%', which is faster when --limit or --days cover many builds. The same builds are picked each time unless --seed is changed. The counts shown by 'tests summary' and 'tests most-failures' are scaled up to estimate the counts of all the builds; the rates are left as-is."`
+	Seed            int64    `help:"Seed used to pick the builds with --sample. Change it to analyze another sample." default:"0"`
+	Explain         bool     `help:"Instead of analyzing the builds, print the prefixes, builds, and junit and build-log.txt files that would be read from the cache, along with their sizes, and exit. Useful to check what --limit, --days, and --sample select. Implies --no-download."`
+	NoIndex         bool     `help:"Parse every junit and build-log.txt file again instead of using the results stored in the index of the cache directory. The index is kept up to date automatically: a file is parsed again as soon as its checksum changes."`
+	NoPager         bool     `help:"Do not pipe the output into $PAGER. By default, the output is piped into $PAGER (or 'less' if unset) when the standard output is a terminal."`
+	AbsoluteTime    bool     `help:"Show the timestamps in the RFC3339 format (e.g., 2022-07-01T21:03:40Z) instead of the time relative to now (e.g., 2d ago). The JSON output always uses the RFC3339 format."`
+	DurationFormat  string   `help:"How the durations are displayed in the text output. Can be 'human' (e.g., 5m1s), 'seconds' (e.g., 301), or 'ms' (e.g., 301000). The JSON output always uses seconds." enum:"human,seconds,ms" default:"human"`
+	Timezone        string   `help:"Timezone used for displaying the timestamps with --absolute-time, e.g. 'Europe/Paris' or 'Local'." default:"UTC"`
+	Plain           bool     `help:"Force a fully machine-safe output: no colors, no emojis, no progress bars, and no pager. Takes precedence over --color."`
+	Color           string   `help:"Change the coloring behavior. Can be one of auto, never, or always." enum:"auto,never,always" default:"auto"`
+	Debug           bool     `help:"Print debug information."`
 }
 
 func main() {
 	kongctx := kong.Parse(&CLI,
-		kong.Description("Prowdig copies the logs from the Google Storage buckets in which the cert-manager logs are contained to ~/.cache/prowdig and then tells you things about the Prow jobs, e.g., the most failing jobs. The folder ~/.cache/prowdig is not configurable for now. It may grow bigger than 10GB if you set a high --limit."),
+		kong.Description("Prowdig copies the logs from the bucket in which Prow uploads the artifacts to ~/.cache/prowdig and then tells you things about the Prow jobs, e.g., the most failing jobs. By default, the cert-manager logs are read from Google Storage; other GCS buckets can be picked with --bucket, and S3 buckets or HTTP artifact servers such as gcsweb with --storage. The cache directory can be changed with --cache-dir or PROWDIG_CACHE_DIR. It may grow bigger than 10GB if you set a high --limit."),
+
+		// Each flag can also be set with an environment variable, e.g.
+		// --no-download with PROWDIG_NO_DOWNLOAD=true, so that prowdig can be
+		// configured in CI pipelines and containers without long command
+		// lines. The variable is shown in the help of each flag.
+		kong.DefaultEnvars("PROWDIG"),
 
 		kong.ValueFormatter(func(value *kong.Value) string {
 			switch value.Name {
 			case "regex":
 				value.Default = isToBeDownloaded.String()
-				return value.Help + " Default: " + value.Default + "."
+				return kong.DefaultHelpValueFormatter(value) + " Default: " + value.Default + "."
 			}
-			return value.Help
+			return kong.DefaultHelpValueFormatter(value)
 		}),
 	)
 
+	// The output file must be set up before the colors so that "auto" doesn't
+	// pick colors when writing to a file.
+	if CLI.OutputFile != "" {
+		out, err := createAtomic(CLI.OutputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		onExit(out.Discard)
+		os.Stdout = out.File
+
+		// When prowdig gets killed, we don't want to leave the temporary file
+		// behind. The output file itself is never touched.
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+
+			// Like the shells do, exit with 128 + the signal number, e.g.
+			// 130 on SIGINT and 143 on SIGTERM.
+			code := 1
+			if sig, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(sig)
+			}
+			exit(code)
+		}()
+
+		// This defer is the first one to be registered, which means it runs
+		// after all the other defers (e.g., the tabwriter flushes).
+		defer func() {
+			err := out.Commit()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		}()
+	}
+
 	switch CLI.Color {
 	case "auto":
 		color.NoColor = os.Getenv("TERM") == "dumb" || !isatty.IsTerminal(os.Stdout.Fd())
@@ -213,732 +755,3728 @@ func main() {
 	case "always":
 		color.NoColor = false
 	}
+	if CLI.Plain {
+		color.NoColor = true
+	}
+	asciiIcons = CLI.Plain || os.Getenv("TERM") == "dumb" || !isatty.IsTerminal(os.Stdout.Fd())
 
-	switch kongctx.Command() {
-	case "download":
-		if CLI.NoDownload {
-			fmt.Fprint(os.Stderr, "error: cannot use --no-download with the download command.\n")
-			os.Exit(1)
+	loc, err := time.LoadLocation(CLI.Timezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: --timezone: %v\n", err)
+		exit(1)
+	}
+	timezone = loc
+	massFailureThreshold = CLI.Tests.MassFailureThreshold
+	strict = CLI.Strict
+	anonymized = CLI.Tests.Anonymize || CLI.Builds.Anonymize
+	if CLI.Sample != "" {
+		sampleFraction, err = parseSample(CLI.Sample)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --sample: %v\n", err)
+			exit(1)
 		}
-
-		if CLI.Download.Regex == "" {
-			CLI.Download.Regex = isToBeDownloaded.String()
+		sampleSeed = CLI.Seed
+	}
+	allowDuplicates = CLI.AllowDuplicates
+	noIndex = CLI.NoIndex
+
+	// Only the commands that analyze the cached artifacts can explain what
+	// they would read.
+	if CLI.Explain {
+		switch cmd := kongctx.Command(); {
+		case cmd == "tests parse-logs <file-or-url>":
+		case strings.HasPrefix(cmd, "tests "), cmd == "export series", cmd == "snapshot", cmd == "errors history <fingerprint>", cmd == "parse-errors":
+			explain = true
+			CLI.NoDownload = true
 		}
-
-		regex, err := regexp.Compile(CLI.Download.Regex)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: --regex '%s' is an invalid regular expression: %v\n", CLI.Download.Regex, err)
-			os.Exit(1)
+		if !explain {
+			fmt.Fprintf(os.Stderr, "error: --explain: '%s' doesn't analyze the cached artifacts\n", kongctx.Command())
+			exit(1)
 		}
-
-		err = downloadCIBuildArtifactsToCache(CLI.Download.Limit, regex)
+	}
+	if CLI.Days > 0 {
+		since = time.Now().AddDate(0, 0, -CLI.Days)
+	}
+	if CLI.Since != "" {
+		var err error
+		since, err = parseWhen(CLI.Since, time.Now(), false)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "error: --since: %v\n", err)
+			exit(1)
 		}
-
-	case "tests parse-logs <file-or-url>":
-		var bytes []byte
+	}
+	if CLI.Until != "" {
 		var err error
-		isURL := strings.HasPrefix(CLI.Tests.ParseLogs.FileOrURL, "http://") || strings.HasPrefix(CLI.Tests.ParseLogs.FileOrURL, "https://")
-		if isURL {
-			content, err := http.Get(CLI.Tests.ParseLogs.FileOrURL)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "fetching URL: %v\n", err)
-			}
-			bytes, err = ioutil.ReadAll(content.Body)
+		until, err = parseWhen(CLI.Until, time.Now(), true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --until: %v\n", err)
+			exit(1)
+		}
+		if !since.IsZero() && !since.Before(until) {
+			fmt.Fprintf(os.Stderr, "error: --until: %s is not after the start of the window, %s\n", until.Format(time.RFC3339), since.Format(time.RFC3339))
+			exit(1)
+		}
+	}
+	if len(CLI.Build) > 0 || CLI.BuildsFile != "" {
+		var builds []int
+		for _, arg := range CLI.Build {
+			build, err := parseBuildArg(arg)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "reading HTTP response: %v\n", err)
-			}
-
-			if CLI.Debug {
-				fmt.Fprintf(os.Stderr, "debug: downloaded %s\n", ByteCountSI(int64(len(bytes))))
-			}
-
-			if content.StatusCode != 200 {
-				fmt.Fprintf(os.Stderr, "fetching URL: %s: %v\n", content.Status, string(bytes))
+				fmt.Fprintf(os.Stderr, "error: --build: %v\n", err)
+				exit(1)
 			}
-		} else {
-			bytes, err = ioutil.ReadFile(CLI.Tests.ParseLogs.FileOrURL)
+			builds = append(builds, build)
+		}
+		if CLI.BuildsFile != "" {
+			fromFile, err := readBuildsFile(CLI.BuildsFile)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
-				os.Exit(1)
+				fmt.Fprintf(os.Stderr, "error: --builds-file: %v\n", err)
+				exit(1)
 			}
+			builds = append(builds, fromFile...)
 		}
-
-		blocks, err := parseBuildLog(bytes)
+		onlyBuilds = make(map[int]struct{})
+		for _, build := range builds {
+			onlyBuilds[build] = struct{}{}
+		}
+	}
+	if CLI.Job != "" {
+		var err error
+		onlyJobs, err = regexp.Compile(CLI.Job)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: while parsing %s: %v\n", CLI.Tests.ParseLogs.FileOrURL, err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "error: --job '%s' is an invalid regular expression: %v\n", CLI.Job, err)
+			exit(1)
 		}
+	}
 
-		// We don't use the syntax 'var results' so that the encoded JSON shows
-		// "[]" instead of "null".
-		results := []GinkgoResult{}
-		for _, block := range blocks {
-			parsed, err := parseGinkgoBlock(block)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: parsing one of the ginkgo blocks: %v\n", err)
-			}
-
-			source := CLI.Tests.ParseLogs.FileOrURL + ":" + strconv.Itoa(block.line)
-			if isURL {
-				source = CLI.Tests.ParseLogs.FileOrURL + "#line=" + strconv.Itoa(block.line)
-			}
+	// The progress bars are written to stderr, which is often redirected to a
+	// log file in CI. We don't want the log files to be filled with the
+	// progress bar escape codes.
+	if CLI.Plain || CLI.NoProgress || !isatty.IsTerminal(os.Stderr.Fd()) {
+		progressOut = io.Discard
+	}
 
-			results = append(results, GinkgoResult{
-				Name:     parsed.name,
-				Status:   parsed.status,
-				Duration: parsed.duration,
-				Err:      parsed.errStr,
-				ErrLoc:   parsed.errLoc,
-				Source:   source,
-				Job:      "",
-				PR:       0,
-				Build:    0,
-			})
+	// The pager must be started after the colors have been decided, since the
+	// pager is not a terminal. It isn't started for "tests pick", whose fuzzy
+	// finder would otherwise fight with the pager over the terminal.
+	if !CLI.NoPager && !CLI.Plain && CLI.OutputFile == "" && isatty.IsTerminal(os.Stdout.Fd()) && kongctx.Command() != "tests pick" {
+		wait, err := startPager()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v, use --no-pager to hide this warning\n", err)
+		} else {
+			onExit(wait)
+			defer wait()
 		}
+	}
 
-		sort.Slice(results, func(i, j int) bool {
-			return strings.Compare(results[i].Name, results[j].Name) < 0
-		})
-
-		switch CLI.Tests.Output {
-		case "json":
-			err = json.NewEncoder(os.Stdout).Encode(results)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
-				os.Exit(1)
-			}
-		case "text":
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
-			defer w.Flush()
+	if CLI.CacheDir != "" {
+		cacheRoot = strings.TrimSuffix(CLI.CacheDir, "/")
+		cacheDir = cacheRoot + "/" + bucketName
+	}
 
-			for _, res := range results {
-				duration := (time.Duration(res.Duration) * time.Second).String()
-				switch res.Status {
-				case statusPassed:
-					fmt.Fprintf(w, "✅ %s\t%s\n", green(duration), res.Name)
-				case statusFailed:
-					fmt.Fprintf(w, "❌ %s\t%s: %s\n", red(duration), res.Name, gray(res.Err))
-				case statusError:
-					fmt.Fprintf(w, "💣️ %s\t%s: %s\n", blue(duration), res.Name, gray(res.Err))
-				default:
-					panic("developer mistake: unknown status: " + res.Status)
-				}
-			}
+	// A read-only cache is kept up to date by someone else, e.g. by a nightly
+	// 'prowdig sync', so nothing is ever downloaded to it. The mirror command
+	// doesn't go through the cache.
+	if CLI.ReadOnlyCache {
+		switch kongctx.Command() {
+		case "init", "download", "sync", "cache import <file>", "cache prune", "cache clear", "cache migrate":
+			fmt.Fprintf(os.Stderr, "error: --read-only-cache: '%s' needs to write to the cache directory\n", kongctx.Command())
+			exit(1)
+		case "mirror":
 		default:
-			fmt.Fprintf(os.Stderr, "developer mistake, defined in kong's enum but not handled: %q\n", CLI.Tests.Output)
-			os.Exit(1)
+			CLI.NoDownload = true
 		}
+	}
 
-	case "tests max-duration":
-		if !CLI.NoDownload {
-			err := downloadPRBuildArtifactsToCache(CLI.Tests.List.Limit, isToBeDownloaded)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
-				os.Exit(1)
-			}
-		}
+	if CLI.Config != "" {
+		configFile = CLI.Config
 
-		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.MaxDuration.Limit)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
-			os.Exit(1)
+		// Unlike the default config file, the one given with --config must
+		// exist, except when "init" is about to write it.
+		_, err := os.Stat(configFile)
+		if err != nil && kongctx.Command() != "init" {
+			fmt.Fprintf(os.Stderr, "error: --config: %v\n", err)
+			exit(1)
 		}
+	}
 
-		stats := computeStatsMaxDuration(results)
-		switch CLI.Tests.Output {
-		case "json":
-			if stats == nil {
-				// Force the encoded JSON to show "[]" instead of "null".
-				stats = []StatsMaxDuration{}
-			}
-			err = json.NewEncoder(os.Stdout).Encode(stats)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			}
-		case "text":
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
-			defer w.Flush()
+	config, err := loadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		exit(1)
+	}
 
-			for _, stat := range stats {
-				fmt.Fprintf(w, "%s\t%s\t%s\n",
-					green((time.Duration(stat.MaxDurationPassed) * time.Second).String()),
-					red((time.Duration(stat.MaxDurationFailed) * time.Second).String()),
-					stat.Name,
-				)
-			}
-		}
+	if CLI.Profile != "" {
+		err = useProfile(config, CLI.Profile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
+	}
 
-	case "tests most-failures":
-		if !CLI.NoDownload {
-			err := downloadPRBuildArtifactsToCache(CLI.Tests.MostFailures.Limit, isToBeDownloaded)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
-				os.Exit(1)
-			}
+	if CLI.MaxCacheSize == "" {
+		CLI.MaxCacheSize = config.MaxCacheSize
+	}
+	if CLI.MaxCacheSize != "" {
+		maxCacheSize, err = parseSize(CLI.MaxCacheSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --max-cache-size: %v\n", err)
+			exit(1)
 		}
+	}
 
-		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.MostFailures.Limit)
+	if CLI.TailBytes != "" {
+		tailBytes, err = parseSize(CLI.TailBytes)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "error: --tail-bytes: %v\n", err)
+			exit(1)
 		}
+	}
 
-		stats := computeStatsMostFailures(results)
-		switch CLI.Tests.Output {
-		case "json":
-			if stats == nil {
-				// Force the encoded JSON to show "[]" instead of "null".
-				stats = []StatsMostFailures{}
-			}
-			err = json.NewEncoder(os.Stdout).Encode(stats)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			}
-		case "text":
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
-			defer w.Flush()
-
-			for _, stat := range stats {
-				lastErr := ""
-				if len(stat.Errors) > 0 {
-					lastErr = stat.Errors[len(stat.Errors)-1].Err
-				}
-				fmt.Fprintf(w, "%s\t%s\t%s: %s\n",
-					green(stat.CountPassed),
-					red(stat.CountFailed),
-					stat.Name,
-					gray(lastErr),
-				)
-			}
+	if CLI.ProwConfig != "" {
+		bytes, err := readLocation(CLI.ProwConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --prow-config: %v\n", err)
+			exit(1)
 		}
+		var prowConfig ProwConfig
+		err = yaml.Unmarshal(bytes, &prowConfig)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "error: --prow-config: %s: %v\n", CLI.ProwConfig, err)
+			exit(1)
 		}
 
-	case "tests list":
-		if !CLI.NoDownload {
-			err := downloadPRBuildArtifactsToCache(CLI.Tests.List.Limit, isToBeDownloaded)
+		bucket, prPrefixes, ciPrefixes := prowConfig.prefixes()
+		if bucket != "" {
+			scheme, name, err := parseBucket(bucket)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
-				os.Exit(1)
+				fmt.Fprintf(os.Stderr, "error: --prow-config: %v\n", err)
+				exit(1)
+			}
+			storageScheme, artifactsURL = scheme, strings.TrimSuffix(bucket, "/")
+			if name != bucketName {
+				bucketName = name
+				cacheDir = filepath.Dir(cacheDir) + "/" + bucketName
 			}
 		}
+		prBucketPrefixes = prPrefixes
+		ciBucketPrefixes = ciPrefixes
+	}
 
-		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.List.Limit)
+	if CLI.Bucket != "" {
+		bucketName = strings.TrimSuffix(strings.TrimPrefix(CLI.Bucket, "gs://"), "/")
+		storageScheme = "gs"
+		cacheDir = filepath.Dir(cacheDir) + "/" + bucketName
+	}
+	if CLI.Storage != "" {
+		storageScheme, bucketName, err = parseBucket(CLI.Storage)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "error: --storage: %v\n", err)
+			exit(1)
 		}
+		artifactsURL = strings.TrimSuffix(CLI.Storage, "/")
+		cacheDir = filepath.Dir(cacheDir) + "/" + bucketName
+	}
+	if CLI.S3Endpoint != "" {
+		s3Endpoint = CLI.S3Endpoint
+	}
 
-		var filtered []GinkgoResult
-		for _, res := range results {
-			if !strings.Contains(res.Name, CLI.Tests.List.Name) {
-				continue
-			}
-
-			if CLI.Tests.List.OnlyFailed && res.Status != statusFailed {
-				continue
+	// The build given to 'builds analyze' may live in another bucket than
+	// the one of the profile, which means that the cache directory must be
+	// picked before it is locked.
+	var analyzeDir string
+	if kongctx.Command() == "builds analyze <url>" {
+		scheme, bucket, objectName, ok := parseArtifactURL(CLI.Builds.Analyze.URL)
+		if ok {
+			analyzeDir, ok = buildDir(objectName)
+		}
+		if !ok {
+			fmt.Fprintf(os.Stderr, "error: %s is not a link to a build, expected the Spyglass page of the build in the Prow UI or a gcsweb page\n", CLI.Builds.Analyze.URL)
+			exit(1)
+		}
+		if bucket != bucketName {
+			storageScheme, bucketName = scheme, bucket
+			cacheDir = filepath.Dir(cacheDir) + "/" + bucketName
+		}
+	}
+	if len(CLI.PRPrefixes) > 0 {
+		prBucketPrefixes = CLI.PRPrefixes
+	}
+	if len(CLI.CIPrefixes) > 0 {
+		ciBucketPrefixes = CLI.CIPrefixes
+	}
+
+	if CLI.Tests.Output == "junit" && kongctx.Command() != "tests parse-logs <file-or-url>" {
+		fmt.Fprintf(os.Stderr, "error: --output=junit is only supported by 'tests parse-logs'.\n")
+		exit(1)
+	}
+	if CLI.Tests.Output == "dot" && kongctx.Command() != "tests co-failures" && kongctx.Command() != "tests clusters" {
+		fmt.Fprintf(os.Stderr, "error: --output=dot is only supported by 'tests co-failures' and 'tests clusters'.\n")
+		exit(1)
+	}
+
+	// The custom formats are rendered from the JSON output, which means that
+	// the commands don't need to know about them. The template must be
+	// started after the pager so that it writes to the pager. Since --explain
+	// prints its own text, the template isn't used with it.
+	switch name := strings.TrimPrefix(CLI.Tests.Output, "custom:"); {
+	case CLI.Tests.Output == "text", CLI.Tests.Output == "json", CLI.Tests.Output == "junit", CLI.Tests.Output == "dot":
+	case name != CLI.Tests.Output && name != "" && strings.HasPrefix(kongctx.Command(), "tests ") && !explain:
+		dir := filepath.Dir(configFile) + "/templates"
+		if CLI.TemplatesDir != "" {
+			dir = CLI.TemplatesDir
+		}
+		tmpl, err := loadTemplate(dir, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --output: %v\n", err)
+			exit(1)
+		}
+		render, err := startTemplate(tmpl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		CLI.Tests.Output = "json"
+		defer func() {
+			err := render()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: --output: %v\n", err)
+				exit(1)
 			}
+		}()
+	case name != CLI.Tests.Output && name != "":
+	default:
+		fmt.Fprintf(os.Stderr, "error: --output must be one of 'text', 'json', 'junit', 'dot', or 'custom:<name>', got %q\n", CLI.Tests.Output)
+		exit(1)
+	}
 
-			filtered = append(filtered, res)
+	if CLI.Tests.Wrap && CLI.Tests.ErrorWidth == 0 {
+		fmt.Fprintf(os.Stderr, "error: --wrap requires --error-width\n")
+		exit(1)
+	}
+
+	if CLI.Tests.NameRegex != "" {
+		nameRegex, err = regexp.Compile(CLI.Tests.NameRegex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --name-regex '%s' is an invalid regular expression: %v\n", CLI.Tests.NameRegex, err)
+			exit(1)
+		}
+	}
+	if CLI.Tests.Owner != "" && len(owners) == 0 {
+		fmt.Fprintf(os.Stderr, "error: --owner: no owner is defined, add 'owners' to the profile in %s\n", configFile)
+		exit(1)
+	}
+	ownerFilter = CLI.Tests.Owner
+	if CLI.Tests.FromJSON != "" {
+		if kongctx.Command() == "tests parse-logs <file-or-url>" {
+			fmt.Fprintf(os.Stderr, "error: --from-json can't be used with parse-logs\n")
+			exit(1)
+		}
+		fromJSON = CLI.Tests.FromJSON
+		CLI.NoDownload = true
+	}
+	if CLI.Tests.ExcludeName != "" {
+		excludeName, err = regexp.Compile(CLI.Tests.ExcludeName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --exclude-name '%s' is an invalid regular expression: %v\n", CLI.Tests.ExcludeName, err)
+			exit(1)
+		}
+	}
+
+	// Most commands read from or write to the cache directory, so we create
+	// it up front instead of failing later with a raw "no such file or
+	// directory" error. The report command uses the cache directories of the
+	// profiles instead.
+	switch kongctx.Command() {
+	case "completion <shell>", "mirror", "report":
+	default:
+		err = ensureCacheDir(CLI.ReadOnlyCache)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+		// A cron job and an interactive run may use the same cache at the
+		// same time. The report command doesn't lock the cache since each
+		// of its prowdig processes does.
+		unlock, err := lockCache(cacheDir, writesCache(kongctx.Command()), CLI.ReadOnlyCache, CLI.WaitForLock)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		defer unlock()
+	}
+
+	// The cache may have been written by another version of prowdig. Clearing
+	// or migrating the cache is always possible.
+	switch kongctx.Command() {
+	case "completion <shell>", "mirror", "report", "cache clear", "cache migrate":
+	default:
+		err = checkCacheLayout(cacheDir, CLI.ReadOnlyCache)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+	}
+
+	// When nothing is going to be downloaded, an empty cache means that the
+	// commands will show nothing at all, which is confusing without a hint.
+	if CLI.NoDownload && fromJSON == "" && readsCache(kongctx.Command()) {
+		empty, err := isCacheEmpty()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while reading the cache directory: %v\n", err)
+			exit(1)
+		}
+		switch {
+		case empty && CLI.ReadOnlyCache:
+			fmt.Fprintf(os.Stderr, "warning: the read-only cache directory %s is empty, is 'prowdig sync' filling it?\n", cacheDir)
+		case empty:
+			fmt.Fprintf(os.Stderr, "warning: the cache directory %s is empty, run 'prowdig download' to fill it\n", cacheDir)
+		}
+	}
+
+	switch kongctx.Command() {
+	case "init":
+		fmt.Printf("Cache directory: %s\n", cacheDir)
+
+		_, err = os.Stat(configFile)
+		switch {
+		case err == nil && !CLI.Init.Force:
+			fmt.Printf("Config file: %s already exists, use --force to overwrite it\n", configFile)
+		case err != nil && !os.IsNotExist(err):
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		default:
+			err = writeStarterConfig(configFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: while writing the config file: %v\n", err)
+				exit(1)
+			}
+			fmt.Printf("Config file: %s written\n", configFile)
+		}
+
+		if CLI.Init.Download {
+			summary, err := downloadPRBuildArtifactsToCache(1, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: the test download failed, do you have access to %s://%s? Try running 'gcloud auth application-default login' for GCS, or setting AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for S3. The error was: %v\n", storageScheme, bucketName, err)
+				exit(1)
+			}
+			fmt.Printf("Test download: %d artifacts found in the last build, %s downloaded\n", summary.Listed, ByteCountSI(summary.DownloadedBytes))
+		}
+
+	case "download":
+		if CLI.NoDownload {
+			fmt.Fprint(os.Stderr, "error: cannot use --no-download with the download command.\n")
+			exit(1)
+		}
+
+		if CLI.Download.Regex == "" {
+			CLI.Download.Regex = isToBeDownloaded.String()
+		}
+
+		regex, err := regexp.Compile(CLI.Download.Regex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --regex '%s' is an invalid regular expression: %v\n", CLI.Download.Regex, err)
+			exit(1)
+		}
+
+		_, err = downloadCIBuildArtifactsToCache(CLI.Download.Limit, regex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+			exit(1)
+		}
+
+	case "mirror":
+		if CLI.NoDownload {
+			fmt.Fprint(os.Stderr, "error: cannot use --no-download with the mirror command.\n")
+			exit(1)
+		}
+		if tailBytes > 0 {
+			fmt.Fprint(os.Stderr, "error: cannot use --tail-bytes with the mirror command, the mirrored files must be complete.\n")
+			exit(1)
+		}
+
+		if CLI.Mirror.Regex == "" {
+			CLI.Mirror.Regex = isToBeDownloaded.String()
+		}
+
+		regex, err := regexp.Compile(CLI.Mirror.Regex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --regex '%s' is an invalid regular expression: %v\n", CLI.Mirror.Regex, err)
+			exit(1)
+		}
+
+		summary, err := mirrorBuildArtifacts(CLI.Mirror.Dest, CLI.Mirror.Limit, regex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to mirror job artifacts to %s: %v\n", CLI.Mirror.Dest, err)
+			exit(1)
+		}
+
+		switch CLI.Mirror.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(summary)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			fmt.Printf("%d artifacts found in the last %d builds.\n", summary.Listed, CLI.Mirror.Limit)
+			fmt.Printf("%s artifacts copied to %s (%s).\n", green(summary.Copied), CLI.Mirror.Dest, ByteCountSI(summary.CopiedBytes))
+			fmt.Printf("%s artifacts already present in %s.\n", gray(summary.UpToDate), CLI.Mirror.Dest)
+		}
+
+	case "sync":
+		if CLI.NoDownload {
+			fmt.Fprint(os.Stderr, "error: cannot use --no-download with the sync command.\n")
+			exit(1)
+		}
+
+		var summary downloadSummary
+		var err error
+		if CLI.Sync.PubsubSubscription != "" {
+			summary, err = downloadNotifiedObjectsToCache(CLI.Sync.PubsubSubscription, ciBucketPrefixes, isToBeDownloaded)
+		} else {
+			summary, err = downloadPRBuildArtifactsToCache(CLI.Sync.Limit, isToBeDownloaded)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to sync job artifacts: %v\n", err)
+			exit(1)
+		}
+
+		// The files are parsed right away so that the commands run after
+		// the sync find their results in the index.
+		if !noIndex {
+			_, _, err = parseArtifactsFromCache(ciBucketPrefixes, CLI.Sync.Limit)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to index the job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		switch CLI.Sync.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(summary)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			if CLI.Sync.PubsubSubscription != "" {
+				fmt.Printf("%d artifacts found in the notifications.\n", summary.Listed)
+			} else {
+				fmt.Printf("%d artifacts found in the last %d builds.\n", summary.Listed, CLI.Sync.Limit)
+			}
+			fmt.Printf("%s new or changed artifacts downloaded (%s).\n", green(summary.Downloaded), ByteCountSI(summary.DownloadedBytes))
+			fmt.Printf("%s artifacts already up to date.\n", gray(summary.UpToDate))
+			if summary.EvictedBuilds > 0 {
+				fmt.Printf("%s least recently used builds removed from the cache (%s).\n", gray(summary.EvictedBuilds), ByteCountSI(summary.EvictedBytes))
+			}
 		}
-		results = filtered
 
-		sort.Slice(results, func(i, j int) bool {
-			return strings.Compare(results[i].Name, results[j].Name) < 0
+	case "tests parse-logs <file-or-url>":
+		var bytes []byte
+		var err error
+		fileOrURL := CLI.Tests.ParseLogs.FileOrURL
+
+		// The links pasted from the Prow UI or from gcsweb are turned into
+		// the URL of the build-log.txt file in the bucket.
+		var pr, build int
+		var job string
+		if scheme, bucket, objectName, ok := parseArtifactURL(fileOrURL); ok {
+			if scheme != "gs" {
+				fmt.Fprintf(os.Stderr, "error: %s: only the links to the artifacts stored in GCS can be fetched, download the build-log.txt file and give its path instead\n", fileOrURL)
+				exit(1)
+			}
+			if isNumber(path.Base(objectName)) {
+				objectName += "/build-log.txt"
+			}
+			pr, job, build, _ = parseObjectName(objectName)
+			fileOrURL = "https://storage.googleapis.com/" + bucket + "/" + objectName
+			if CLI.Debug {
+				fmt.Fprintf(os.Stderr, "debug: fetching %s\n", fileOrURL)
+			}
+		}
+		isURL := strings.HasPrefix(fileOrURL, "http://") || strings.HasPrefix(fileOrURL, "https://")
+		if isURL {
+			content, err := http.Get(fileOrURL)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fetching URL: %v\n", err)
+			}
+			bytes, err = ioutil.ReadAll(content.Body)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "reading HTTP response: %v\n", err)
+			}
+
+			if CLI.Debug {
+				fmt.Fprintf(os.Stderr, "debug: downloaded %s\n", ByteCountSI(int64(len(bytes))))
+			}
+
+			if content.StatusCode != 200 {
+				fmt.Fprintf(os.Stderr, "fetching URL: %s: %v\n", content.Status, string(bytes))
+			}
+		} else {
+			bytes, err = ioutil.ReadFile(fileOrURL)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		}
+
+		blocks, err := parseBuildLog(bytes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while parsing %s: %v\n", fileOrURL, err)
+			exit(1)
+		}
+
+		// We don't use the syntax 'var results' so that the encoded JSON shows
+		// "[]" instead of "null".
+		results := []GinkgoResult{}
+		for _, block := range blocks {
+			parsed, err := parseGinkgoBlock(block)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: parsing one of the ginkgo blocks: %v\n", err)
+			}
+
+			source := fileOrURL + ":" + strconv.Itoa(block.line)
+			if isURL {
+				source = fileOrURL + "#line=" + strconv.Itoa(block.line)
+			}
+
+			results = append(results, GinkgoResult{
+				Name:     parsed.name,
+				Status:   parsed.status,
+				Duration: parsed.duration,
+				Err:      parsed.errStr,
+				ErrLoc:   parsed.errLoc,
+				Source:   source,
+				Job:      job,
+				PR:       pr,
+				Build:    build,
+			})
+		}
+
+		for _, failure := range parseGinkgoV2Summary(bytes) {
+			source := fileOrURL + ":" + strconv.Itoa(failure.line)
+			if isURL {
+				source = fileOrURL + "#line=" + strconv.Itoa(failure.line)
+			}
+
+			results = append(results, GinkgoResult{
+				Name:   failure.parsed.name,
+				Status: failure.parsed.status,
+				ErrLoc: failure.parsed.errLoc,
+				Source: source,
+				Job:    job,
+				PR:     pr,
+				Build:  build,
+			})
+		}
+		for i := range results {
+			results[i].Owner = findOwner(results[i].Name)
+		}
+		results = filterNames(results, nameRegex, excludeName)
+		results = filterOwner(results, ownerFilter)
+
+		if CLI.Tests.Anonymize {
+			results = anonymizeResults(results)
+		}
+
+		sort.SliceStable(results, func(i, j int) bool {
+			return lessResult(results[i], results[j])
 		})
 
 		switch CLI.Tests.Output {
 		case "json":
-			if results == nil {
-				// Force the encoded JSON to show "[]" instead of "null".
-				results = []GinkgoResult{}
-			}
 			err = json.NewEncoder(os.Stdout).Encode(results)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "junit":
+			err = writeJunit(os.Stdout, fileOrURL, results)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
 			}
 		case "text":
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 			defer w.Flush()
 
+			// The continuation lines of the wrapped error messages must skip
+			// the --wide columns.
+			cont := "\t"
+			if CLI.Tests.Wide {
+				cont = "\t\t\t\t\t"
+			}
 			for _, res := range results {
+				duration := formatDuration(res.Duration)
 				switch res.Status {
 				case statusPassed:
-					fmt.Fprintf(w, "✅ %s\t%s\n", green((time.Duration(res.Duration) * time.Second).String()), res.Name)
-				case statusFailed:
-					fmt.Fprintf(w, "❌ %s\t%s: %s\n", red((time.Duration(res.Duration) * time.Second).String()), res.Name, gray(res.Err))
+					fmt.Fprintf(w, "%s %s\t%s%s%s\n", icon(statusPassed), green(duration), wideColumns(res), res.Name, link(res.Source))
+				case statusFailed, statusTimedOut, statusPanicked:
+					fmt.Fprintf(w, "%s %s\t%s%s: %s%s\n", icon(res.Status), red(duration), wideColumns(res), res.Name, gray(fitErr(res.Err, cont)), link(res.Source))
 				case statusError:
-					fmt.Fprintf(w, "💣️ %s\t%s: %s\n", blue((time.Duration(res.Duration) * time.Second).String()), res.Name, gray(res.Err))
+					fmt.Fprintf(w, "%s %s\t%s%s: %s%s\n", icon(statusError), blue(duration), wideColumns(res), res.Name, gray(fitErr(res.Err, cont)), link(res.Source))
 				default:
 					panic("developer mistake: unknown status: " + res.Status)
 				}
 			}
-		}
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+		default:
+			fmt.Fprintf(os.Stderr, "developer mistake, defined in kong's enum but not handled: %q\n", CLI.Tests.Output)
+			exit(1)
 		}
 
-	case "jobs list":
+	case "tests max-duration":
 		if !CLI.NoDownload {
-			err := downloadPRBuildArtifactsToCache(CLI.Builds.List.Limit, regexp.MustCompile(`prowjob\.json$`))
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.List.Limit, isToBeDownloaded)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
-				os.Exit(1)
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
 			}
 		}
 
-		results, err := parseBuildsFromCache(ciBucketPrefixes, CLI.Builds.List.Limit)
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.MaxDuration.Limit)
+		if err == errExplained {
+			return
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
 		}
 
-		switch CLI.Builds.Output {
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
+
+		stats := computeStatsMaxDuration(results)
+		switch CLI.Tests.Output {
 		case "json":
-			if results == nil {
+			if stats == nil {
 				// Force the encoded JSON to show "[]" instead of "null".
-				results = []BuildResult{}
+				stats = []StatsMaxDuration{}
 			}
-			err = json.NewEncoder(os.Stdout).Encode(results)
+			err = json.NewEncoder(os.Stdout).Encode(stats)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			}
 		case "text":
-			for _, res := range results {
-				switch res.Status {
-				case BuildSuccess:
-					fmt.Printf("%s\t%s\n", green((time.Duration(res.Duration) * time.Second).String()), res.JobName)
-				case BuildFailed:
-					fmt.Printf("%s\t%s: %s\n", red((time.Duration(res.Duration) * time.Second).String()), res.JobName, gray(res.Err))
-				default:
-					panic("developer mistake: unknown status: " + res.Status)
-				}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			sources := testSources(results)
+			for _, stat := range stats {
+				fmt.Fprintf(w, "%s\t%s\t%s%s\n",
+					green(formatDuration(fromSeconds(stat.MaxDurationPassed))),
+					red(formatDuration(fromSeconds(stat.MaxDurationFailed))),
+					stat.Name,
+					link(sources[stat.Name]),
+				)
 			}
 		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 
-	default:
-		panic("developer mistake: " + kongctx.Command())
-	}
-}
+	case "tests most-failures":
+		if CLI.Tests.MostFailures.GroupBy != "" {
+			if _, ok := dimensions[CLI.Tests.MostFailures.GroupBy]; !ok {
+				var dims []string
+				for dim := range dimensions {
+					dims = append(dims, dim)
+				}
+				sort.Strings(dims)
+				fmt.Fprintf(os.Stderr, "error: --group-by: unknown dimension %q, the known dimensions are: %s\n", CLI.Tests.MostFailures.GroupBy, strings.Join(dims, ", "))
+				exit(1)
+			}
+		}
 
-// One ginkgo block looks like this:
-//
-//   - Failure [301.437 seconds]                          ^
-//     [Conformance] Certificates                           |
-//     test/e2e/framework/framework.go:287                  |
-//     with issuer type External ClusterIssuer            |
-//     test/e2e/suite/conformance/certificates.go:47      |
-//     should issue a cert with wildcard DNS Name [It]  |
-//     test/e2e/suite/conformance/certificates.go:105   |
-//     Unexpected error:                                |
-//     | "lines"
-//     <*errors.errorString | 0xc0001c07b0>: {      |
-//     s: "timed out waiting for the condition",|
-//     }                                            |
-//     timed out waiting for the condition          |
-//     occurred                                         |
-//     test/e2e/suite/conformance/certificates.go:522   |
-//     ------------------------------                       v
-type ginkgoBlock struct {
-	// Line number of the first line of the Ginkgo block as it appears in the
-	// build-log.txt file.
-	line int
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.MostFailures.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
 
-	// The lines of the ginkgo block, which starts with the line that starts with
-	// '• Failure [301.437 seconds]'. It does not include the ending marker
-	// '------------------------------'.
-	lines []string
-}
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.MostFailures.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-// The function parseBuildLog parses the content of a build-log.txt file and
-// returns a slice of "ginkgo blocks". You don't need to remove ANSI color codes
-// that are printed by Ginkgo before giving the logs to this function.
-func parseBuildLog(buildLog []byte) ([]ginkgoBlock, error) {
-	// Since Ginkgo colors its output, we need to remove the ANSI escape codes.
-	buildLog = rmAnsiColors.ReplaceAll(buildLog, []byte(""))
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
 
-	var blocks []ginkgoBlock
-	scanner := bufio.NewScanner(bytes.NewReader(buildLog))
-	lineNo := 0
-	isContent := false
-	var body []string
-	for scanner.Scan() {
-		lineNo++
-		line := scanner.Bytes()
-		if !isContent && bytes.HasPrefix(line, []byte("• Failure")) {
-			isContent = true
+		if CLI.Tests.MostFailures.GroupBy != "" {
+			results = groupByDimension(results, CLI.Tests.MostFailures.GroupBy)
 		}
 
-		if isContent {
-			body = append(body, string(line))
+		if CLI.Tests.MostFailures.PerJob {
+			matrix := computeStatsPerJob(results)
+			switch CLI.Tests.Output {
+			case "json":
+				err = json.NewEncoder(os.Stdout).Encode(matrix)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					exit(1)
+				}
+			case "text":
+				err = printStatsPerJob(os.Stdout, matrix, testSources(results))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					exit(1)
+				}
+			}
+			break
 		}
 
-		if isContent && bytes.Equal(line, []byte("------------------------------")) {
-			blocks = append(blocks, ginkgoBlock{
-				line:  lineNo,
-				lines: body,
-			})
-			body = nil
-			isContent = false
+		stats := computeStatsMostFailures(results)
+		scaleStatsMostFailures(stats, sampleFraction)
+		stats = filterStatsMostFailures(stats, CLI.Tests.MostFailures.MinFailures, CLI.Tests.MostFailures.MinRuns)
+		sortStatsMostFailures(stats, CLI.Tests.MostFailures.Sort)
+		switch CLI.Tests.Output {
+		case "json":
+			if stats == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				stats = []StatsMostFailures{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+			defer w.Flush()
+
+			for _, stat := range stats {
+				lastErr, lastSource := "", ""
+				if len(stat.Errors) > 0 {
+					lastErr = stat.Errors[len(stat.Errors)-1].Err
+					lastSource = stat.Errors[len(stat.Errors)-1].Source
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s: %s%s\n",
+					green(stat.CountPassed),
+					red(stat.CountFailed),
+					gray(fmt.Sprintf("%.0f%% [%.0f%%-%.0f%%]", 100*stat.FailureRate, 100*stat.FailureRateLow, 100*stat.FailureRateHigh)),
+					gray("first "+formatTime(stat.FirstSeen)),
+					gray("last "+formatTime(stat.LastSeen)),
+					stat.Name,
+					gray(fitErr(lastErr, "\t\t\t\t\t")),
+					link(lastSource),
+				)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
 		}
-	}
 
-	if isContent {
-		return nil, fmt.Errorf("unexpected end of file, still waiting for the ginkgo block started at line %d to end with '------------------------------'", lineNo)
-	}
+	case "tests pick":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Pick.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
 
-	return blocks, nil
-}
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Pick.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-type parsedGinkgoBlock struct {
-	// The name of the test.
-	name     string
-	status   status
-	duration int
-	errStr   string
-	errLoc   string
-}
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
 
-// The parseGinkgoBlock function parses the body of one ginkgo block, as defined
-// in the diagram above the ginkgoBlock struct.
-//
-// Note that the "[It]" suffixes are removed from the test names in order to
-// match the test name given in junit__0x.xml files.
-func parseGinkgoBlock(block ginkgoBlock) (parsedGinkgoBlock, error) {
-	if len(block.lines) < 2 {
-		return parsedGinkgoBlock{}, fmt.Errorf("a ginkgo block is at least 2 lines long, got: %s", strings.Join(block.lines, "\n"))
-	}
+		name, err := fuzzyPick(completionCandidates(results, "names"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		if name == "" {
+			// The user aborted the fuzzy finder.
+			exit(130)
+		}
 
-	// • Failure [301.574 seconds]                          <- Header
-	// [Conformance] Certificates                            ^
-	// test/e2e/framework/framework.go:287                   |
-	//   with issuer type SelfSigned ClusterIssuer           | Name
-	//   test/e2e/suite/conformance/tests.go:47              |
-	//     should issue an ECDSA, defaulted cert [It]        |
-	//     test/e2e/suite/conformance/suite.go:105           v
-	//
-	//     failed to create issuer                          <- ErrHeader    ^
-	//     Unexpected error:                                 ^              |
-	//         <*errors.errorString | 0xc0001c07d0>: {       |              |
-	//             s: "timed out waiting for the condition", | Err          |
-	//         }                                             |              | optional
-	//         timed out waiting for the condition           |              |
-	//     occurred                                          V              |
-	//                                                                      |
-	//     test/e2e/suite/conformance/tests.go:149          <- ErrLoc       v
-	// ------------------------------                       <- Footer
+		history := testHistory(results, name)
+		switch CLI.Tests.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(history)
+		case "text":
+			err = printTestHistory(os.Stdout, history)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
 
-	// Header.
-	match := reGingkoBlockHeader.FindStringSubmatch(block.lines[0])
-	if len(match) != 3 {
-		return parsedGinkgoBlock{}, fmt.Errorf("ginkgo block header: expected %s, got: %s", reGingkoBlockHeader, block.lines[0])
-	}
+	case "tests clusters":
+		if CLI.Tests.Clusters.Threshold < 0 || CLI.Tests.Clusters.Threshold > 1 {
+			fmt.Fprintf(os.Stderr, "error: --threshold must be between 0 and 1, got %v\n", CLI.Tests.Clusters.Threshold)
+			exit(1)
+		}
 
-	var status status
-	switch {
-	case strings.HasPrefix(match[1], "Failure in Spec Setup"):
-		status = statusError
-	case match[1] == "Failure":
-		status = statusFailed
-	default:
-		return parsedGinkgoBlock{}, fmt.Errorf("ginkgo block header: expected 'Failure' or 'Failure in Spec Setup', got: %s", match[1])
-	}
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Clusters.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
 
-	duration, err := strconv.Atoi(match[2])
-	if err != nil {
-		return parsedGinkgoBlock{}, fmt.Errorf("ginkgo block header: expected an integer, got: %s", match[1])
-	}
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Clusters.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-	// Footer.
-	if block.lines[len(block.lines)-1] != "------------------------------" {
-		return parsedGinkgoBlock{}, fmt.Errorf("expected the last line to be '------------------------------', block was: %s", strings.Join(block.lines, "\n"))
-	}
+		clusters := clusterErrors(results, CLI.Tests.Clusters.Threshold)
+		switch CLI.Tests.Output {
+		case "json":
+			if clusters == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				clusters = []ErrorCluster{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(clusters)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+			defer w.Flush()
 
-	block.lines = block.lines[1 : len(block.lines)-1]
+			sources := errSources(results)
+			for _, cluster := range clusters {
+				fmt.Fprintf(w, "%s\t%d tests\t%s\t%s%s\n", red(cluster.Count), len(cluster.Tests), cluster.Fingerprint, gray(fitErr(cluster.Err, "\t\t\t")), link(sources[cluster.Err]))
+			}
+		case "dot":
+			err = writeClustersDot(os.Stdout, clusters)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		}
 
-	// Name.
-	var parts []string
-	i := 0
-	for i < len(block.lines)-1 &&
-		strings.HasPrefix(block.lines[i], strings.Repeat(" ", i)) &&
-		strings.HasPrefix(block.lines[i+1], strings.Repeat(" ", i)) {
+	case "tests triage":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Triage.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
 
-		parts = append(parts, strings.TrimPrefix(strings.TrimSuffix(block.lines[i], " [It]"), strings.Repeat(" ", i)))
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Triage.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-		i += 2
-	}
-	if i == 0 {
-		return parsedGinkgoBlock{}, fmt.Errorf("no name line found, remaining was: %s", strings.Join(block.lines, "\n"))
-	}
+		buckets := computeTriage(results, CLI.Tests.Triage.Examples)
+		switch CLI.Tests.Output {
+		case "json":
+			if buckets == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				buckets = []TriageBucket{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(buckets)
+		case "text":
+			err = printTriage(os.Stdout, buckets)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
 
-	name := strings.Join(parts, " ")
+	case "tests file-issues":
+		repo := githubRepo
+		if CLI.Tests.FileIssues.Repo != "" {
+			repo = CLI.Tests.FileIssues.Repo
+		}
+		if repo == "" {
+			fmt.Fprintf(os.Stderr, "error: --repo is required since the profile has no 'githubRepo'\n")
+			exit(1)
+		}
+		if CLI.Tests.FileIssues.Token == "" && !CLI.Tests.FileIssues.DryRun {
+			fmt.Fprintf(os.Stderr, "error: a GitHub token is needed to file the issues, set GITHUB_TOKEN or pass --dry-run\n")
+			exit(1)
+		}
 
-	// The Err and ErrLoc are optional.
-	if i >= len(block.lines) {
-		return parsedGinkgoBlock{
-			name:     name,
-			status:   status,
-			duration: duration,
-			errStr:   "",
-			errLoc:   "",
-		}, nil
-	}
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.FileIssues.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
 
-	indent := strings.Repeat(" ", i-2)
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.FileIssues.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-	block.lines = block.lines[i:]
-	if block.lines[0] == "" {
-		block.lines = block.lines[1:]
-	}
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
 
-	// Now, let's remove the indentation of Err and ErrLoc.
-	for j := range block.lines {
-		block.lines[j] = strings.TrimPrefix(block.lines[j], indent)
-	}
+		stats := computeStatsMostFailures(results)
+		if len(stats) > CLI.Tests.FileIssues.Top {
+			stats = stats[len(stats)-CLI.Tests.FileIssues.Top:]
+		}
 
-	// ErrLoc.
-	//
-	// We remove the ErrLoc lines from block.lines so that the remainder is
-	// only ErrHeader and Err.
-	errLoc := strings.TrimPrefix(block.lines[len(block.lines)-1], indent)
-	block.lines = block.lines[0 : len(block.lines)-2]
-
-	var errStr []string
+		gh := githubClient{api: githubAPI, repo: repo, token: CLI.Tests.FileIssues.Token}
+		filed, err := fileIssues(gh, stats, CLI.Tests.FileIssues.Label, CLI.Tests.FileIssues.DryRun)
+		// The issues filed before the error are still shown.
+		switch CLI.Tests.Output {
+		case "json":
+			if filed == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				filed = []FiledIssue{}
+			}
+			_ = json.NewEncoder(os.Stdout).Encode(filed)
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			for _, issue := range filed {
+				number := "-"
+				if issue.Number != 0 {
+					number = "#" + strconv.Itoa(issue.Number)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s%s\n", issue.Action, number, issue.Title, link(issue.URL))
+			}
+			_ = w.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
 
-	// ErrHeader.
-	i = 0
-	for ; i < len(block.lines) && !strings.HasPrefix(block.lines[i], "Unexpected error:"); i++ {
-		if len(block.lines[i]) == 0 {
-			continue
+	case "tests co-failures":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.CoFailures.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
 		}
-		errStr = append(errStr, block.lines[i])
-	}
 
-	if i == len(block.lines) {
-		return parsedGinkgoBlock{name: name, status: status, duration: duration, errStr: strings.Join(errStr, "\n"), errLoc: errLoc}, nil
-	}
-	block.lines = block.lines[i:len(block.lines)]
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.CoFailures.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-	// Err.
-	//
-	// Now let's deal with this overly verbose "Unexpected error: ...
-	// occured". Note that the ErrHeader has already been dealt with.
-	//
-	//    Unexpected error:
-	//        <*errors.errorString | 0xc0001c07d0>: {             ^
-	//            s: "timed out waiting for the condition",       |  Pre
-	//        }                                                   |
-	//        timed out waiting for the condition                 v
-	//    occurred
-	//
-	// Notice the indentation of 4 spaces in the lines between "Unexpected
-	// error:" and "occured".
-	for i := range block.lines {
-		if !isParen.MatchString(block.lines[i]) {
-			continue
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
 		}
-		block.lines = block.lines[i+1 : len(block.lines)-1]
-		break
-	}
-	for i := range block.lines {
-		block.lines[i] = strings.TrimPrefix(block.lines[i], "    ")
-	}
 
-	errStr = append(errStr, block.lines...)
-	return parsedGinkgoBlock{name: name, status: status, duration: duration, errStr: strings.Join(errStr, "\n"), errLoc: errLoc}, nil
-}
+		pairs := computeCoFailures(results, CLI.Tests.CoFailures.MinTogether, CLI.Tests.CoFailures.MinLift)
+		switch CLI.Tests.Output {
+		case "json":
+			if pairs == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				pairs = []CoFailure{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(pairs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
 
-// downloadPRBuildArtifactsToCache is a slow function that reads the Google
-// Storage bucket, and downloads the files found in the bucket onto your
-// disk in the hardcoded directory ~/.cache/prowdig.
-//
-// The argument "limit" corresponds to the maximum number of Prow builds to
-// be downloaded. The earliest builds are downloaded first. By earliest
-// builds, we mean the builds with the lowest build number. To understand
-// better the "limit" parameter, let us see what happens when we list
-// builds using gsutil:
-//
-//	gsutil ls "gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/**" | grep "build-log\.txt$"
-//	                <-----------> <------------------------------------>
-//	                 bucket name               bucket prefix
-//
-// The output is:
-//
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
-//	                                                                                       <----------------->
-//	                                                                                           build number
-//
-// Using downloadPRBuildArtifactsToCache with the regex is "build-log\.txt$",
-// and the bucket is "jetstack-logs", you will see the following files
-// downloaded to ~/.cache/prowdig:
-//
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
-//
-// With limit=2, then only the last two build numbers get downloaded:
-//
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
-//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
-//
-// For example, the last item of that list will be found at the path:
-//
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/jetstack_cert-manager/2/pull-cert-manager-e2e-v1-13/245/build-log.txt
-//	<--------------> <----------->
-//	    hardcoded     bucket name
-//
-// The filter can be left nil.
-func downloadPRBuildArtifactsToCache(limit int, filter *regexp.Regexp) error {
-	gcs, err := storage.NewClient(context.Background())
-	if err != nil {
-		return fmt.Errorf("error: Google Cloud storage: %v\n", err)
-	}
-	bucket := gcs.Bucket(bucketName)
+			sources := testSources(results)
+			for _, pair := range pairs {
+				fmt.Fprintf(w, "%s\t%s\t%s%s\n", red(pair.Together), gray(fmt.Sprintf("×%.1f", pair.Lift)), pair.TestA, link(sources[pair.TestA]))
+				fmt.Fprintf(w, "\t\t%s%s\n", pair.TestB, link(sources[pair.TestB]))
+			}
+		case "dot":
+			err = writeCoFailuresDot(os.Stdout, pairs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		}
 
-	bar1 := pb.NewOptions(int(5 /* seconds */ *5 /* = 1/200 ms */),
-		pb.OptionSetPredictTime(false),
-		pb.OptionSetWriter(os.Stderr),
-		pb.OptionEnableColorCodes(true),
-		pb.OptionShowBytes(false),
-		pb.OptionSetDescription("Listing all PRs..."),
-		pb.OptionSetTheme(theme),
-	)
-	go func() {
-		for !bar1.IsFinished() {
-			_ = bar1.Add(1)
-			_ = bar1.RenderBlank()
-			time.Sleep(200 * time.Millisecond)
+	case "tests flaky":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Flaky.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
 		}
-	}()
-	prPrefixes, err := listPRPrefixes(bucket, ciBucketPrefixes)
-	if err != nil {
-		return fmt.Errorf("failed to list PR prefixes: %v", err)
-	}
-	_ = bar1.Finish()
-	_ = bar1.Clear()
 
-	// Now, let's list the files under each PR prefix.
-	var objects []storage.ObjectAttrs
-	totalSize := int64(0)
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Flaky.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
 
-	bar2 := pb.NewOptions(limit,
-		pb.OptionSetWriter(os.Stderr),
-		pb.OptionSetPredictTime(false),
-		pb.OptionEnableColorCodes(true),
-		pb.OptionShowBytes(false),
-		pb.OptionSetDescription(fmt.Sprintf("Finding the last %d jobs...", limit)),
-		pb.OptionSetTheme(theme),
-	)
-	_ = bar2.RenderBlank()
-	countJobs := 0 // One prowjob.json = one build.
-	for _, prPrefix := range prPrefixes {
-		objectIter := bucket.Objects(context.Background(), &storage.Query{
-			Prefix: prPrefix, Projection: storage.ProjectionNoACL,
-		})
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
 
-		for countJobs < limit {
-			object, err := objectIter.Next()
-			if err == iterator.Done {
-				break
+		scores := computeFlakeScores(results, FlakeWeights{
+			Rate:      CLI.Tests.Flaky.RateWeight,
+			Recency:   CLI.Tests.Flaky.RecencyWeight,
+			Spread:    CLI.Tests.Flaky.SpreadWeight,
+			Diversity: CLI.Tests.Flaky.DiversityWeight,
+		}, CLI.Tests.Flaky.HalfLife)
+		switch CLI.Tests.Output {
+		case "json":
+			if scores == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				scores = []FlakeScore{}
 			}
+			err = json.NewEncoder(os.Stdout).Encode(scores)
 			if err != nil {
-				return fmt.Errorf("failed to iterate over GCS objects: %s: %w", object.Name, err)
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
 			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
 
-			if strings.HasSuffix(object.Name, "prowjob.json") {
-				countJobs++
-				_ = bar2.Add(1)
+			sources := testSources(results)
+			for _, score := range scores {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s%s\n",
+					red(fmt.Sprintf("%.2f", score.Score)),
+					green(score.CountPassed),
+					red(score.CountFailed),
+					score.Name,
+					link(sources[score.Name]),
+				)
 			}
+		}
 
-			if filter != nil && !filter.MatchString(object.Name) {
-				continue
+	case "tests flakes":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Flakes.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
 			}
+		}
 
-			totalSize += object.Size
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Flakes.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
 
-			// Why "*object"? No one else is going to touch the
-			// *storage.ObjectAttrs pointer, so it makes sense to do a shallow
-			// copy here since all the "shared" fields like object.Metadata
-			// won't be used by anyone else.
-			objects = append(objects, *object)
+		stats := computeStatsFlakes(results)
+		switch CLI.Tests.Output {
+		case "json":
+			if stats == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				stats = []StatsFlakes{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
 
+			sources := testSources(results)
+			for _, stat := range stats {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s%s\n",
+					red(fmt.Sprintf("%.0f%%", 100*stat.FlakeRate)),
+					gray(fmt.Sprintf("%d/%d builds", stat.FlakyBuilds, stat.Builds)),
+					gray("last "+formatTime(stat.LastSeen)),
+					stat.Name,
+					link(sources[stat.Name]),
+				)
+			}
 		}
-		if countJobs >= limit {
-			break
+
+	case "tests slowdowns":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Slowdowns.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
 		}
-	}
-	_ = bar2.Finish()
-	_ = bar2.Clear()
 
-	bar3 := pb.NewOptions64(totalSize,
-		pb.OptionSetWriter(os.Stderr),
-		pb.OptionSetPredictTime(true),
-		pb.OptionShowCount(),
-		pb.OptionEnableColorCodes(true),
-		pb.OptionShowBytes(true),
-		pb.OptionSetDescription("Downloading logs for each job..."),
-		pb.OptionSetTheme(theme),
-	)
-	_ = bar3.RenderBlank()
-	for _, object := range objects {
-		if CLI.Debug {
-			fmt.Fprintf(os.Stderr, "downloading %s\n", object.Name)
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Slowdowns.Limit)
+		if err == errExplained {
+			return
 		}
-		err := downloadToCache(&object, bucket)
 		if err != nil {
-			return fmt.Errorf("failed to download jobs artifacts for %s: %w", object.Name, err)
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
 		}
-		_ = bar3.Add64(object.Size)
-	}
-	_ = bar3.Finish()
-	_ = bar3.Clear()
 
-	return nil
-}
+		slowdowns := computeSlowdowns(results, CLI.Tests.Slowdowns.Recent, CLI.Tests.Slowdowns.Factor, CLI.Tests.Slowdowns.MinRuns)
+		switch CLI.Tests.Output {
+		case "json":
+			if slowdowns == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				slowdowns = []Slowdown{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(slowdowns)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
 
-// Because the ci-cert-manager-* jobs have a very different layout in the
-// Google Storage bucket, the download logic is a bit different.
-//
-// More specifically, because of the lexicographical sorting limitation of
-// Google Storage, we have to list the builds for each of the "prefixes".
-// The prefixes are hardcoded. They look a bit like this:
-//
-//	logs/ci-cert-manager-e2e-v1-23
-//	logs/ci-cert-manager-e2e-v1-24
-//	logs/ci-cert-manager-make-test
-//	logs/ci-cert-manager-next-e2e-v1-23
-//	logs/ci-cert-manager-next-e2e-v1-24
-//	logs/ci-cert-manager-next-make-test
-//	logs/ci-cert-manager-next-upgrade
-//	logs/ci-cert-manager-next-venafi
-//	logs/ci-cert-manager-previous-bazel
+			sources := testSources(results)
+			for _, slowdown := range slowdowns {
+				fmt.Fprintf(w, "%s\t%s → %s\t%s%s\n",
+					red(fmt.Sprintf("×%.1f", slowdown.Factor)),
+					green(formatDuration(fromSeconds(slowdown.BaselineMedian))),
+					red(formatDuration(fromSeconds(slowdown.RecentMedian))),
+					slowdown.Name,
+					link(sources[slowdown.Name]),
+				)
+			}
+		}
+
+	case "tests compare":
+		opts := CLI.Tests.Compare
+		if (opts.Before == "") != (opts.After == "") || (opts.BeforeJob == "") != (opts.AfterJob == "") {
+			fmt.Fprintf(os.Stderr, "error: --before and --after, and --before-job and --after-job, must be given together\n")
+			exit(1)
+		}
+		if opts.Before == "" && opts.BeforeJob == "" {
+			fmt.Fprintf(os.Stderr, "error: either --before and --after or --before-job and --after-job must be given\n")
+			exit(1)
+		}
+		var before, after compareSide
+		var err error
+		before.From, before.To, err = parseWindow(opts.Before, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --before: %v\n", err)
+			exit(1)
+		}
+		after.From, after.To, err = parseWindow(opts.After, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --after: %v\n", err)
+			exit(1)
+		}
+		if opts.BeforeJob != "" {
+			before.Job, err = regexp.Compile(opts.BeforeJob)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: --before-job '%s' is an invalid regular expression: %v\n", opts.BeforeJob, err)
+				exit(1)
+			}
+			after.Job, err = regexp.Compile(opts.AfterJob)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: --after-job '%s' is an invalid regular expression: %v\n", opts.AfterJob, err)
+				exit(1)
+			}
+		}
+
+		// Unless --since or --until are given, only the builds that started
+		// within the two windows are downloaded and parsed.
+		if since.IsZero() && until.IsZero() && !before.From.IsZero() && !after.From.IsZero() && !before.To.IsZero() && !after.To.IsZero() {
+			since, until = before.From, before.To
+			if after.From.Before(since) {
+				since = after.From
+			}
+			if after.To.After(until) {
+				until = after.To
+			}
+		}
+
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(opts.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, opts.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		if CLI.Tests.ExcludeMassFailures {
+			results = excludeMassFailures(results)
+		}
+
+		comparisons := compareTests(before.filter(results), after.filter(results), opts.Factor, opts.MinRuns)
+		switch CLI.Tests.Output {
+		case "json":
+			if comparisons == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				comparisons = []TestComparison{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(comparisons)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			sources := testSources(results)
+			for _, c := range comparisons {
+				var change, detail string
+				switch c.Change {
+				case changeFixed:
+					change = green("fixed")
+					detail = fmt.Sprintf("%d/%d → %d/%d failed", c.BeforeFailures, c.BeforeRuns, c.AfterFailures, c.AfterRuns)
+				case changeSlower:
+					change = red(fmt.Sprintf("slower ×%.1f", c.Factor))
+					detail = green(formatDuration(fromSeconds(c.BeforeMedian))) + " → " + red(formatDuration(fromSeconds(c.AfterMedian)))
+				case changeNewlyFlaky:
+					change = red("newly flaky")
+					detail = fmt.Sprintf("%d/%d → %d/%d failed", c.BeforeFailures, c.BeforeRuns, c.AfterFailures, c.AfterRuns)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s%s\n", change, gray(detail), c.Name, link(sources[c.Name]))
+			}
+		}
+
+	case "tests attribute <pr>":
+		opts := CLI.Tests.Attribute
+		repo := githubRepo
+		if opts.Repo != "" {
+			repo = opts.Repo
+		}
+		var changed []string
+		if opts.Token != "" {
+			if repo == "" {
+				fmt.Fprintf(os.Stderr, "error: --repo is required since the profile has no 'githubRepo'\n")
+				exit(1)
+			}
+			var err error
+			gh := githubClient{api: githubAPI, repo: repo, token: opts.Token}
+			changed, err = gh.changedFiles(opts.PR)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: while fetching the files changed by the PR: %v\n", err)
+				exit(1)
+			}
+		}
+
+		var prPrefixes []string
+		for _, prefix := range prBucketPrefixes {
+			prPrefixes = append(prPrefixes, strings.TrimSuffix(prefix, "/")+"/"+strconv.Itoa(opts.PR))
+		}
+
+		if !CLI.NoDownload {
+			_, err := downloadPrefixesToCache(prPrefixes, opts.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download the build artifacts of the PR: %v\n", err)
+				exit(1)
+			}
+			_, err = downloadPRBuildArtifactsToCache(opts.BaselineLimit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		prResults, err := parseGinkgoResultsFromCache(prPrefixes, opts.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+		baseline, err := parseGinkgoResultsFromCache(ciBucketPrefixes, opts.BaselineLimit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		if CLI.Tests.ExcludeMassFailures {
+			prResults = excludeMassFailures(prResults)
+			baseline = excludeMassFailures(baseline)
+		}
+		attributions := attributeFailures(opts.PR, prResults, baseline, changed, opts.MinRuns, opts.Threshold)
+		switch CLI.Tests.Output {
+		case "json":
+			if attributions == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				attributions = []FailureAttribution{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(attributions)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+			defer w.Flush()
+
+			for _, a := range attributions {
+				var label string
+				switch a.Label {
+				case attributionPreExisting:
+					label = green("likely pre-existing flake")
+				case attributionUnknown:
+					label = gray("not enough baseline")
+				case attributionIntroduced:
+					label = red("likely introduced by this PR")
+				}
+				numbers := fmt.Sprintf("%d/%d failed in the PR, %d/%d in the periodics", a.PRFailures, a.PRRuns, a.BaselineFailures, a.BaselineRuns)
+				if a.Label != attributionUnknown {
+					numbers += fmt.Sprintf(" (chance %.1f%%)", 100*a.Chance)
+				}
+				if len(a.ChangedFiles) > 0 {
+					numbers += ", touches " + strings.Join(a.ChangedFiles, ", ")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s: %s%s\n", label, gray(numbers), a.Name, gray(fitErr(a.Err, "\t\t")), link(a.Source))
+			}
+		}
+
+	case "tests mass-failures":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.MassFailures.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.MassFailures.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		builds := tagMassFailures(results, CLI.Tests.MassFailureThreshold)
+		switch CLI.Tests.Output {
+		case "json":
+			if builds == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				builds = []MassFailureBuild{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(builds)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+			defer w.Flush()
+
+			// Any artifact of the build will do to find its Spyglass URL.
+			sources := make(map[string]string)
+			for _, res := range results {
+				sources[res.Job+"/"+strconv.Itoa(res.Build)] = res.Source
+			}
+			for _, build := range builds {
+				fmt.Fprintf(w, "%s\t%d/%d\t%s\t%s\t%d: %s%s\n",
+					red(fmt.Sprintf("%.0f%%", 100*build.FailureRate)),
+					build.Failed, build.Runs,
+					formatTime(build.Started),
+					build.Job, build.Build,
+					gray(fitErr(build.TopError, "\t\t\t\t")),
+					link(spyglassURL(sources[build.Job+"/"+strconv.Itoa(build.Build)])),
+				)
+			}
+		}
+
+	case "tests summary":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.Summary.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.Summary.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		summary := computeStatsSummary(results)
+		scaleStatsSummary(&summary, sampleFraction)
+		switch CLI.Tests.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(summary)
+		case "text":
+			err = printStatsSummary(os.Stdout, summary, errSources(results)[summary.TopError])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "tests list":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Tests.List.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Tests.List.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		var filtered []GinkgoResult
+		for _, res := range results {
+			if !strings.Contains(res.Name, CLI.Tests.List.Name) {
+				continue
+			}
+
+			if CLI.Tests.List.OnlyFailed && !res.Status.isFailed() {
+				continue
+			}
+
+			filtered = append(filtered, res)
+		}
+		results = filtered
+
+		sort.SliceStable(results, func(i, j int) bool {
+			return lessResult(results[i], results[j])
+		})
+
+		switch CLI.Tests.Output {
+		case "json":
+			if results == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				results = []GinkgoResult{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(results)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+			defer w.Flush()
+
+			// The continuation lines of the wrapped error messages must skip
+			// the --wide columns.
+			cont := "\t"
+			if CLI.Tests.Wide {
+				cont = "\t\t\t\t\t"
+			}
+			for _, res := range results {
+				switch res.Status {
+				case statusPassed:
+					fmt.Fprintf(w, "%s %s\t%s%s%s\n", icon(statusPassed), green(formatDuration(res.Duration)), wideColumns(res), res.Name, link(res.Source))
+				case statusFailed, statusTimedOut, statusPanicked:
+					fmt.Fprintf(w, "%s %s\t%s%s: %s%s\n", icon(res.Status), red(formatDuration(res.Duration)), wideColumns(res), res.Name, gray(fitErr(res.Err, cont)), link(res.Source))
+				case statusError:
+					fmt.Fprintf(w, "%s %s\t%s%s: %s%s\n", icon(statusError), blue(formatDuration(res.Duration)), wideColumns(res), res.Name, gray(fitErr(res.Err, cont)), link(res.Source))
+				default:
+					panic("developer mistake: unknown status: " + res.Status)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "builds list":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Builds.List.Limit, isProwJobFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseBuildsFromCache(ciBucketPrefixes, CLI.Builds.List.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+
+		switch CLI.Builds.Output {
+		case "json":
+			if results == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				results = []BuildResult{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(results)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			for _, res := range results {
+				pr := "-"
+				if res.PR != 0 {
+					pr = fmt.Sprintf("#%d", res.PR)
+				}
+				switch res.Status {
+				case BuildSuccess:
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(res.Started), green(formatDuration(time.Duration(res.Duration)*time.Second)), pr, gray(res.Build), res.JobName, blue(res.URL))
+				case BuildFailed:
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s: %s\n", formatTime(res.Started), red(formatDuration(time.Duration(res.Duration)*time.Second)), pr, gray(res.Build), res.JobName, blue(res.URL), gray(res.Err))
+				default:
+					panic("developer mistake: unknown status: " + res.Status)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "builds analyze <url>":
+		// Only the given build is looked at, whatever the selection flags.
+		_, _, build, err := parseObjectName(analyzeDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		onlyBuilds = map[int]struct{}{build: {}}
+		onlyJobs = nil
+		since, until = time.Time{}, time.Time{}
+
+		if !CLI.NoDownload {
+			_, err := downloadBuildToCache(analyzeDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		// The builds of a job are the directories right under the job.
+		prefixes := []string{path.Dir(analyzeDir)}
+		builds, err := parseBuildsFromCache(prefixes, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+		results, err := parseGinkgoResultsFromCache(prefixes, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		analysis := analyzeBuild(analyzeDir, builds, results)
+		switch CLI.Builds.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(analysis)
+		case "text":
+			err = printBuildAnalysis(os.Stdout, analysis)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "builds most-failures":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Builds.MostFailures.Limit, isProwJobFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseBuildsFromCache(ciBucketPrefixes, CLI.Builds.MostFailures.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+
+		stats := computeJobFailures(results)
+		switch CLI.Builds.Output {
+		case "json":
+			if stats == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				stats = []StatsJobFailures{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			for _, stat := range stats {
+				topErr := ""
+				if stat.TopErrCount > 0 {
+					topErr = ": " + gray(fmt.Sprintf("%s (%d×)", stat.TopErr, stat.TopErrCount))
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s%s\n",
+					red(fmt.Sprintf("%.0f%%", 100*stat.FailureRate)),
+					gray(fmt.Sprintf("%d/%d failed", stat.Failed, stat.Passed+stat.Failed)),
+					gray("avg "+formatDuration(time.Duration(stat.AvgDuration)*time.Second)),
+					stat.Job,
+					topErr,
+				)
+			}
+		}
+
+	case "builds durations":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Builds.Durations.Limit, isProwJobFile)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseBuildsFromCache(ciBucketPrefixes, CLI.Builds.Durations.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+
+		stats := computeBuildDurations(results)
+		switch CLI.Builds.Output {
+		case "json":
+			if stats == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				stats = []StatsBuildDurations{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			seconds := func(n int) string { return formatDuration(time.Duration(n) * time.Second) }
+			for _, stat := range stats {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					red("waited "+seconds(stat.MedianPending)),
+					gray("(max "+seconds(stat.MaxPending)+")"),
+					"ran "+seconds(stat.MedianDuration),
+					gray(fmt.Sprintf("(max %s, %d builds)", seconds(stat.MaxDuration), stat.Builds)),
+					stat.Job,
+				)
+			}
+		}
+
+	case "builds retests":
+		if !CLI.NoDownload {
+			_, err := downloadBuildArtifactsToCache(prBucketPrefixes, CLI.Builds.Retests.Limit, regexp.MustCompile(isToBeDownloaded.String()+"|"+isProwJobFile.String()))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		builds, err := parseBuildsFromCache(prBucketPrefixes, CLI.Builds.Retests.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+		results, err := parseGinkgoResultsFromCache(prBucketPrefixes, CLI.Builds.Retests.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		stats := computeRetests(builds, results)
+		switch CLI.Builds.Output {
+		case "json":
+			// Force the encoded JSON to show "[]" instead of "null".
+			if stats.PRs == nil {
+				stats.PRs = []PRRetests{}
+			}
+			if stats.Months == nil {
+				stats.Months = []MonthRetests{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			seconds := func(n int) string { return formatDuration(time.Duration(n) * time.Second) }
+			topTests := func(tests []NameCount) string {
+				var names []string
+				for i, test := range tests {
+					if i == 3 {
+						names = append(names, fmt.Sprintf("and %d more", len(tests)-i))
+						break
+					}
+					names = append(names, fmt.Sprintf("%s (%d)", test.Name, test.Count))
+				}
+				return strings.Join(names, ", ")
+			}
+			for _, stat := range stats.PRs {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					red(fmt.Sprintf("%d re-runs", stat.Retests)),
+					gray("("+seconds(stat.Wasted)+" of CI)"),
+					fmt.Sprintf("#%d", stat.PR)+link(fmt.Sprintf("https://github.com/%s/pull/%d", githubRepo, stat.PR)),
+					topTests(stat.Tests),
+				)
+			}
+			if len(stats.Months) > 0 {
+				fmt.Fprintln(w)
+			}
+			for _, stat := range stats.Months {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					stat.Month,
+					red(fmt.Sprintf("%d re-runs in %d PRs", stat.Retests, stat.PRs)),
+					gray("("+seconds(stat.Wasted)+" of CI)"),
+					topTests(stat.Tests),
+				)
+			}
+		}
+
+	case "builds storms":
+		if !CLI.NoDownload {
+			_, err := downloadBuildArtifactsToCache(prBucketPrefixes, CLI.Builds.Storms.Limit, regexp.MustCompile(isToBeDownloaded.String()+"|"+isProwJobFile.String()))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download build artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		builds, err := parseBuildsFromCache(prBucketPrefixes, CLI.Builds.Storms.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+		results, err := parseGinkgoResultsFromCache(prBucketPrefixes, CLI.Builds.Storms.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		storms := computeRetestStorms(builds, results, CLI.Builds.Storms.MaxRetests, CLI.Builds.Storms.Window)
+		switch CLI.Builds.Output {
+		case "json":
+			if storms == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				storms = []RetestStorm{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(storms)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			for i, storm := range storms {
+				if i > 0 {
+					fmt.Fprintln(w)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					red(fmt.Sprintf("%d re-runs", storm.Retests)),
+					gray(fmt.Sprintf("(in %s, last %s)", formatDuration(storm.Last.Sub(storm.First)), formatTime(storm.Last))),
+					fmt.Sprintf("#%d", storm.PR)+link(fmt.Sprintf("https://github.com/%s/pull/%d", githubRepo, storm.PR)),
+					storm.Job,
+				)
+				for _, test := range storm.Tests {
+					fmt.Fprintf(w, "  %s\t%s\n", red(test.Count), test.Name)
+				}
+				for _, url := range storm.Builds {
+					fmt.Fprintf(w, "  %s\n", blue(url))
+				}
+			}
+		}
+
+	case "jobs coverage":
+		var listed map[string]int
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Jobs.Coverage.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+			listed, err = countBucketBuilds(ciBucketPrefixes, CLI.Jobs.Coverage.Limit)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to list the builds: %v\n", err)
+				exit(1)
+			}
+		}
+
+		coverage, err := computeJobCoverage(ciBucketPrefixes, CLI.Jobs.Coverage.Limit, listed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+		switch CLI.Jobs.Coverage.Output {
+		case "json":
+			if coverage == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				coverage = []jobCoverage{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(coverage)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			for _, cov := range coverage {
+				builds := "?"
+				if cov.Builds >= 0 {
+					builds = strconv.Itoa(cov.Builds)
+				}
+				parsed := green(fmt.Sprintf("%d parsed", cov.Parsed))
+				if cov.Builds < 0 && cov.Parsed < cov.Downloaded || cov.Builds >= 0 && cov.Parsed < cov.Builds {
+					parsed = red(fmt.Sprintf("%d parsed", cov.Parsed))
+				}
+				parseErrs := ""
+				if cov.ParseErrors > 0 {
+					parseErrs = red(fmt.Sprintf("%d parse errors", cov.ParseErrors))
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", cov.Prefix, gray(builds+" builds"), gray(fmt.Sprintf("%d downloaded", cov.Downloaded)), parsed, parseErrs)
+			}
+		}
+
+	case "jobs suites":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Jobs.Suites.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		suites, err := parseSuiteDurationsFromCache(ciBucketPrefixes, CLI.Jobs.Suites.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch the suite durations from files: %v\n", err)
+			exit(1)
+		}
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Jobs.Suites.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		stats := computeSuiteOverhead(suites, results)
+		switch CLI.Jobs.Suites.Output {
+		case "json":
+			if stats == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				stats = []StatsSuiteOverhead{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			seconds := func(n int) string { return formatDuration(time.Duration(n) * time.Second) }
+			for _, stat := range stats {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+					red(fmt.Sprintf("%.0f%% overhead", 100*stat.Overhead)),
+					gray("suite "+seconds(stat.AvgSuite)),
+					gray("specs "+seconds(stat.AvgSpecs)),
+					gray(fmt.Sprintf("%d builds", stat.Builds)),
+					stat.Job,
+				)
+			}
+		}
+
+	case "jobs health":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Jobs.Health.Limit, regexp.MustCompile(isToBeDownloaded.String()+"|"+isProwJobFile.String()))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		builds, err := parseBuildsFromCache(ciBucketPrefixes, CLI.Jobs.Health.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch build results from files: %v\n", err)
+			exit(1)
+		}
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Jobs.Health.Limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		stats := computeJobHealth(builds, results, CLI.Jobs.Health.Recent, HealthWeights{
+			Failure: CLI.Jobs.Health.FailureWeight,
+			Infra:   CLI.Jobs.Health.InfraWeight,
+			Trend:   CLI.Jobs.Health.TrendWeight,
+			Flake:   CLI.Jobs.Health.FlakeWeight,
+		})
+		switch CLI.Jobs.Health.Output {
+		case "json":
+			if stats == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				stats = []JobHealth{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(stats)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			percent := func(f float64) string { return fmt.Sprintf("%.0f%%", 100*f) }
+			for _, stat := range stats {
+				score := green(fmt.Sprintf("%.0f", stat.Score))
+				if stat.Score < 80 {
+					score = red(fmt.Sprintf("%.0f", stat.Score))
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					score,
+					gray("failed "+percent(stat.FailureRate)),
+					gray("infra "+percent(stat.InfraFailureRate)),
+					gray("trend "+percent(stat.DurationTrend)),
+					gray("flaky "+percent(stat.FlakeRate)),
+					gray(fmt.Sprintf("%d builds", stat.Builds)),
+					stat.Job,
+				)
+			}
+		}
+
+	case "export series":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Export.Series.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Export.Series.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		err = json.NewEncoder(os.Stdout).Encode(toSeries(results, CLI.Export.Series.Test))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "snapshot":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Snapshot.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Snapshot.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		snapshot := takeSnapshot(results, time.Now())
+		path, err := writeSnapshot(CLI.Snapshot.Dir, snapshot)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		fmt.Printf("Snapshot written to %s.\n", path)
+
+	case "diff <old> <new>":
+		old, err := readSnapshot(CLI.Diff.Old)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+		new, err := readSnapshot(CLI.Diff.New)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+		changes := diffSnapshots(old, new)
+		switch CLI.Diff.Output {
+		case "json":
+			if changes == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				changes = []SnapshotChange{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(changes)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			defer w.Flush()
+
+			for _, change := range changes {
+				delta := fmt.Sprintf("%+.0f%%", 100*(change.NewFailureRate-change.OldFailureRate))
+				if change.NewFailureRate > change.OldFailureRate {
+					delta = red(delta)
+				} else {
+					delta = green(delta)
+				}
+				fmt.Fprintf(w, "%s\t%.0f%% → %.0f%%\t%s\n", delta, 100*change.OldFailureRate, 100*change.NewFailureRate, change.Name)
+			}
+		}
+
+	case "errors history <fingerprint>":
+		if CLI.Errors.History.Threshold < 0 || CLI.Errors.History.Threshold > 1 {
+			fmt.Fprintf(os.Stderr, "error: --threshold must be between 0 and 1, got %v\n", CLI.Errors.History.Threshold)
+			exit(1)
+		}
+
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.Errors.History.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Errors.History.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		history, found := errorHistory(results, CLI.Errors.History.Fingerprint, CLI.Errors.History.Threshold)
+		if !found {
+			fmt.Fprintf(os.Stderr, "error: no error message has the fingerprint %s in the last %d builds, run 'prowdig tests clusters' to list the fingerprints\n", CLI.Errors.History.Fingerprint, CLI.Errors.History.Limit)
+			exit(1)
+		}
+
+		switch CLI.Errors.History.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(history)
+		case "text":
+			err = printErrorHistory(os.Stdout, history)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "report":
+		self, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+		profiles := []string{defaultProfile}
+		if CLI.Profile != "" {
+			profiles = []string{CLI.Profile}
+		}
+		if CLI.Report.AllProfiles {
+			profiles = profileNames(config)
+		}
+
+		// The flags that change what is analyzed are passed down to each
+		// prowdig process. The environment variables were already read, which
+		// is why they are not passed down.
+		args := []string{"--plain", "--cache-dir=" + cacheRoot}
+		if CLI.Config != "" {
+			args = append(args, "--config="+configFile)
+		}
+		if CLI.Days > 0 {
+			args = append(args, "--days="+strconv.Itoa(CLI.Days))
+		}
+		// The window is passed down once resolved so that all the processes
+		// agree on it, even when it is relative to now.
+		if CLI.Since != "" {
+			args = append(args, "--since="+since.Format(time.RFC3339))
+		}
+		if CLI.Until != "" {
+			args = append(args, "--until="+until.Format(time.RFC3339))
+		}
+		if CLI.Job != "" {
+			args = append(args, "--job="+CLI.Job)
+		}
+		if CLI.TailBytes != "" {
+			args = append(args, "--tail-bytes="+CLI.TailBytes)
+		}
+		if CLI.Sample != "" {
+			args = append(args, "--sample="+CLI.Sample, "--seed="+strconv.FormatInt(CLI.Seed, 10))
+		}
+		for _, flag := range []struct {
+			name  string
+			isSet bool
+		}{
+			{"--no-download", CLI.NoDownload},
+			{"--read-only-cache", CLI.ReadOnlyCache},
+			{"--wait-for-lock", CLI.WaitForLock},
+			{"--strict", CLI.Strict},
+			{"--allow-duplicates", CLI.AllowDuplicates},
+			{"--no-index", CLI.NoIndex},
+		} {
+			if flag.isSet {
+				args = append(args, flag.name)
+			}
+		}
+		if !CLI.Report.AllProfiles {
+			if CLI.Bucket != "" {
+				args = append(args, "--bucket="+CLI.Bucket)
+			}
+			if CLI.Storage != "" {
+				args = append(args, "--storage="+CLI.Storage)
+			}
+			if CLI.S3Endpoint != "" {
+				args = append(args, "--s3-endpoint="+CLI.S3Endpoint)
+			}
+			if len(CLI.PRPrefixes) > 0 {
+				args = append(args, "--pr-prefixes="+strings.Join(CLI.PRPrefixes, ","))
+			}
+			if len(CLI.CIPrefixes) > 0 {
+				args = append(args, "--ci-prefixes="+strings.Join(CLI.CIPrefixes, ","))
+			}
+			if CLI.ProwConfig != "" {
+				args = append(args, "--prow-config="+CLI.ProwConfig)
+			}
+		}
+		args = append(args, "tests", "summary", "--limit="+strconv.Itoa(CLI.Report.Limit), "--output=json")
+
+		reports := reportProfiles(self, config, profiles, args)
+		switch CLI.Report.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(reports)
+		case "text":
+			for i, report := range reports {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%s\n", report.Profile)
+				if report.Err != "" {
+					fmt.Printf("%s\n", red("error: "+report.Err))
+					continue
+				}
+				err = printStatsSummary(os.Stdout, *report.Summary, "")
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			exit(1)
+		}
+
+	case "parse-errors":
+		if !CLI.NoDownload {
+			_, err := downloadPRBuildArtifactsToCache(CLI.ParseErrors.Limit, isToBeDownloaded)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to download job artifacts: %v\n", err)
+				exit(1)
+			}
+		}
+
+		_, parseErrs, err := parseArtifactsFromCache(ciBucketPrefixes, CLI.ParseErrors.Limit)
+		if err == errExplained {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to fetch ginkgo results from files: %v\n", err)
+			exit(1)
+		}
+
+		switch CLI.ParseErrors.Output {
+		case "json":
+			if parseErrs == nil {
+				// Force the encoded JSON to show "[]" instead of "null".
+				parseErrs = []parseError{}
+			}
+			err = json.NewEncoder(os.Stdout).Encode(parseErrs)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			for _, parseErr := range parseErrs {
+				fmt.Printf("%s %s\n", blue(parseErr.Source), red(parseErr.Err))
+			}
+		}
+
+	case "completion <shell>":
+		switch CLI.Completion.Shell {
+		case "bash":
+			_, _ = io.WriteString(os.Stdout, bashCompletion)
+		case "zsh":
+			_, _ = io.WriteString(os.Stdout, "autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion)
+		}
+
+	case "complete <kind>":
+		// Completion must be fast and must not print anything on stderr, so
+		// we never download anything here.
+		results, err := parseGinkgoResultsFromCache(ciBucketPrefixes, CLI.Complete.Limit)
+		if err != nil {
+			exit(1)
+		}
+		for _, candidate := range completionCandidates(results, CLI.Complete.Kind) {
+			fmt.Println(candidate)
+		}
+
+	case "cache export <file>":
+		count, size, err := exportCache(CLI.Cache.Export.File)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while exporting the cache to %s: %v\n", CLI.Cache.Export.File, err)
+			exit(1)
+		}
+		fmt.Printf("%d files (%s) exported to %s.\n", count, ByteCountSI(size), CLI.Cache.Export.File)
+
+	case "cache import <file>":
+		count, size, err := importCache(CLI.Cache.Import.File)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while importing the cache from %s: %v\n", CLI.Cache.Import.File, err)
+			exit(1)
+		}
+		fmt.Printf("%d files (%s) imported into %s.\n", count, ByteCountSI(size), cacheDir)
+
+	case "cache size":
+		info, err := computeCacheInfo(cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while reading the cache %s: %v\n", cacheDir, err)
+			exit(1)
+		}
+		fmt.Printf("%s\t%s\n", ByteCountSI(info.DiskSize), cacheDir)
+
+	case "cache prune":
+		age, err := parseAge(CLI.Cache.Prune.OlderThan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: --older-than: %v\n", err)
+			exit(1)
+		}
+		builds, bytes, err := pruneCache(cacheDir, time.Now().Add(-age))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while pruning the cache %s: %v\n", cacheDir, err)
+			exit(1)
+		}
+		fmt.Printf("%d builds older than %s removed (%s freed).\n", builds, CLI.Cache.Prune.OlderThan, ByteCountSI(bytes))
+
+	case "cache clear":
+		count, bytes, err := clearCache(cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while clearing the cache %s: %v\n", cacheDir, err)
+			exit(1)
+		}
+		fmt.Printf("%d artifacts removed from %s (%s freed).\n", count, cacheDir, ByteCountSI(bytes))
+
+	case "cache migrate":
+		from, done, err := migrateCache(cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while migrating the cache %s: %v\n", cacheDir, err)
+			exit(1)
+		}
+		if len(done) == 0 {
+			fmt.Printf("%s already uses the layout %d.\n", cacheDir, cacheLayout)
+			break
+		}
+		for _, m := range done {
+			fmt.Printf("Layout %d -> %d: %s.\n", m.from, m.from+1, m.description)
+		}
+		fmt.Printf("%s migrated from the layout %d to the layout %d.\n", cacheDir, from, cacheLayout)
+
+	case "cache info":
+		info, err := computeCacheInfo(cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: while reading the cache %s: %v\n", cacheDir, err)
+			exit(1)
+		}
+
+		switch CLI.Cache.Info.Output {
+		case "json":
+			err = json.NewEncoder(os.Stdout).Encode(info)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				exit(1)
+			}
+		case "text":
+			fmt.Printf("Cache directory: %s (layout %d)\n", info.Dir, info.Layout)
+			fmt.Printf("%d artifacts in %d builds (%s).\n", info.Artifacts, info.Builds, ByteCountSI(info.Size))
+			fmt.Printf("%s on disk, %s saved by storing the identical artifacts once.\n", ByteCountSI(info.DiskSize), green(ByteCountSI(info.Size-info.DiskSize)))
+		}
+
+	default:
+		panic("developer mistake: " + kongctx.Command())
+	}
+}
+
+// Config is the content of the file ~/.config/prowdig/config.yaml. It looks
+// like this:
+//
+//	profiles:
+//	  istio:
+//	    bucket: istio-prow
+//	    deckURL: https://prow.istio.io
+//	    githubRepo: istio/istio
+//	    prPrefixes:
+//	      - pr-logs/pull/istio_istio
+//	    ciPrefixes:
+//	      - logs/integ-k8s-124_istio_postsubmit
+//	    prefixAliases:
+//	      pr-logs/pull/istio_old-istio: pr-logs/pull/istio_istio
+type Config struct {
+	Profiles map[string]Profile `yaml:"profiles"`
+
+	// (optional) Maximum size of the cache directory, e.g. "10GB". The
+	// --max-cache-size flag takes precedence.
+	MaxCacheSize string `yaml:"maxCacheSize"`
+}
+
+// A Profile groups the settings needed to dig into the Prow deployment of
+// a given project.
+type Profile struct {
+	// The name of the GCS bucket in which Prow uploads the artifacts, e.g.
+	// "jetstack-logs". When the artifacts are stored in S3 or MinIO, the
+	// name is prefixed with s3://, e.g. "s3://prow-logs".
+	Bucket string `yaml:"bucket"`
+
+	// (optional) The URL of the S3-compatible store, e.g.
+	// "http://minio.example.com:9000".
+	S3Endpoint string `yaml:"s3Endpoint,omitempty"`
+
+	// The prefixes under which the presubmit builds are stored, e.g.
+	// "pr-logs/pull/cert-manager_cert-manager".
+	PRPrefixes []string `yaml:"prPrefixes"`
+
+	// The prefixes under which the periodic builds are stored, e.g.
+	// "logs/ci-cert-manager-e2e-v1-24".
+	CIPrefixes []string `yaml:"ciPrefixes"`
+
+	// (optional) The URL of Deck, e.g. "https://prow.build-infra.jetstack.net".
+	DeckURL string `yaml:"deckURL"`
+
+	// (optional) The GitHub repository, e.g. "cert-manager/cert-manager".
+	GitHubRepo string `yaml:"githubRepo"`
+
+	// (optional) Prefixes that must be treated as the same prefix, e.g. after
+	// a GitHub organization was renamed. The key is the old prefix, the value
+	// is the new prefix:
+	//
+	//	prefixAliases:
+	//	  pr-logs/pull/jetstack_cert-manager: pr-logs/pull/cert-manager_cert-manager
+	PrefixAliases map[string]string `yaml:"prefixAliases"`
+
+	// (optional) Regular expressions matching the names of the artifacts
+	// that prowdig reads. The defaults work for cert-manager; the upstream
+	// Kubernetes jobs, for example, name their junit files junit_01.xml or
+	// junit_runner.xml:
+	//
+	//	patterns:
+	//	  junit: 'junit_.*\.xml$'
+	Patterns struct {
+		// Defaults to 'junit__.*\.xml$'.
+		Junit string `yaml:"junit"`
+
+		// Defaults to 'build-log\.txt$'.
+		BuildLog string `yaml:"buildLog"`
+
+		// Defaults to 'prowjob\.json$'. There is one such file per build,
+		// which is what prowdig uses to count the builds.
+		ProwJob string `yaml:"prowJob"`
+	} `yaml:"patterns"`
+
+	// (optional) Rules that extract dimensions from the test names, e.g. the
+	// issuer type. The key is the name of the dimension, and the value is a
+	// regular expression whose first group is the value of the dimension.
+	// When set, the built-in cert-manager rules are replaced:
+	//
+	//	dimensions:
+	//	  issuer: 'with issuer type (.+?) (?:Cluster)?Issuer'
+	Dimensions map[string]string `yaml:"dimensions"`
+
+	// (optional) Rules that assign an owner to the tests, e.g. the team in
+	// charge of them. Each test is owned by the first rule whose regular
+	// expression matches its name:
+	//
+	//	owners:
+	//	  - owner: team-vault
+	//	    tests: 'Vault Issuer'
+	//	  - owner: team-acme
+	//	    tests: 'ACME|HTTP01|DNS01'
+	Owners []OwnerRule `yaml:"owners"`
+}
+
+// OwnerRule assigns an owner to the tests whose name matches the regular
+// expression Tests.
+type OwnerRule struct {
+	Owner string `yaml:"owner"`
+	Tests string `yaml:"tests"`
+}
+
+// ownerRule is the compiled form of OwnerRule.
+type ownerRule struct {
+	owner string
+	tests *regexp.Regexp
+}
+
+// The built-in profile, used when no --profile is given. It can be selected
+// explicitly with --profile=cert-manager, and can be overridden in the config
+// file.
+const defaultProfile = "cert-manager"
+
+// profileNames returns the name of the built-in profile followed by the names
+// of the profiles of the config file, sorted alphabetically.
+func profileNames(config Config) []string {
+	var names []string
+	for name := range config.Profiles {
+		if name == defaultProfile {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{defaultProfile}, names...)
+}
+
+// writeStarterConfig writes a config file that contains the built-in
+// cert-manager profile.
+func writeStarterConfig(file string) error {
+	config := Config{Profiles: map[string]Profile{
+		defaultProfile: {
+			Bucket:        bucketName,
+			PRPrefixes:    prBucketPrefixes,
+			CIPrefixes:    ciBucketPrefixes,
+			DeckURL:       deckURL,
+			GitHubRepo:    githubRepo,
+			PrefixAliases: prefixAliases,
+		},
+	}}
+
+	buf := bytes.NewBufferString("# Select a profile with 'prowdig --profile=<name>'. See\n# https://github.com/maelvls/prowdig for the list of fields.\n")
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	err := enc.Encode(config)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// ProwConfig is the subset of Prow's config.yaml that tells us where the
+// builds are stored. The job config and the main config are often kept in
+// the same file; when they aren't, the bucket can't be found and the current
+// bucket is kept.
+type ProwConfig struct {
+	// The key is the repository, e.g. "cert-manager/cert-manager".
+	Presubmits  map[string][]ProwJobConfig `yaml:"presubmits"`
+	Postsubmits map[string][]ProwJobConfig `yaml:"postsubmits"`
+	Periodics   []ProwJobConfig            `yaml:"periodics"`
+
+	Plank struct {
+		// The key is "*" or a repository, e.g. "cert-manager/cert-manager".
+		DefaultDecorationConfigs map[string]ProwDecorationConfig `yaml:"default_decoration_configs"`
+	} `yaml:"plank"`
+}
+
+type ProwJobConfig struct {
+	Name             string                `yaml:"name"`
+	DecorationConfig *ProwDecorationConfig `yaml:"decoration_config"`
+}
+
+type ProwDecorationConfig struct {
+	GCSConfiguration struct {
+		// Either "my-bucket" or "gs://my-bucket".
+		Bucket string `yaml:"bucket"`
+	} `yaml:"gcs_configuration"`
+}
+
+// prefixes returns the bucket and the prefixes under which Prow stores the
+// builds of the jobs, assuming the default "explicit" path strategy:
+//
+//	pr-logs/pull/<org>_<repo>/<pr>/<job>/<build>/   for presubmits
+//	logs/<job>/<build>/                             for postsubmits and periodics
+//
+// The bucket is taken from the default decoration config "*", or from the
+// first job that has its own decoration config. The prefixes are sorted.
+func (c ProwConfig) prefixes() (bucket string, prPrefixes, ciPrefixes []string) {
+	bucketOf := func(d *ProwDecorationConfig) string {
+		if d == nil {
+			return ""
+		}
+		// The bucket is prefixed with s3:// when the artifacts are stored
+		// in S3, in which case the prefix is kept for parseBucket.
+		return strings.TrimSuffix(strings.TrimPrefix(d.GCSConfiguration.Bucket, "gs://"), "/")
+	}
+	if d, ok := c.Plank.DefaultDecorationConfigs["*"]; ok {
+		bucket = bucketOf(&d)
+	}
+
+	for repo, jobs := range c.Presubmits {
+		prPrefixes = append(prPrefixes, "pr-logs/pull/"+strings.ReplaceAll(repo, "/", "_"))
+		for _, job := range jobs {
+			if bucket == "" {
+				bucket = bucketOf(job.DecorationConfig)
+			}
+		}
+	}
+
+	var ciJobs []ProwJobConfig
+	for _, jobs := range c.Postsubmits {
+		ciJobs = append(ciJobs, jobs...)
+	}
+	ciJobs = append(ciJobs, c.Periodics...)
+	for _, job := range ciJobs {
+		ciPrefixes = append(ciPrefixes, "logs/"+job.Name)
+		if bucket == "" {
+			bucket = bucketOf(job.DecorationConfig)
+		}
+	}
+
+	sort.Strings(prPrefixes)
+	sort.Strings(ciPrefixes)
+	return bucket, prPrefixes, ciPrefixes
+}
+
+// readLocation reads a file that is either stored in a GCS bucket
+// (gs://bucket/path), in an S3 bucket (s3://bucket/path), served over
+// HTTP(S), or stored locally.
+func readLocation(location string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, object := splitGCSURL(strings.TrimPrefix(location, "s3://"))
+		store, err := newS3Store(bucket, s3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		r, err := store.ReadObject(context.Background(), object)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", location, err)
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case strings.HasPrefix(location, "gs://"):
+		bucket, object := splitGCSURL(location)
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCS client: %w", err)
+		}
+		defer client.Close()
+		r, err := client.Bucket(bucket).Object(object).NewReader(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %w", location, err)
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"):
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("while fetching %s: %s", location, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	default:
+		return ioutil.ReadFile(location)
+	}
+}
+
+// homeDir returns $HOME. When prowdig runs in a container, $HOME may not be
+// set, in which case the temporary directory is used instead.
+func homeDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return os.TempDir()
+	}
+	return home
+}
+
+// loadConfig reads the config file. A missing config file is not an error;
+// an empty config is returned instead.
+func loadConfig(file string) (Config, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read the config file: %w", err)
+	}
+
+	var config Config
+	err = yaml.Unmarshal(bytes, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse the config file %s: %w", file, err)
+	}
+
+	return config, nil
+}
+
+// useProfile replaces the built-in settings (bucketName, prBucketPrefixes,
+// ciBucketPrefixes, deckURL, githubRepo) with the ones from the given
+// profile. The cache directory becomes ~/.cache/prowdig/<profile>/<bucket> so
+// that the profiles don't step on each other's toes.
+func useProfile(config Config, name string) error {
+	profile, ok := config.Profiles[name]
+	switch {
+	case !ok && name == defaultProfile:
+		profile = Profile{
+			Bucket:        bucketName,
+			PRPrefixes:    prBucketPrefixes,
+			CIPrefixes:    ciBucketPrefixes,
+			DeckURL:       deckURL,
+			GitHubRepo:    githubRepo,
+			PrefixAliases: prefixAliases,
+		}
+	case !ok:
+		return fmt.Errorf("profile %q not found in %s, the known profiles are: %s", name, configFile, strings.Join(profileNames(config), ", "))
+	}
+
+	if profile.Bucket == "" {
+		return fmt.Errorf("profile %q in %s: the field 'bucket' is required", name, configFile)
+	}
+
+	scheme, bucket, err := parseBucket(profile.Bucket)
+	if err != nil {
+		return fmt.Errorf("profile %q in %s: %w", name, configFile, err)
+	}
+	storageScheme, bucketName = scheme, bucket
+	artifactsURL = strings.TrimSuffix(profile.Bucket, "/")
+	s3Endpoint = profile.S3Endpoint
+	prBucketPrefixes = profile.PRPrefixes
+	ciBucketPrefixes = profile.CIPrefixes
+	deckURL = strings.TrimSuffix(profile.DeckURL, "/")
+	githubRepo = profile.GitHubRepo
+	prefixAliases = profile.PrefixAliases
+
+	for _, pattern := range []struct {
+		field string
+		value string
+		re    **regexp.Regexp
+	}{
+		{"patterns.junit", profile.Patterns.Junit, &isJunitFile},
+		{"patterns.buildLog", profile.Patterns.BuildLog, &isBuildLogFile},
+		{"patterns.prowJob", profile.Patterns.ProwJob, &isProwJobFile},
+	} {
+		if pattern.value == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern.value)
+		if err != nil {
+			return fmt.Errorf("profile %q in %s: the field '%s' is not a valid regular expression: %w", name, configFile, pattern.field, err)
+		}
+		*pattern.re = re
+	}
+	isToBeDownloaded = regexp.MustCompile("(" + isJunitFile.String() + "|" + isArtifactsJunitFile.String() + "|" + isBuildLogFile.String() + ")")
+
+	if len(profile.Dimensions) > 0 {
+		rules := make(map[string]*regexp.Regexp)
+		for dim, value := range profile.Dimensions {
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return fmt.Errorf("profile %q in %s: the field 'dimensions.%s' is not a valid regular expression: %w", name, configFile, dim, err)
+			}
+			if re.NumSubexp() < 1 {
+				return fmt.Errorf("profile %q in %s: the field 'dimensions.%s' must have a group, e.g. 'with issuer type (.+?) Issuer'", name, configFile, dim)
+			}
+			rules[dim] = re
+		}
+		dimensions = rules
+	}
+
+	owners = nil
+	for i, rule := range profile.Owners {
+		if rule.Owner == "" {
+			return fmt.Errorf("profile %q in %s: the field 'owners[%d].owner' is required", name, configFile, i)
+		}
+		re, err := regexp.Compile(rule.Tests)
+		if err != nil {
+			return fmt.Errorf("profile %q in %s: the field 'owners[%d].tests' is not a valid regular expression: %w", name, configFile, i, err)
+		}
+		owners = append(owners, ownerRule{owner: rule.Owner, tests: re})
+	}
+
+	// The default profile keeps the cache directory used when --profile isn't
+	// given so that naming it explicitly doesn't start from an empty cache.
+	cacheDir = cacheRoot + "/" + name + "/" + bucketName
+	if name == defaultProfile {
+		cacheDir = cacheRoot + "/" + bucketName
+	}
+
+	return nil
+}
+
+// The functions that must be run before prowdig exits, including when
+// exiting early on errors. They are run in reverse order. Since exit may be
+// called from the signal handler, atExit is only accessed with atExitMu held.
+var (
+	atExitMu sync.Mutex
+	atExit   []func()
+)
+
+// onExit registers a function to be run by exit.
+func onExit(fn func()) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	atExit = append(atExit, fn)
+}
+
+// exit runs the atExit functions and then exits with the given code. Always
+// use exit instead of os.Exit. The atExit functions are only run once, even
+// when exit is called from several goroutines.
+func exit(code int) {
+	atExitMu.Lock()
+	fns := atExit
+	atExit = nil
+	atExitMu.Unlock()
+
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+	os.Exit(code)
+}
+
+// startPager starts $PAGER (or "less" if unset) and redirects os.Stdout to
+// it, similarly to what git does. The default options given to less (FRX)
+// make less exit right away when the output fits in the terminal, keep the
+// colors, and leave the output on the screen after exiting. The returned
+// function closes the pager's input and waits for the user to quit the pager.
+//
+// On Windows, where there is no sh, $PAGER is split on spaces and run
+// directly, and nothing is started when $PAGER is unset and less isn't
+// installed.
+func startPager() (func(), error) {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+		if _, err := exec.LookPath(pager); err != nil && runtime.GOOS == "windows" {
+			return func() {}, nil
+		}
+	}
+	args := strings.Fields(pager)
+	if len(args) == 0 || pager == "cat" {
+		return func() {}, nil
+	}
+
+	// Once started, sh can't tell us that the pager doesn't exist, and the
+	// output would be lost.
+	_, err := exec.LookPath(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the pager %q: %w", pager, err)
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command(args[0], args[1:]...)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+
+	// We don't use cmd.StdinPipe since os.Stdout needs to be an *os.File.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the pager: %w", err)
+	}
+	cmd.Stdin = r
+
+	err = cmd.Start()
+	if err != nil {
+		_ = r.Close()
+		_ = w.Close()
+		return nil, fmt.Errorf("failed to start the pager %q: %w", pager, err)
+	}
+	_ = r.Close()
+
+	stdout := os.Stdout
+	os.Stdout = w
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			_ = w.Close()
+			_ = cmd.Wait()
+			os.Stdout = stdout
+		})
+	}, nil
+}
+
+// loadTemplate parses the template <name>.tmpl of the given directory. The
+// names of the available templates are given when it doesn't exist.
+func loadTemplate(dir, name string) (*template.Template, error) {
+	path := filepath.Join(dir, name+".tmpl")
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		var names []string
+		for _, file := range files {
+			names = append(names, "custom:"+strings.TrimSuffix(filepath.Base(file), ".tmpl"))
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no template named %q, %s doesn't contain any .tmpl file", name, dir)
+		}
+		return nil, fmt.Errorf("no template named %q in %s, the available ones are: %s", name, dir, strings.Join(names, ", "))
+	}
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// startTemplate redirects os.Stdout to a pipe so that the JSON printed by the
+// command can be rendered with the given template, similarly to startPager.
+// The returned function restores os.Stdout and renders the JSON to it.
+func startTemplate(tmpl *template.Template) (func() error, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(&buf, r)
+		copied <- err
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	return func() error {
+		_ = w.Close()
+		os.Stdout = stdout
+		err := <-copied
+		if err != nil {
+			return fmt.Errorf("failed to read the output to be rendered: %w", err)
+		}
+		return renderTemplate(stdout, tmpl, &buf)
+	}, nil
+}
+
+// renderTemplate decodes the given JSON and executes the template with it.
+// The numbers are given to the template as int64 when they are integers, so
+// that the build IDs aren't shown as 1.542891685103538e+18, and as float64
+// otherwise. When the JSON contains several values, e.g. one per line, the
+// template is given the list of values.
+func renderTemplate(out io.Writer, tmpl *template.Template, in io.Reader) error {
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	var values []interface{}
+	for {
+		var value interface{}
+		err := dec.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode the output to be rendered: %w", err)
+		}
+		values = append(values, fromJSONNumbers(value))
+	}
+
+	var data interface{} = values
+	if len(values) == 1 {
+		data = values[0]
+	}
+	err := tmpl.Execute(out, data)
+	if err != nil {
+		return fmt.Errorf("failed to render the template: %w", err)
+	}
+	return nil
+}
+
+// fromJSONNumbers replaces the json.Number values with int64 or float64.
+func fromJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = fromJSONNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = fromJSONNumbers(v[k])
+		}
+	}
+	return value
+}
+
+// atomicFile is a temporary file that is renamed to its final path once it
+// is fully written. Readers of the final path never see a half-written file.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+// createAtomic creates the temporary file next to the given path so that the
+// final rename doesn't cross filesystems. Unlike ioutil.TempFile, which always
+// uses the mode 0600, the file is created with the mode 0666 minus the umask
+// like any other file, since the output files and the cache files are often
+// read by other users.
+func createAtomic(path string) (*atomicFile, error) {
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	seed := time.Now().UnixNano() + int64(os.Getpid())
+	for i := int64(0); i < 10000; i++ {
+		f, err := os.OpenFile(prefix+strconv.FormatInt(seed+i, 36), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the output file: %w", err)
+		}
+		return &atomicFile{File: f, path: path}, nil
+	}
+	return nil, fmt.Errorf("failed to create the output file: too many temporary files named %s*", prefix)
+}
+
+// Commit flushes the temporary file to disk and renames it to its final path.
+func (f *atomicFile) Commit() error {
+	err := f.Sync()
+	if err != nil {
+		f.Discard()
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	err = f.Close()
+	if err != nil {
+		f.Discard()
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	err = os.Rename(f.Name(), f.path)
+	if err != nil {
+		f.Discard()
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// Discard removes the temporary file. Does nothing if the file has already
+// been committed.
+func (f *atomicFile) Discard() {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
+
+// One ginkgo block looks like this:
+//
+//   - Failure [301.437 seconds]                          ^
+//     [Conformance] Certificates                           |
+//     test/e2e/framework/framework.go:287                  |
+//     with issuer type External ClusterIssuer            |
+//     test/e2e/suite/conformance/certificates.go:47      |
+//     should issue a cert with wildcard DNS Name [It]  |
+//     test/e2e/suite/conformance/certificates.go:105   |
+//     Unexpected error:                                |
+//     | "lines"
+//     <*errors.errorString | 0xc0001c07b0>: {      |
+//     s: "timed out waiting for the condition",|
+//     }                                            |
+//     timed out waiting for the condition          |
+//     occurred                                         |
+//     test/e2e/suite/conformance/certificates.go:522   |
+//     ------------------------------                       v
+type ginkgoBlock struct {
+	// Line number of the first line of the Ginkgo block as it appears in the
+	// build-log.txt file.
+	line int
+
+	// The lines of the ginkgo block, which starts with the line that starts with
+	// '• Failure [301.437 seconds]'. It does not include the ending marker
+	// '------------------------------'.
+	lines []string
+}
+
+// The function parseBuildLog parses the content of a build-log.txt file and
+// returns a slice of "ginkgo blocks". You don't need to remove ANSI color codes
+// that are printed by Ginkgo before giving the logs to this function.
+func parseBuildLog(buildLog []byte) ([]ginkgoBlock, error) {
+	// Since Ginkgo colors its output, we need to remove the ANSI escape codes.
+	buildLog = rmAnsiColors.ReplaceAll(buildLog, []byte(""))
+
+	var blocks []ginkgoBlock
+	scanner := bufio.NewScanner(bytes.NewReader(buildLog))
+	lineNo := 0
+	isContent := false
+	var body []string
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if !isContent && (bytes.HasPrefix(line, []byte("• Failure")) || bytes.HasPrefix(line, []byte("•! Panic")) || bytes.HasPrefix(line, []byte("•... Timeout"))) {
+			isContent = true
+		}
+
+		if isContent {
+			body = append(body, string(line))
+		}
+
+		if isContent && bytes.Equal(line, []byte("------------------------------")) {
+			blocks = append(blocks, ginkgoBlock{
+				line:  lineNo,
+				lines: body,
+			})
+			body = nil
+			isContent = false
+		}
+	}
+
+	if isContent {
+		return nil, fmt.Errorf("unexpected end of file, still waiting for the ginkgo block started at line %d to end with '------------------------------'", lineNo)
+	}
+
+	return blocks, nil
+}
+
+// Ginkgo v2 doesn't print the v1 blocks anymore. Until the v2 timeline is
+// supported, the failures are found in the summary printed at the end of the
+// run:
+//
+//	Summarizing 2 Failures:
+//	  [FAIL] [Conformance] Certificates with issuer type SelfSigned Issuer [It] should issue a basic certificate
+//	  test/e2e/suite/conformance/certificates/tests.go:153
+//	  [TIMEDOUT] [cert-manager] Vault Issuer [BeforeEach] should be ready with a valid AppRole
+//	  test/e2e/suite/issuers/vault/issuer.go:60
+//
+//	Ran 462 of 624 Specs in 1624.482 seconds
+//
+// The summary tells neither the duration nor the error message. The
+// uppercase labels are what tell v2 apart from v1, which also prints a
+// summary, but with "[Fail]" labels.
+type ginkgoV2Failure struct {
+	// Line number of the "[FAIL]" line in the build-log.txt file.
+	line   int
+	parsed parsedGinkgoBlock
+}
+
+var (
+	reGinkgoV2Summary = regexp.MustCompile(`^Summarizing \d+ Failures?:$`)
+	reGinkgoV2Failure = regexp.MustCompile(`^\s*\[(FAIL|TIMEDOUT|PANICKED|INTERRUPTED|ABORTED)\] (.*)$`)
+
+	// The node in which the failure happened, e.g. "[It]" or "[BeforeEach]".
+	reGinkgoV2Node = regexp.MustCompile(`\s\[(It|BeforeEach|JustBeforeEach|AfterEach|JustAfterEach|BeforeAll|AfterAll|DeferCleanup[^\]]*)\](\s|$)`)
+)
+
+// parseGinkgoV2Summary returns the failures listed in the "Summarizing N
+// Failures:" section of a Ginkgo v2 build-log.txt. Nothing is returned for the
+// Ginkgo v1 logs.
+func parseGinkgoV2Summary(buildLog []byte) []ginkgoV2Failure {
+	buildLog = rmAnsiColors.ReplaceAll(buildLog, []byte(""))
+	lines := strings.Split(string(buildLog), "\n")
+
+	var failures []ginkgoV2Failure
+	inSummary := false
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if reGinkgoV2Summary.MatchString(strings.TrimSpace(line)) {
+			inSummary = true
+			continue
+		}
+		if !inSummary {
+			continue
+		}
+
+		match := reGinkgoV2Failure.FindStringSubmatch(line)
+		if match == nil {
+			inSummary = false
+			continue
+		}
+
+		// The failures that happen in a setup node are shown as "error",
+		// like the "Failure in Spec Setup" blocks of Ginkgo v1.
+		status := statusFailed
+		switch match[1] {
+		case "TIMEDOUT":
+			status = statusTimedOut
+		case "PANICKED":
+			status = statusPanicked
+		}
+		name := match[2]
+		if node := reGinkgoV2Node.FindStringSubmatch(name); node != nil {
+			if node[1] != "It" && status == statusFailed {
+				status = statusError
+			}
+			name = strings.Replace(name, node[0], " ", 1)
+		}
+
+		lineNo := i + 1
+		errLoc := ""
+		if i+1 < len(lines) && !reGinkgoV2Failure.MatchString(lines[i+1]) {
+			errLoc = strings.TrimSpace(lines[i+1])
+			i++
+		}
+
+		failures = append(failures, ginkgoV2Failure{
+			line: lineNo,
+			parsed: parsedGinkgoBlock{
+				name:   strings.TrimSpace(name),
+				status: status,
+				errLoc: errLoc,
+			},
+		})
+	}
+	return failures
+}
+
+type parsedGinkgoBlock struct {
+	// The name of the test.
+	name     string
+	status   status
+	duration time.Duration
+	errStr   string
+	errLoc   string
+
+	// Only set for the tests parsed from junit files.
+	systemOut  string
+	systemErr  string
+	properties map[string]string
+	attempts   int
+}
+
+// The parseGinkgoBlock function parses the body of one ginkgo block, as defined
+// in the diagram above the ginkgoBlock struct.
+//
+// Note that the "[It]" suffixes are removed from the test names in order to
+// match the test name given in junit__0x.xml files.
+func parseGinkgoBlock(block ginkgoBlock) (parsedGinkgoBlock, error) {
+	if len(block.lines) < 2 {
+		return parsedGinkgoBlock{}, fmt.Errorf("a ginkgo block is at least 2 lines long, got: %s", strings.Join(block.lines, "\n"))
+	}
+
+	// • Failure [301.574 seconds]                          <- Header
+	// [Conformance] Certificates                            ^
+	// test/e2e/framework/framework.go:287                   |
+	//   with issuer type SelfSigned ClusterIssuer           | Name
+	//   test/e2e/suite/conformance/tests.go:47              |
+	//     should issue an ECDSA, defaulted cert [It]        |
+	//     test/e2e/suite/conformance/suite.go:105           v
+	//
+	//     failed to create issuer                          <- ErrHeader    ^
+	//     Unexpected error:                                 ^              |
+	//         <*errors.errorString | 0xc0001c07d0>: {       |              |
+	//             s: "timed out waiting for the condition", | Err          |
+	//         }                                             |              | optional
+	//         timed out waiting for the condition           |              |
+	//     occurred                                          V              |
+	//                                                                      |
+	//     test/e2e/suite/conformance/tests.go:149          <- ErrLoc       v
+	// ------------------------------                       <- Footer
+
+	// Header.
+	match := reGingkoBlockHeader.FindStringSubmatch(block.lines[0])
+	if len(match) != 3 {
+		return parsedGinkgoBlock{}, fmt.Errorf("ginkgo block header: expected %s, got: %s", reGingkoBlockHeader, block.lines[0])
+	}
+
+	var status status
+	switch {
+	case strings.HasPrefix(match[1], "Failure in Spec Setup"):
+		status = statusError
+	case match[1] == "Failure":
+		status = statusFailed
+	case strings.HasPrefix(match[1], "Panic"):
+		status = statusPanicked
+	case match[1] == "Timeout":
+		status = statusTimedOut
+	default:
+		return parsedGinkgoBlock{}, fmt.Errorf("ginkgo block header: expected 'Failure', 'Failure in Spec Setup', 'Panic', or 'Timeout', got: %s", match[1])
+	}
+
+	seconds, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return parsedGinkgoBlock{}, fmt.Errorf("ginkgo block header: expected a number of seconds, got: %s", match[2])
+	}
+	duration := fromSeconds(seconds)
+
+	// Footer.
+	if block.lines[len(block.lines)-1] != "------------------------------" {
+		return parsedGinkgoBlock{}, fmt.Errorf("expected the last line to be '------------------------------', block was: %s", strings.Join(block.lines, "\n"))
+	}
+
+	block.lines = block.lines[1 : len(block.lines)-1]
+
+	// Name.
+	var parts []string
+	i := 0
+	for i < len(block.lines)-1 &&
+		strings.HasPrefix(block.lines[i], strings.Repeat(" ", i)) &&
+		strings.HasPrefix(block.lines[i+1], strings.Repeat(" ", i)) {
+
+		parts = append(parts, strings.TrimPrefix(strings.TrimSuffix(block.lines[i], " [It]"), strings.Repeat(" ", i)))
+
+		i += 2
+	}
+	if i == 0 {
+		return parsedGinkgoBlock{}, fmt.Errorf("no name line found, remaining was: %s", strings.Join(block.lines, "\n"))
+	}
+
+	name := strings.Join(parts, " ")
+
+	// The Err and ErrLoc are optional.
+	if i >= len(block.lines) {
+		return parsedGinkgoBlock{
+			name:     name,
+			status:   status,
+			duration: duration,
+			errStr:   "",
+			errLoc:   "",
+		}, nil
+	}
+
+	indent := strings.Repeat(" ", i-2)
+
+	block.lines = block.lines[i:]
+	if block.lines[0] == "" {
+		block.lines = block.lines[1:]
+	}
+
+	// Now, let's remove the indentation of Err and ErrLoc.
+	for j := range block.lines {
+		block.lines[j] = strings.TrimPrefix(block.lines[j], indent)
+	}
+
+	// ErrLoc.
+	//
+	// We remove the ErrLoc lines from block.lines so that the remainder is
+	// only ErrHeader and Err.
+	errLoc := strings.TrimPrefix(block.lines[len(block.lines)-1], indent)
+	block.lines = block.lines[0 : len(block.lines)-2]
+
+	var errStr []string
+
+	// ErrHeader.
+	i = 0
+	for ; i < len(block.lines) && !strings.HasPrefix(block.lines[i], "Unexpected error:"); i++ {
+		if len(block.lines[i]) == 0 {
+			continue
+		}
+		errStr = append(errStr, block.lines[i])
+	}
+
+	if i == len(block.lines) {
+		return parsedGinkgoBlock{name: name, status: status, duration: duration, errStr: strings.Join(errStr, "\n"), errLoc: errLoc}, nil
+	}
+	block.lines = block.lines[i:len(block.lines)]
+
+	// Err.
+	//
+	// Now let's deal with this overly verbose "Unexpected error: ...
+	// occured". Note that the ErrHeader has already been dealt with.
+	//
+	//    Unexpected error:
+	//        <*errors.errorString | 0xc0001c07d0>: {             ^
+	//            s: "timed out waiting for the condition",       |  Pre
+	//        }                                                   |
+	//        timed out waiting for the condition                 v
+	//    occurred
+	//
+	// Notice the indentation of 4 spaces in the lines between "Unexpected
+	// error:" and "occured".
+	for i := range block.lines {
+		if !isParen.MatchString(block.lines[i]) {
+			continue
+		}
+		block.lines = block.lines[i+1 : len(block.lines)-1]
+		break
+	}
+	for i := range block.lines {
+		block.lines[i] = strings.TrimPrefix(block.lines[i], "    ")
+	}
+
+	errStr = append(errStr, block.lines...)
+	return parsedGinkgoBlock{name: name, status: status, duration: duration, errStr: strings.Join(errStr, "\n"), errLoc: errLoc}, nil
+}
+
+// downloadPRBuildArtifactsToCache is a slow function that reads the Google
+// Storage bucket, and downloads the files found in the bucket onto your
+// disk in the hardcoded directory ~/.cache/prowdig.
+//
+// The argument "limit" corresponds to the maximum number of Prow builds to
+// be downloaded. The earliest builds are downloaded first. By earliest
+// builds, we mean the builds with the lowest build number. To understand
+// better the "limit" parameter, let us see what happens when we list
+// builds using gsutil:
+//
+//	gsutil ls "gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/**" | grep "build-log\.txt$"
+//	                <-----------> <------------------------------------>
+//	                 bucket name               bucket prefix
+//
+// The output is:
+//
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
+//	                                                                                       <----------------->
+//	                                                                                           build number
+//
+// Using downloadPRBuildArtifactsToCache with the regex is "build-log\.txt$",
+// and the bucket is "jetstack-logs", you will see the following files
+// downloaded to ~/.cache/prowdig:
+//
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
+//
+// With limit=2, then only the last two build numbers get downloaded:
+//
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
+//	~/.config/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
+//
+// For example, the last item of that list will be found at the path:
+//
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/jetstack_cert-manager/2/pull-cert-manager-e2e-v1-13/245/build-log.txt
+//	<--------------> <----------->
+//	    hardcoded     bucket name
+//
+// The filter can be left nil.
+func downloadPRBuildArtifactsToCache(limit int, filter *regexp.Regexp) (downloadSummary, error) {
+	return downloadBuildArtifactsToCache(ciBucketPrefixes, limit, filter)
+}
+
+// downloadBuildArtifactsToCache is like downloadPRBuildArtifactsToCache but
+// lists the builds under the given prefixes, e.g. prBucketPrefixes when the
+// presubmits are needed.
+func downloadBuildArtifactsToCache(prefixes []string, limit int, filter *regexp.Regexp) (downloadSummary, error) {
+	bucket, err := newObjectStore()
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("error: %v\n", err)
+	}
+
+	objects, totalSize, err := listPRBuildObjects(bucket, prefixes, limit, filter)
+	if err != nil {
+		return downloadSummary{}, err
+	}
+
+	return downloadObjectsToCache(bucket, objects, totalSize)
+}
+
+// pubsubBatchSize is the number of notifications pulled, and of notifications
+// acknowledged, per request to Pub/Sub.
+const pubsubBatchSize = 1000
+
+// downloadNotifiedObjectsToCache drains the Pub/Sub subscription that receives
+// the notifications of the bucket, e.g.
+// projects/my-project/subscriptions/prowdig, and downloads the objects
+// announced by the OBJECT_FINALIZE notifications that are under one of the
+// given prefixes and match the filter (the filter can be left nil). Unlike
+// listing the bucket, the cost doesn't grow with the number of builds in the
+// bucket. The notifications are only acknowledged once the objects are in the
+// cache so that the objects of an interrupted run are downloaded by the next
+// run.
+//
+// The notifications must carry the JSON_API_V1 payload, which is what
+// 'gsutil notification create -f json' sets up. Like the Pub/Sub client
+// libraries, the emulator given with PUBSUB_EMULATOR_HOST is used when set.
+func downloadNotifiedObjectsToCache(subscription string, prefixes []string, filter *regexp.Regexp) (downloadSummary, error) {
+	ctx := context.Background()
+	var opts []option.ClientOption
+	if host := os.Getenv("PUBSUB_EMULATOR_HOST"); host != "" {
+		opts = append(opts, option.WithEndpoint("http://"+host+"/"), option.WithoutAuthentication())
+	}
+	client, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("Google Cloud Pub/Sub: %v", err)
+	}
+
+	var objects []objectAttrs
+	var ackIDs []string
+	totalSize := int64(0)
+	seen := make(map[string]int) // Object name -> index in objects.
+	for {
+		resp, err := client.Projects.Subscriptions.Pull(subscription, &pubsub.PullRequest{MaxMessages: pubsubBatchSize, ReturnImmediately: true}).Context(ctx).Do()
+		if err != nil {
+			return downloadSummary{}, fmt.Errorf("failed to pull the notifications from %s: %w", subscription, err)
+		}
+		if len(resp.ReceivedMessages) == 0 {
+			break
+		}
+		for _, received := range resp.ReceivedMessages {
+			ackIDs = append(ackIDs, received.AckId)
+
+			object, ok, err := parseFinalizeNotification(received.Message)
+			if err != nil {
+				return downloadSummary{}, fmt.Errorf("%s: %w", subscription, err)
+			}
+			underPrefix := false
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(object.Name, strings.TrimSuffix(prefix, "/")+"/") {
+					underPrefix = true
+					break
+				}
+			}
+			if !ok || !underPrefix || (filter != nil && !filter.MatchString(object.Name)) {
+				continue
+			}
+			if isOutsideWindow(object.Name) || !isSelectedBuild(object.Name) {
+				continue
+			}
+
+			// An object that is overwritten is notified once per version,
+			// only the last version is downloaded.
+			if i, ok := seen[object.Name]; ok {
+				totalSize -= objects[i].Size
+				objects[i] = object
+			} else {
+				seen[object.Name] = len(objects)
+				objects = append(objects, object)
+			}
+			totalSize += object.Size
+		}
+	}
+
+	bucket, err := newObjectStore()
+	if err != nil {
+		return downloadSummary{}, err
+	}
+	summary, err := downloadObjectsToCache(bucket, objects, totalSize)
+	if err != nil {
+		return summary, err
+	}
+
+	// When the acknowledgement fails, the notifications are delivered again
+	// and the objects are found up to date in the cache the next time.
+	for start := 0; start < len(ackIDs); start += pubsubBatchSize {
+		batch := ackIDs[start:]
+		if len(batch) > pubsubBatchSize {
+			batch = batch[:pubsubBatchSize]
+		}
+		_, err := client.Projects.Subscriptions.Acknowledge(subscription, &pubsub.AcknowledgeRequest{AckIds: batch}).Context(ctx).Do()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to acknowledge the notifications pulled from %s: %v\n", subscription, err)
+			break
+		}
+	}
+	return summary, nil
+}
+
+// parseFinalizeNotification returns the object announced by the given
+// notification of the bucket. The boolean is false when the notification
+// isn't an OBJECT_FINALIZE notification of the bucket, e.g. when an object
+// was deleted.
+func parseFinalizeNotification(msg *pubsub.PubsubMessage) (objectAttrs, bool, error) {
+	if msg == nil || msg.Attributes["eventType"] != "OBJECT_FINALIZE" || msg.Attributes["bucketId"] != bucketName {
+		return objectAttrs{}, false, nil
+	}
+	if msg.Data == "" {
+		return objectAttrs{}, false, fmt.Errorf("the notification of %s has no payload, the notifications must be created with the JSON_API_V1 payload format, e.g. with 'gsutil notification create -f json'", msg.Attributes["objectId"])
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Data)
+	if err != nil {
+		return objectAttrs{}, false, fmt.Errorf("the payload of the notification of %s isn't valid base64: %w", msg.Attributes["objectId"], err)
+	}
+
+	// The payload is the object resource of the JSON API, in which the
+	// integers are strings and the CRC32C is the base64 of its big-endian
+	// bytes.
+	var resource struct {
+		Name   string `json:"name"`
+		Size   string `json:"size"`
+		CRC32C string `json:"crc32c"`
+	}
+	err = json.Unmarshal(data, &resource)
+	if err != nil {
+		return objectAttrs{}, false, fmt.Errorf("the payload of the notification of %s isn't a JSON object resource: %w", msg.Attributes["objectId"], err)
+	}
+	size, err := strconv.ParseInt(resource.Size, 10, 64)
+	if err != nil {
+		return objectAttrs{}, false, fmt.Errorf("the notification of %s has an invalid size %q", resource.Name, resource.Size)
+	}
+	crc, err := base64.StdEncoding.DecodeString(resource.CRC32C)
+	if err != nil || len(crc) != 4 {
+		return objectAttrs{}, false, fmt.Errorf("the notification of %s has an invalid crc32c %q", resource.Name, resource.CRC32C)
+	}
+	return objectAttrs{Name: resource.Name, Size: size, CRC32C: binary.BigEndian.Uint32(crc)}, true, nil
+}
+
+// downloadBuildToCache downloads the build-log.txt, junit, and prowjob.json
+// files of the given build directory, e.g.
+// logs/ci-cert-manager-e2e-v1-24/1542977259508338688.
+func downloadBuildToCache(dir string) (downloadSummary, error) {
+	summary, err := downloadPrefixesToCache([]string{dir}, 1, regexp.MustCompile(isToBeDownloaded.String()+"|"+isProwJobFile.String()))
+	if err == nil && summary.Listed == 0 {
+		return summary, fmt.Errorf("no artifacts found under %s", dir)
+	}
+	return summary, err
+}
+
+// downloadPrefixesToCache downloads the objects of the last "limit" builds
+// found anywhere under the given prefixes, e.g. under the directory of a PR,
+// that match the filter. Unlike downloadBuildArtifactsToCache, the prefixes
+// aren't expected to contain PR directories.
+func downloadPrefixesToCache(prefixes []string, limit int, filter *regexp.Regexp) (downloadSummary, error) {
+	bucket, err := newObjectStore()
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("error: %v\n", err)
+	}
+
+	bar := pb.NewOptions(limit,
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(false),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(false),
+		pb.OptionSetDescription("Listing the artifacts of the builds..."),
+		pb.OptionSetTheme(theme),
+	)
+	_ = bar.RenderBlank()
+	var queries []objectQuery
+	for _, prefix := range prefixes {
+		queries = append(queries, objectQuery{Prefix: strings.TrimSuffix(prefix, "/") + "/"})
+	}
+	objects, totalSize, err := listBuildObjects(bucket, queries, limit, filter, bar)
+	if err != nil {
+		return downloadSummary{}, err
+	}
+	_ = bar.Finish()
+	_ = bar.Clear()
+
+	return downloadObjectsToCache(bucket, objects, totalSize)
+}
+
+// listPRBuildObjects is the listing half of downloadBuildArtifactsToCache:
+// it returns the objects of the last "limit" builds found under the given
+// prefixes that match the filter (the filter can be left nil), along with
+// their total size in bytes.
+func listPRBuildObjects(bucket objectStore, prefixes []string, limit int, filter *regexp.Regexp) ([]objectAttrs, int64, error) {
+	bar1 := pb.NewOptions(int(5 /* seconds */ *5 /* = 1/200 ms */),
+		pb.OptionSetPredictTime(false),
+		pb.OptionSetWriter(progressOut),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(false),
+		pb.OptionSetDescription("Listing all PRs..."),
+		pb.OptionSetTheme(theme),
+	)
+	go func() {
+		for !bar1.IsFinished() {
+			_ = bar1.Add(1)
+			_ = bar1.RenderBlank()
+			time.Sleep(200 * time.Millisecond)
+		}
+	}()
+	prPrefixes, err := listPRPrefixes(bucket, prefixes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list PR prefixes: %v", err)
+	}
+	_ = bar1.Finish()
+	_ = bar1.Clear()
+
+	// Now, let's list the files under each PR prefix.
+	bar2 := pb.NewOptions(limit,
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(false),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(false),
+		pb.OptionSetDescription(fmt.Sprintf("Finding the last %d jobs...", limit)),
+		pb.OptionSetTheme(theme),
+	)
+	_ = bar2.RenderBlank()
+	var queries []objectQuery
+	for _, prPrefix := range prPrefixes {
+		queries = append(queries, objectQuery{Prefix: prPrefix})
+	}
+	objects, totalSize, err := listBuildObjects(bucket, queries, limit, filter, bar2)
+	if err != nil {
+		return nil, 0, err
+	}
+	_ = bar2.Finish()
+	_ = bar2.Clear()
+
+	return objects, totalSize, nil
+}
+
+// Because the ci-cert-manager-* jobs have a very different layout in the
+// Google Storage bucket, the download logic is a bit different.
+//
+// More specifically, because of the lexicographical sorting limitation of
+// Google Storage, we have to list the builds for each of the "prefixes".
+// The prefixes are hardcoded. They look a bit like this:
+//
+//	logs/ci-cert-manager-e2e-v1-23
+//	logs/ci-cert-manager-e2e-v1-24
+//	logs/ci-cert-manager-make-test
+//	logs/ci-cert-manager-next-e2e-v1-23
+//	logs/ci-cert-manager-next-e2e-v1-24
+//	logs/ci-cert-manager-next-make-test
+//	logs/ci-cert-manager-next-upgrade
+//	logs/ci-cert-manager-next-venafi
+//	logs/ci-cert-manager-previous-bazel
 //	logs/ci-cert-manager-previous-e2e-feature-gates-disabled-v1-23
 //	logs/ci-cert-manager-previous-e2e-feature-gates-disabled-v1-24
 //	logs/ci-cert-manager-previous-e2e-v1-23
 //	logs/ci-cert-manager-previous-e2e-v1-24
-func downloadCIBuildArtifactsToCache(limit int, filter *regexp.Regexp) error {
+func downloadCIBuildArtifactsToCache(limit int, filter *regexp.Regexp) (downloadSummary, error) {
 	// There are thousands of build artifacts in the Google Storage bucket.
 	// We use the --limit=N flag to only show the latest ones.
 	// Unfortunately, the Google Storage API doesn't help us getting the
@@ -1004,837 +4542,7284 @@ func downloadCIBuildArtifactsToCache(limit int, filter *regexp.Regexp) error {
 	// PRs, but this heuristic is good enough and it is fast.
 	//
 	//
-	// (2) PERIODIC BUILDS
+	// (2) PERIODIC BUILDS
+	//
+	// The periodic builds are all the jobs that start with
+	// "ci-cert-manager-". The heuristic is to only list the builds inside
+	// the "latest" jobs and to ignore the old and unused jobs. That gives
+	// us 94 jobs to go over:
+	//
+	//     $ gsutil ls gs://jetstack-logs/logs | grep ci-cert-manager
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-18/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-19-previous/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-24-previous/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-24/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-21/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-22/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-23/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-24/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-7/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-8/
+	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-9/
+	//     gs://jetstack-logs/logs/ci-cert-manager-next-bazel/
+	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-11/
+	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-12/
+	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-13/
+	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-22/
+	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-23/
+	//     gs://jetstack-logs/logs/ci-cert-manager-previous-e2e-v1-11/
+	//     gs://jetstack-logs/logs/ci-cert-manager-previous-e2e-v1-12/
+	//     ...
+	//
+	// Our heuristic relies on the fact that the build IDs all have the same
+	// number of digits, meaning that the lexicographic order used by GCS is
+	// also the numeric order, i.e., the chronological order. Instead of
+	// listing each prefix from the beginning, we ask Prow for the builds it
+	// still knows about (prowjobs.js), and we use the oldest of these build
+	// IDs as the StartOffset of the GCS query:
+	//
+	//     gs://jetstack-logs/logs/ci-cert-manager-previous-e2e-v1-24/1542916860926758912/
+	//     <-------------------- StartOffset --------------------------------->
+	//
+	// That way, GCS jumps straight to the newest builds, and the thousands
+	// of older builds are never listed. When Prow doesn't know about a given
+	// job anymore, we fall back to the first three digits of the most recent
+	// build ID known to Prow.
+	if deckURL == "" {
+		return downloadSummary{}, fmt.Errorf("no Deck URL configured, please set 'deckURL' in the profile")
+	}
+	url := deckURL + "/prowjobs.js?var=allBuilds"
+	resp, err := http.Get(url)
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("failed to list the latest builds from %s: %w", deckURL, err)
+	}
+	defer resp.Body.Close()
+
+	type ProwJob struct {
+		Kind       string `json:"kind"`
+		APIVersion string `json:"apiVersion"`
+		Metadata   struct {
+			Name              string    `json:"name"`
+			Namespace         string    `json:"namespace"`
+			UID               string    `json:"uid"`
+			ResourceVersion   string    `json:"resourceVersion"`
+			Generation        int       `json:"generation"`
+			CreationTimestamp time.Time `json:"creationTimestamp"`
+			Labels            struct {
+				CreatedByProw                         string `json:"created-by-prow"`
+				PresetCloudflareCredentials           string `json:"preset-cloudflare-credentials"`
+				PresetDefaultE2EVolumes               string `json:"preset-default-e2e-volumes"`
+				PresetDindEnabled                     string `json:"preset-dind-enabled"`
+				PresetEnableAllFeatureGatesDisableSsa string `json:"preset-enable-all-feature-gates-disable-ssa"`
+				PresetGinkgoSkipDefault               string `json:"preset-ginkgo-skip-default"`
+				PresetMakeVolumes                     string `json:"preset-make-volumes"`
+				PresetServiceAccount                  string `json:"preset-service-account"`
+				ProwK8SIoBuildID                      string `json:"prow.k8s.io/build-id"`
+				ProwK8SIoContext                      string `json:"prow.k8s.io/context"`
+				ProwK8SIoID                           string `json:"prow.k8s.io/id"`
+				ProwK8SIoJob                          string `json:"prow.k8s.io/job"`
+				ProwK8SIoRefsBaseRef                  string `json:"prow.k8s.io/refs.base_ref"`
+				ProwK8SIoRefsOrg                      string `json:"prow.k8s.io/refs.org"`
+				ProwK8SIoRefsRepo                     string `json:"prow.k8s.io/refs.repo"`
+				ProwK8SIoType                         string `json:"prow.k8s.io/type"`
+			} `json:"labels"`
+			Annotations struct {
+				Description             string `json:"description"`
+				ProwK8SIoContext        string `json:"prow.k8s.io/context"`
+				ProwK8SIoJob            string `json:"prow.k8s.io/job"`
+				TestgridAlertEmail      string `json:"testgrid-alert-email"`
+				TestgridCreateTestGroup string `json:"testgrid-create-test-group"`
+				TestgridDashboards      string `json:"testgrid-dashboards"`
+			} `json:"annotations"`
+		} `json:"metadata"`
+		Spec struct {
+			Type      string `json:"type"`
+			Agent     string `json:"agent"`
+			Cluster   string `json:"cluster"`
+			Namespace string `json:"namespace"`
+			Job       string `json:"job"`
+			ExtraRefs []struct {
+				Org     string `json:"org"`
+				Repo    string `json:"repo"`
+				BaseRef string `json:"base_ref"`
+			} `json:"extra_refs"`
+			Report  bool `json:"report"`
+			PodSpec struct {
+				Volumes []struct {
+					Name   string `json:"name"`
+					Secret struct {
+						SecretName string `json:"secretName"`
+					} `json:"secret,omitempty"`
+					EmptyDir struct {
+					} `json:"emptyDir,omitempty"`
+					HostPath struct {
+						Path string `json:"path"`
+						Type string `json:"type"`
+					} `json:"hostPath,omitempty"`
+				} `json:"volumes"`
+				Containers []struct {
+					Name  string   `json:"name"`
+					Image string   `json:"image"`
+					Args  []string `json:"args"`
+					Env   []struct {
+						Name      string `json:"name"`
+						Value     string `json:"value,omitempty"`
+						ValueFrom struct {
+							SecretKeyRef struct {
+								Name string `json:"name"`
+								Key  string `json:"key"`
+							} `json:"secretKeyRef"`
+						} `json:"valueFrom,omitempty"`
+					} `json:"env"`
+					Resources struct {
+						Requests struct {
+							CPU    string `json:"cpu"`
+							Memory string `json:"memory"`
+						} `json:"requests"`
+					} `json:"resources"`
+					VolumeMounts []struct {
+						Name      string `json:"name"`
+						ReadOnly  bool   `json:"readOnly,omitempty"`
+						MountPath string `json:"mountPath"`
+					} `json:"volumeMounts"`
+					SecurityContext struct {
+						Capabilities struct {
+							Add []string `json:"add"`
+						} `json:"capabilities"`
+						Privileged bool `json:"privileged"`
+					} `json:"securityContext"`
+				} `json:"containers"`
+				DNSConfig struct {
+					Options []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"options"`
+				} `json:"dnsConfig"`
+			} `json:"pod_spec"`
+			DecorationConfig struct {
+				Timeout       string `json:"timeout"`
+				GracePeriod   string `json:"grace_period"`
+				UtilityImages struct {
+					Clonerefs  string `json:"clonerefs"`
+					Initupload string `json:"initupload"`
+					Entrypoint string `json:"entrypoint"`
+					Sidecar    string `json:"sidecar"`
+				} `json:"utility_images"`
+				GcsConfiguration struct {
+					Bucket       string `json:"bucket"`
+					PathStrategy string `json:"path_strategy"`
+					DefaultOrg   string `json:"default_org"`
+					DefaultRepo  string `json:"default_repo"`
+				} `json:"gcs_configuration"`
+				GcsCredentialsSecret string `json:"gcs_credentials_secret"`
+			} `json:"decoration_config"`
+			ProwjobDefaults struct {
+				TenantID string `json:"tenant_id"`
+			} `json:"prowjob_defaults"`
+		} `json:"spec"`
+		Status struct {
+			StartTime        time.Time `json:"startTime"`
+			PendingTime      time.Time `json:"pendingTime"`
+			State            string    `json:"state"`
+			Description      string    `json:"description"`
+			URL              string    `json:"url"`
+			PodName          string    `json:"pod_name"`
+			BuildID          string    `json:"build_id"`
+			PrevReportStates struct {
+				Gcsk8Sreporter string `json:"gcsk8sreporter"`
+				Gcsreporter    string `json:"gcsreporter"`
+			} `json:"prev_report_states"`
+		} `json:"status"`
+	}
+
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// The response from prow.k8s.io is a JavaScript blob that can be
+	// interpreted. We need to turn it into JSON. The blob looks like this:
+	//
+	//   var allBuilds = {"items":[{"kind":"ProwJob", ...}]};
+	//   <------------->                                    ^
+	//    remove this                                    remove this
 	//
-	// The periodic builds are all the jobs that start with
-	// "ci-cert-manager-". The heuristic is to only list the builds inside
-	// the "latest" jobs and to ignore the old and unused jobs. That gives
-	// us 94 jobs to go over:
+	regex := regexp.MustCompile(`var allBuilds = {"items":(.*)};`)
+	match := regex.FindStringSubmatch(string(bytes))
+	if len(match) != 2 {
+		return downloadSummary{}, fmt.Errorf(`the body was expected to look like '{"items":[{"kind":"ProwJob", ...}]};' but it was '%s'`, string(bytes))
+	}
+
+	var builds []ProwJob
+	err = json.Unmarshal([]byte(match[1]), &builds)
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("while unmarshalling the ProwJob JSON object: %w", err)
+	}
+
+	if len(builds) == 0 {
+		return downloadSummary{}, fmt.Errorf("no ProwJobs found, please check the body returned by %s", url)
+	}
+
+	// The oldest build ID that Prow still knows about, for each job name.
+	oldestBuildID := make(map[string]string)
+	for _, build := range builds {
+		id := build.Status.BuildID
+		if id == "" {
+			continue
+		}
+		cur, ok := oldestBuildID[build.Spec.Job]
+		if !ok || len(id) < len(cur) || (len(id) == len(cur) && id < cur) {
+			oldestBuildID[build.Spec.Job] = id
+		}
+	}
+
+	lastBuildID := builds[len(builds)-1].Status.BuildID
+	if len(lastBuildID) < 3 {
+		return downloadSummary{}, fmt.Errorf("the last ProwJob returned by %s has an unexpected build ID: %q", url, lastBuildID)
+	}
+	buildIDPrefix := lastBuildID[:3]
+
+	bucket, err := newObjectStore()
+	if err != nil {
+		return downloadSummary{}, fmt.Errorf("error: %v\n", err)
+	}
+
+	// Now, let's list the files under each CI prefix.
+	var queries []objectQuery
+	for _, prefix := range ciBucketPrefixes {
+		startAt, ok := oldestBuildID[path.Base(prefix)]
+		if !ok {
+			startAt = buildIDPrefix
+		}
+		queries = append(queries, objectQuery{
+			Prefix:      prefix + "/",
+			StartOffset: prefix + "/" + startAt,
+		})
+	}
+
+	bar2 := pb.NewOptions(limit,
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(false),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(false),
+		pb.OptionSetDescription(fmt.Sprintf("Finding the last %d jobs...", limit)),
+		pb.OptionSetTheme(theme),
+	)
+	_ = bar2.RenderBlank()
+	objects, totalSize, err := listBuildObjects(bucket, queries, limit, filter, bar2)
+	if err != nil {
+		return downloadSummary{}, err
+	}
+	_ = bar2.Finish()
+	_ = bar2.Clear()
+
+	return downloadObjectsToCache(bucket, objects, totalSize)
+}
+
+// listConcurrency is the maximum number of GCS prefixes that are listed at
+// the same time. Listing one prefix is mostly waiting on the network, so
+// going a bit over the number of CPUs is fine.
+const listConcurrency = 16
+
+// forEachConcurrently runs fn for each i in [0, n) with at most
+// listConcurrency calls running at the same time. It waits for all the calls
+// to return and returns the first error encountered, if any.
+func forEachConcurrently(n int, fn func(i int) error) error {
+	sem := make(chan struct{}, listConcurrency)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			errs[i] = fn(i)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listBuildObjects runs each of the given queries and returns the
+// objects that match the filter (the filter can be left nil), along with
+// their total size in bytes. The queries usually only set Prefix and
+// optionally StartOffset.
+//
+// The queries are run in batches of listConcurrency, but the result is the
+// same as if they had been run one after the other: the objects are returned
+// in the order of the queries, and no further batch is started once "limit"
+// builds have been found. One prowjob.json = one build.
+//
+// The progress bar is incremented each time a build is found; it is not
+// finished by listBuildObjects.
+func listBuildObjects(bucket objectStore, queries []objectQuery, limit int, filter *regexp.Regexp, bar *pb.ProgressBar) ([]objectAttrs, int64, error) {
+	var objects []objectAttrs
+	totalSize := int64(0)
+	countJobs := 0
+	for start := 0; start < len(queries) && countJobs < limit; start += listConcurrency {
+		batch := queries[start:]
+		if len(batch) > listConcurrency {
+			batch = batch[:listConcurrency]
+		}
+
+		// Since we don't know in advance how many builds each query
+		// returns, each goroutine lists up to the number of builds still
+		// missing for its own query. The surplus is discarded when merging
+		// below.
+		remaining := limit - countJobs
+		perQuery := make([][]objectAttrs, len(batch))
+		err := forEachConcurrently(len(batch), func(i int) error {
+			query := batch[i]
+			objectIter := bucket.List(context.Background(), query)
+
+			countJobs := 0
+			for countJobs < remaining {
+				object, err := objectIter.Next()
+				if err == iterator.Done {
+					break
+				}
+				if err != nil {
+					return fmt.Errorf("failed to iterate over the objects under %s: %w", query.Prefix, err)
+				}
+
+				if isOutsideWindow(object.Name) || !isSelectedBuild(object.Name) {
+					continue
+				}
+
+				if isProwJobFile.MatchString(object.Name) {
+					countJobs++
+				}
+
+				// Why "*object"? No one else is going to touch the
+				// *objectAttrs pointer, so it makes sense to do a shallow
+				// copy here.
+				perQuery[i] = append(perQuery[i], *object)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, listed := range perQuery {
+			for _, object := range listed {
+				if countJobs >= limit {
+					break
+				}
+
+				if isProwJobFile.MatchString(object.Name) {
+					countJobs++
+					_ = bar.Add(1)
+				}
+
+				if filter != nil && !filter.MatchString(object.Name) {
+					continue
+				}
+
+				totalSize += object.Size
+				objects = append(objects, object)
+			}
+		}
+	}
+
+	return objects, totalSize, nil
+}
+
+// downloadSummary tells what happened when downloading a set of objects to
+// the cache.
+type downloadSummary struct {
+	// Number of objects that were selected for download.
+	Listed int `json:"listed"`
+
+	// Number of objects that were missing from the cache or that had a
+	// different checksum, and were thus downloaded.
+	Downloaded int `json:"downloaded"`
+
+	// Number of bytes that were actually downloaded.
+	DownloadedBytes int64 `json:"downloadedBytes"`
+
+	// Number of objects that were already in the cache with the right
+	// checksum.
+	UpToDate int `json:"upToDate"`
+
+	// Number of builds removed from the cache to stay under
+	// --max-cache-size, and the number of bytes freed.
+	EvictedBuilds int   `json:"evictedBuilds,omitempty"`
+	EvictedBytes  int64 `json:"evictedBytes,omitempty"`
+}
+
+// downloadObjectsToCache downloads the given objects to the cache while
+// showing a progress bar. The totalSize is the sum of the object sizes and is
+// only used for the progress bar.
+func downloadObjectsToCache(bucket objectStore, objects []objectAttrs, totalSize int64) (downloadSummary, error) {
+	bar := pb.NewOptions64(totalSize,
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(true),
+		pb.OptionShowCount(),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(true),
+		pb.OptionSetDescription("Downloading logs for each job..."),
+		pb.OptionSetTheme(theme),
+	)
+	_ = bar.RenderBlank()
+
+	// The file systems don't all store the modification time with a
+	// sub-second precision.
+	start := time.Now().Truncate(time.Second)
+
+	summary := downloadSummary{Listed: len(objects)}
+	for _, object := range objects {
+		if CLI.Debug {
+			fmt.Fprintf(os.Stderr, "downloading %s\n", object.Name)
+		}
+		downloaded, err := downloadToCache(&object, bucket)
+		if err != nil {
+			return summary, fmt.Errorf("failed to download jobs artifacts for %s: %w", object.Name, err)
+		}
+		if downloaded {
+			summary.Downloaded++
+			summary.DownloadedBytes += downloadSize(&object)
+		} else {
+			summary.UpToDate++
+		}
+		_ = bar.Add64(object.Size)
+	}
+	_ = bar.Finish()
+	_ = bar.Clear()
+
+	if maxCacheSize > 0 {
+		builds, bytes, err := evictCache(cacheDir, maxCacheSize, start)
+		if err != nil {
+			return summary, fmt.Errorf("failed to evict the least recently used builds from the cache: %w", err)
+		}
+		summary.EvictedBuilds = builds
+		summary.EvictedBytes = bytes
+	}
+
+	return summary, nil
+}
+
+// cachedBuild is a build directory found in the cache, see scanCacheBuilds.
+type cachedBuild struct {
+	// Relative to the cache directory, e.g.
+	// logs/ci-cert-manager-e2e-v1-24/1542977259508338688.
+	dir string
+
+	// The size of the artifacts that no other build shares.
+	size int64
+
+	// A build is "used" when its artifacts are downloaded or found up to
+	// date, which is when downloadToCache bumps the modification time of the
+	// build directory. The artifacts can't be used for that since they may be
+	// hard links shared with other builds.
+	lastUsed time.Time
+}
+
+// scanCacheBuilds lists the builds of the given cache directory, least
+// recently used first, and returns the total size of the cache. The files
+// that don't belong to a build, such as latest-build.txt, count in the total
+// size.
+//
+// Since the artifacts are hard links to the blob store (see writeToCache),
+// the size of a build only counts the blobs that no other build uses.
+func scanCacheBuilds(dir string) ([]*cachedBuild, int64, error) {
+	type file struct {
+		size   int64
+		builds map[string]struct{}
+	}
+	builds := make(map[string]*cachedBuild)
+	files := make(map[string]*file) // The key is the inode, or the path.
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (path == dir+"/"+blobsDirName || path == dir+"/"+indexDirName) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			rel := strings.TrimPrefix(path, dir+"/")
+			if buildDir, ok := buildDir(rel); ok && buildDir == rel {
+				builds[buildDir] = &cachedBuild{dir: buildDir, lastUsed: info.ModTime()}
+			}
+			return nil
+		}
+
+		key := path
+		if ino, ok := inode(info); ok {
+			key = strconv.FormatUint(ino, 10)
+		}
+		f, ok := files[key]
+		if !ok {
+			f = &file{size: info.Size(), builds: make(map[string]struct{})}
+			files[key] = f
+		}
+
+		buildDir, ok := buildDir(strings.TrimPrefix(path, dir+"/"))
+		if !ok {
+			return nil
+		}
+		f.builds[buildDir] = struct{}{}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+		if len(f.builds) != 1 {
+			continue
+		}
+		for buildDir := range f.builds {
+			builds[buildDir].size += f.size
+		}
+	}
+
+	var lru []*cachedBuild
+	for _, b := range builds {
+		lru = append(lru, b)
+	}
+	sort.Slice(lru, func(i, j int) bool {
+		if !lru[i].lastUsed.Equal(lru[j].lastUsed) {
+			return lru[i].lastUsed.Before(lru[j].lastUsed)
+		}
+		return lru[i].dir < lru[j].dir
+	})
+	return lru, total, nil
+}
+
+// evictCache removes the least recently used builds from the given cache
+// directory until its size is below maxSize, see cachedBuild for what "used"
+// means. The builds used since keepAfter are never removed, meaning that the
+// cache may stay bigger than maxSize when the last download alone is bigger
+// than maxSize. The files that don't belong to a build, such as
+// latest-build.txt, count in the size but are never removed. The blobs that
+// are no longer linked from any build are removed at the end. Returns the
+// number of builds removed and the number of bytes freed.
+func evictCache(dir string, maxSize int64, keepAfter time.Time) (int, int64, error) {
+	lru, total, err := scanCacheBuilds(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var evicted int
+	var freed int64
+	for _, b := range lru {
+		if total <= maxSize || !b.lastUsed.Before(keepAfter) {
+			break
+		}
+		if CLI.Debug {
+			fmt.Fprintf(os.Stderr, "evicting %s (%s)\n", b.dir, ByteCountSI(b.size))
+		}
+		err := removeCachedBuild(dir, b.dir)
+		if err != nil {
+			return evicted, freed, err
+		}
+		total -= b.size
+		freed += b.size
+		evicted++
+	}
+
+	if evicted > 0 {
+		err = pruneBlobs(dir + "/" + blobsDirName)
+		if err != nil {
+			return evicted, freed, err
+		}
+	}
+
+	return evicted, freed, nil
+}
+
+// removeCachedBuild removes the given build directory from the cache along
+// with the index entries of its files.
+func removeCachedBuild(dir, build string) error {
+	err := os.RemoveAll(dir + "/" + build)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir + "/" + indexDirName + "/" + build)
+}
+
+// pruneCache removes the builds of the given cache directory that started
+// before olderThan. Whole builds are removed, never single artifacts. When the
+// build ID doesn't tell when the build started, the last time the build was
+// used is looked at instead. The blobs that are no longer linked from any
+// build are removed at the end. Returns the number of builds removed and the
+// number of bytes freed.
+func pruneCache(dir string, olderThan time.Time) (int, int64, error) {
+	builds, _, err := scanCacheBuilds(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var pruned int
+	var freed int64
+	for _, b := range builds {
+		started := b.lastUsed
+		if _, _, build, err := parseObjectName(b.dir); err == nil && !buildStarted(build).IsZero() {
+			started = buildStarted(build)
+		}
+		if !started.Before(olderThan) {
+			continue
+		}
+		if CLI.Debug {
+			fmt.Fprintf(os.Stderr, "pruning %s (%s)\n", b.dir, ByteCountSI(b.size))
+		}
+		err := removeCachedBuild(dir, b.dir)
+		if err != nil {
+			return pruned, freed, err
+		}
+		freed += b.size
+		pruned++
+	}
+
+	if pruned > 0 {
+		err = pruneBlobs(dir + "/" + blobsDirName)
+		if err != nil {
+			return pruned, freed, err
+		}
+	}
+
+	return pruned, freed, nil
+}
+
+// clearCache removes everything in the given cache directory, but keeps the
+// directory itself and its lock file. Returns the number of artifacts removed and the number of
+// bytes freed on disk.
+func clearCache(dir string) (int, int64, error) {
+	info, err := computeCacheInfo(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		if entry.Name() == lockFileName {
+			continue
+		}
+		err = os.RemoveAll(dir + "/" + entry.Name())
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return info.Artifacts, info.DiskSize, nil
+}
+
+// parseAge parses an age such as "30d", "12h", or "1h30m". The unit "d" stands
+// for days; the other units are the ones of time.ParseDuration.
+func parseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q, expected a number of days such as '30d' or a duration such as '12h'", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, expected a number of days such as '30d' or a duration such as '12h'", s)
+	}
+	return d, nil
+}
+
+// The index of the parsed results lives in the cache directory, e.g.
+// ~/.cache/prowdig/jetstack-logs/.index. Like the blob store, its name starts
+// with a dot so that it can't be mistaken for a bucket prefix.
+const indexDirName = ".index"
+
+// Until the index was split into one entry per file, it was this single file.
+// It is removed when the index is opened.
+const legacyIndexFileName = ".index.json"
+
+// indexVersion must be bumped whenever the parsing changes so that the entries
+// written by an older prowdig are thrown away.
+const indexVersion = 3
+
+// artifactIndex stores the results parsed from each junit and build-log.txt
+// file along with the CRC32 checksum of the file, so that the files are only
+// parsed again when they change. Each file has its own entry in the index
+// directory, named after the object name of the file, e.g.
+// .index/logs/ci-cert-manager-e2e-v1-24/1542977259508338688/build-log.txt.json.
+// That way, only the entries of the files being parsed are read or written,
+// and the entries of a build go away along with the build.
+type artifactIndex struct {
+	// The cache directory in which the index lives.
+	dir string
+
+	// A read-only cache is never written to, the new entries are only kept
+	// in memory.
+	readOnly bool
+	memory   map[string]indexEntry
+
+	// Whether a failure to write an entry was already reported.
+	warned bool
+}
+
+type indexEntry struct {
+	Version int            `json:"version"`
+	CRC32   uint32         `json:"crc32"`
+	Results []GinkgoResult `json:"results"`
+
+	// (optional) The files that fail to parse are indexed too so that they
+	// aren't parsed again either.
+	Err string `json:"err,omitempty"`
+}
+
+// openIndex opens the index of the given cache directory.
+func openIndex(dir string, readOnly bool) *artifactIndex {
+	if !readOnly {
+		_ = os.Remove(dir + "/" + legacyIndexFileName)
+	}
+	return &artifactIndex{dir: dir, readOnly: readOnly, memory: make(map[string]indexEntry)}
+}
+
+func (index *artifactIndex) path(objectName string) string {
+	return index.dir + "/" + indexDirName + "/" + objectName + ".json"
+}
+
+// get returns the entry of the given object name. The entries that are
+// missing, corrupted, or written by another version of prowdig are all
+// reported as missing, which means that the file is parsed again.
+func (index *artifactIndex) get(objectName string) (indexEntry, bool) {
+	if entry, ok := index.memory[objectName]; ok {
+		return entry, true
+	}
+	bytes, err := ioutil.ReadFile(index.path(objectName))
+	if err != nil {
+		return indexEntry{}, false
+	}
+	var entry indexEntry
+	err = json.Unmarshal(bytes, &entry)
+	if err != nil || entry.Version != indexVersion {
+		return indexEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores the entry of the given object name. The entry is written
+// atomically so that a concurrent prowdig never reads half of it.
+func (index *artifactIndex) put(objectName string, entry indexEntry) error {
+	entry.Version = indexVersion
+	if index.readOnly {
+		index.memory[objectName] = entry
+		return nil
+	}
+
+	path := index.path(objectName)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(entry)
+	if err != nil {
+		f.Discard()
+		return err
+	}
+	return f.Commit()
+}
+
+// parseArtifactIndexed returns the results of the given junit or
+// build-log.txt file from the index when the file didn't change since it was
+// indexed, and parses it and indexes the results otherwise. The fields that
+// depend on the profile, such as the dimensions, are computed again since the
+// profile may have changed since the file was indexed.
+func parseArtifactIndexed(index *artifactIndex, artifact string) ([]GinkgoResult, error) {
+	bytes, err := loadFromCache(artifact)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load from file, was expected to be already in cache: %w", err)
+	}
+	objectName := strings.TrimPrefix(artifact, cacheDir+"/")
+	sum := crc32.Checksum(bytes, crc32.MakeTable(crc32.Castagnoli))
+
+	entry, ok := index.get(objectName)
+	if ok && entry.CRC32 == sum {
+		if entry.Err != "" {
+			return nil, errors.New(entry.Err)
+		}
+		results := make([]GinkgoResult, len(entry.Results))
+		copy(results, entry.Results)
+		for i := range results {
+			results[i].Prefix = canonicalPrefix(objectName)
+			results[i].Started = buildStarted(results[i].Build)
+			results[i].Dimensions = extractDimensions(results[i].Name)
+			results[i].Owner = findOwner(results[i].Name)
+		}
+		return results, nil
+	}
+
+	results, err := parseArtifactContent(artifact, bytes)
+	entry = indexEntry{CRC32: sum, Results: results}
+	if err != nil {
+		entry.Err = err.Error()
+	}
+	putErr := index.put(objectName, entry)
+	if putErr != nil && !index.warned {
+		fmt.Fprintf(os.Stderr, "warning: failed to save the index of the parsed results: %v\n", putErr)
+		index.warned = true
+	}
+	return results, err
+}
+
+// The content-addressed blob store lives in the cache directory, e.g.
+// ~/.cache/prowdig/jetstack-logs/.blobs. Its name starts with a dot so that
+// it can't be mistaken for a bucket prefix.
+const blobsDirName = ".blobs"
+
+// writeToCache stores the content in the blob store, e.g.
+// ~/.cache/prowdig/jetstack-logs/.blobs/3a/3a7bd3e2360a..., and hard links
+// filePath to the blob. The identical artifacts, e.g. the build logs of the
+// retried uploads, thus consume disk space once. When the file system doesn't
+// support hard links, the content is written to filePath directly.
+func writeToCache(filePath string, content []byte) error {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	blob := cacheDir + "/" + blobsDirName + "/" + hash[:2] + "/" + hash
+
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	_, err = os.Stat(blob)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(blob), 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create the blob dir: %w", err)
+		}
+		// Written to a temporary file first so that a blob is never
+		// half-written.
+		err = ioutil.WriteFile(blob+".tmp", content, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write the blob %s: %w", hash, err)
+		}
+		err = os.Rename(blob+".tmp", blob)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the blob %s: %w", hash, err)
+	}
+
+	return linkToBlob(filePath, blob)
+}
+
+// linkToBlob hard links filePath to the given blob. When the file system
+// doesn't support hard links, the blob is copied to filePath instead.
+func linkToBlob(filePath, blob string) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	// The file may already exist when its checksum didn't match.
+	_ = os.Remove(filePath)
+	err = os.Link(blob, filePath)
+	if err == nil {
+		return nil
+	}
+
+	src, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// pruneBlobs removes the blobs that no artifact links to anymore.
+func pruneBlobs(dir string) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if n, ok := linkCount(info); ok && n <= 1 {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// The metadata of the cache lives in the cache directory, e.g.
+// ~/.cache/prowdig/jetstack-logs/.cache.json, and tells which layout the
+// cache uses. Like the blob store, its name starts with a dot so that it can't
+// be mistaken for a bucket prefix.
+const cacheMetaFileName = ".cache.json"
+
+// cacheLayout is the layout of the caches written by this version of prowdig.
+// Whenever the way the artifacts are stored changes, it must be bumped and a
+// migration must be added to cacheMigrations so that the existing caches can
+// be upgraded with 'prowdig cache migrate' instead of being downloaded again.
+// The layouts are:
+//
+//  1. Each artifact is a regular file named after its object name.
+//  2. The artifacts are hard links to the content-addressed blob store, see
+//     writeToCache.
+const cacheLayout = 2
+
+type cacheMeta struct {
+	Layout int `json:"layout"`
+}
+
+// cacheMigration upgrades a cache from the layout "from" to the layout
+// from+1. A migration may be interrupted and run again.
+type cacheMigration struct {
+	from        int
+	description string
+	migrate     func(dir string) error
+}
+
+// The migrations are ordered by layout.
+var cacheMigrations = []cacheMigration{
+	{from: 1, description: "store the identical artifacts once in the blob store", migrate: migrateToBlobs},
+}
+
+// readCacheLayout returns the layout of the cache in the given directory. The
+// caches written before the metadata file existed are recognized from their
+// content, in which case the boolean is false: the blob store only exists
+// since the layout 2. A missing or empty cache has the current layout.
+func readCacheLayout(dir string) (int, bool, error) {
+	bytes, err := ioutil.ReadFile(dir + "/" + cacheMetaFileName)
+	switch {
+	case err == nil:
+		var meta cacheMeta
+		err = json.Unmarshal(bytes, &meta)
+		if err != nil || meta.Layout < 1 {
+			return 0, false, fmt.Errorf("the metadata file %s is corrupted: %v", dir+"/"+cacheMetaFileName, err)
+		}
+		return meta.Layout, true, nil
+	case !os.IsNotExist(err):
+		return 0, false, fmt.Errorf("while reading the cache metadata: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return cacheLayout, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("while reading the cache directory: %w", err)
+	}
+	empty := true
+	for _, entry := range entries {
+		switch entry.Name() {
+		case blobsDirName:
+			return 2, false, nil
+		case indexDirName, legacyIndexFileName, lockFileName:
+		default:
+			empty = false
+		}
+	}
+	if empty {
+		return cacheLayout, false, nil
+	}
+	return 1, false, nil
+}
+
+func writeCacheLayout(dir string, layout int) error {
+	f, err := createAtomic(dir + "/" + cacheMetaFileName)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(cacheMeta{Layout: layout})
+	if err != nil {
+		f.Discard()
+		return err
+	}
+	return f.Commit()
+}
+
+// checkCacheLayout fails when the cache was written by a newer version of
+// prowdig, and warns when the cache can be migrated. The layout of a cache
+// that has no metadata file yet is recorded, unless the cache is read-only.
+func checkCacheLayout(dir string, readOnly bool) error {
+	layout, known, err := readCacheLayout(dir)
+	if err != nil {
+		return err
+	}
+	if !known && !readOnly {
+		if _, err := os.Stat(dir); err == nil {
+			err = writeCacheLayout(dir, layout)
+			if err != nil {
+				return fmt.Errorf("while writing the cache metadata: %w", err)
+			}
+		}
+	}
+
+	switch {
+	case layout > cacheLayout:
+		return fmt.Errorf("the cache directory %s uses the layout %d, but this version of prowdig only knows the layouts up to %d; upgrade prowdig or use another --cache-dir", dir, layout, cacheLayout)
+	case layout < cacheLayout:
+		fmt.Fprintf(os.Stderr, "warning: the cache directory %s uses the layout %d, run 'prowdig cache migrate' to upgrade it to the layout %d\n", dir, layout, cacheLayout)
+	}
+	return nil
+}
+
+// migrateCache runs the migrations that bring the cache to the current
+// layout. The layout is recorded after each migration so that an interrupted
+// migration resumes where it stopped. Returns the layout the cache had and the
+// migrations that were run.
+func migrateCache(dir string) (int, []cacheMigration, error) {
+	layout, _, err := readCacheLayout(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	if layout > cacheLayout {
+		return layout, nil, fmt.Errorf("the cache directory %s uses the layout %d, but this version of prowdig only knows the layouts up to %d", dir, layout, cacheLayout)
+	}
+
+	from := layout
+	var done []cacheMigration
+	for _, m := range cacheMigrations {
+		if m.from != layout {
+			continue
+		}
+		err = m.migrate(dir)
+		if err != nil {
+			return from, done, fmt.Errorf("while migrating from the layout %d to the layout %d: %w", m.from, m.from+1, err)
+		}
+		layout = m.from + 1
+		err = writeCacheLayout(dir, layout)
+		if err != nil {
+			return from, done, fmt.Errorf("while writing the cache metadata: %w", err)
+		}
+		done = append(done, m)
+	}
+	if layout != cacheLayout {
+		panic(fmt.Sprintf("developer mistake: no migration from the cache layout %d", layout))
+	}
+	return from, done, writeCacheLayout(dir, layout)
+}
+
+// migrateToBlobs moves the artifacts that are regular files to the blob
+// store, see writeToCache. The blob store of cacheDir is used, which means
+// that dir must be cacheDir.
+func migrateToBlobs(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (path == dir+"/"+blobsDirName || path == dir+"/"+indexDirName) {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || path == dir+"/"+legacyIndexFileName || path == dir+"/"+cacheMetaFileName || path == dir+"/"+lockFileName {
+			return nil
+		}
+		// The artifacts that are already hard links come from the blob
+		// store.
+		if n, ok := linkCount(info); ok && n > 1 {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return writeToCache(path, content)
+	})
+}
+
+// cacheInfo tells how much room the cache takes.
+type cacheInfo struct {
+	Dir string `json:"dir"`
+
+	// See cacheLayout.
+	Layout int `json:"layout"`
+
+	// Number of builds and of artifacts in the cache.
+	Builds    int `json:"builds"`
+	Artifacts int `json:"artifacts"`
+
+	// Sum of the sizes of the artifacts, as if each artifact was stored
+	// separately.
+	Size int64 `json:"size"`
+
+	// Space actually taken on disk. Identical artifacts are only stored once,
+	// see writeToCache.
+	DiskSize int64 `json:"diskSize"`
+}
+
+func computeCacheInfo(dir string) (cacheInfo, error) {
+	layout, _, err := readCacheLayout(dir)
+	if err != nil {
+		return cacheInfo{}, err
+	}
+	info := cacheInfo{Dir: dir, Layout: layout}
+	builds := make(map[string]struct{})
+	inodes := make(map[uint64]struct{})
+	err = filepath.Walk(dir, func(path string, file os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file.IsDir() && (path == dir+"/"+blobsDirName || path == dir+"/"+indexDirName) {
+			return filepath.SkipDir
+		}
+		if file.IsDir() || path == dir+"/"+legacyIndexFileName || path == dir+"/"+cacheMetaFileName || path == dir+"/"+lockFileName {
+			return nil
+		}
+
+		info.Artifacts++
+		info.Size += file.Size()
+		if ino, ok := inode(file); !ok {
+			info.DiskSize += file.Size()
+		} else if _, seen := inodes[ino]; !seen {
+			inodes[ino] = struct{}{}
+			info.DiskSize += file.Size()
+		}
+
+		if buildDir, ok := buildDir(strings.TrimPrefix(path, dir+"/")); ok {
+			builds[buildDir] = struct{}{}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return info, nil
+	}
+	if err != nil {
+		return cacheInfo{}, err
+	}
+	info.Builds = len(builds)
+	return info, nil
+}
+
+// buildDir returns the directory of the build that the given object belongs
+// to, e.g.:
+//
+//	logs/ci-cert-manager-e2e-v1-24/1542977259508338688/artifacts/junit__01.xml
+//	<----------------------------------------------->
+//	                   build dir
+//
+// The boolean is false when the object doesn't belong to a build, e.g. for
+// logs/ci-cert-manager-e2e-v1-24/latest-build.txt. The build dir of a build
+// dir is itself.
+func buildDir(objectName string) (string, bool) {
+	_, job, build, err := parseObjectName(objectName)
+	if err != nil {
+		return "", false
+	}
+	segments := strings.Split(objectName, "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i] == job && segments[i+1] == strconv.Itoa(build) {
+			return strings.Join(segments[:i+2], "/"), true
+		}
+	}
+	return "", false
+}
+
+// parseSize parses a size such as "10GB", "1.5 GB", "500MiB", or "1024". The
+// units without "i" are powers of 1000, like the ones shown by ByteCountSI.
+func parseSize(s string) (int64, error) {
+	m := reSize.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q, expected a number followed by an optional unit, e.g. '10GB' or '500MiB'", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	base := 1000.0
+	if m[3] != "" {
+		base = 1024
+	}
+	exp := 0
+	if m[2] != "" {
+		exp = strings.Index("kMGTPE", strings.Replace(m[2], "K", "k", 1)) + 1
+	}
+	return int64(n * math.Pow(base, float64(exp))), nil
+}
+
+var reSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(?:([kKMGTPE])(i)?)?B?$`)
+
+// mirrorSummary tells what happened when mirroring a set of objects.
+type mirrorSummary struct {
+	// Number of objects that were selected for mirroring.
+	Listed int `json:"listed"`
+
+	// Number of objects that were missing from the destination or that had a
+	// different checksum, and were thus copied.
+	Copied int `json:"copied"`
+
+	// Number of bytes that were copied.
+	CopiedBytes int64 `json:"copiedBytes"`
+
+	// Number of objects that were already in the destination with the right
+	// checksum.
+	UpToDate int `json:"upToDate"`
+}
+
+// mirrorBuildArtifacts copies the objects of the last "limit" builds that
+// match the filter to dest. The object names are kept as-is, meaning that the
+// layout of the source bucket is preserved. The destination can either be a
+// GCS bucket with an optional prefix:
+//
+//	gs://my-archive-bucket/prowdig
+//	     <--------------> <----->
+//	      bucket name     prefix
+//
+// in which case the objects are copied server-side, or a local directory, in
+// which case the objects go through the cache (~/.cache/prowdig) first:
+//
+//	/mnt/archive/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
+//	<---------->
+//	    dest
+func mirrorBuildArtifacts(dest string, limit int, filter *regexp.Regexp) (mirrorSummary, error) {
+	bucket, err := newObjectStore()
+	if err != nil {
+		return mirrorSummary{}, fmt.Errorf("error: %v\n", err)
+	}
+
+	objects, totalSize, err := listPRBuildObjects(bucket, ciBucketPrefixes, limit, filter)
+	if err != nil {
+		return mirrorSummary{}, err
+	}
+
+	// The copyObject function returns false when the object was already present in
+	// the destination.
+	var copyObject func(object *objectAttrs) (bool, error)
+	if strings.HasPrefix(dest, "gs://") {
+		destBucket, destPrefix := splitGCSURL(dest)
+		if destBucket == "" {
+			return mirrorSummary{}, fmt.Errorf("no bucket name found in %s", dest)
+		}
+		// The objects are copied server-side, which only works from GCS to
+		// GCS.
+		src, ok := bucket.(*gcsStore)
+		if !ok {
+			return mirrorSummary{}, fmt.Errorf("mirroring to %s requires the artifacts to be stored in GCS, but they are in %s://%s; mirror to a local directory instead", dest, storageScheme, bucketName)
+		}
+		copyObject = func(object *objectAttrs) (bool, error) {
+			dst := src.client.Bucket(destBucket).Object(path.Join(destPrefix, object.Name))
+			attrs, err := dst.Attrs(context.Background())
+			switch {
+			case err == nil && attrs.CRC32C == object.CRC32C:
+				return false, nil
+			case err != nil && !errors.Is(err, storage.ErrObjectNotExist):
+				return false, fmt.Errorf("failed to read the attributes of gs://%s/%s: %w", destBucket, dst.ObjectName(), err)
+			}
+
+			_, err = dst.CopierFrom(src.bucket.Object(object.Name)).Run(context.Background())
+			if err != nil {
+				return false, fmt.Errorf("failed to copy to gs://%s/%s: %w", destBucket, dst.ObjectName(), err)
+			}
+			return true, nil
+		}
+	} else {
+		copyObject = func(object *objectAttrs) (bool, error) {
+			_, err := downloadToCache(object, bucket)
+			if err != nil {
+				return false, err
+			}
+
+			filePath := filepath.Join(dest, object.Name)
+			existing, err := ioutil.ReadFile(filePath)
+			if err == nil && object.sameContent(existing) {
+				return false, nil
+			}
+
+			bytes, err := loadFromCache(cacheDir + "/" + object.Name)
+			if err != nil {
+				return false, err
+			}
+
+			err = os.MkdirAll(filepath.Dir(filePath), 0755)
+			if err != nil {
+				return false, fmt.Errorf("failed to create directory: %w", err)
+			}
+
+			err = ioutil.WriteFile(filePath, bytes, 0644)
+			if err != nil {
+				return false, fmt.Errorf("failed to write %s: %w", filePath, err)
+			}
+			return true, nil
+		}
+	}
+
+	bar := pb.NewOptions64(totalSize,
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(true),
+		pb.OptionShowCount(),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(true),
+		pb.OptionSetDescription("Copying logs for each job..."),
+		pb.OptionSetTheme(theme),
+	)
+	_ = bar.RenderBlank()
+
+	summary := mirrorSummary{Listed: len(objects)}
+	for _, object := range objects {
+		if CLI.Debug {
+			fmt.Fprintf(os.Stderr, "copying %s\n", object.Name)
+		}
+		copied, err := copyObject(&object)
+		if err != nil {
+			return summary, fmt.Errorf("failed to mirror %s: %w", object.Name, err)
+		}
+		if copied {
+			summary.Copied++
+			summary.CopiedBytes += object.Size
+		} else {
+			summary.UpToDate++
+		}
+		_ = bar.Add64(object.Size)
+	}
+	_ = bar.Finish()
+	_ = bar.Clear()
+
+	return summary, nil
+}
+
+// splitGCSURL splits a URL of the form gs://bucket/some/prefix into the
+// bucket name and the prefix. The prefix may be empty.
+func splitGCSURL(url string) (bucket, prefix string) {
+	parts := strings.SplitN(strings.TrimPrefix(url, "gs://"), "/", 2)
+	if len(parts) == 2 {
+		prefix = strings.Trim(parts[1], "/")
+	}
+	return parts[0], prefix
+}
+
+// parseBucket splits the location of a bucket, e.g. "s3://prow-logs", into
+// its scheme ("gs", "s3", "http", or "https") and its name. A location without
+// a scheme is a GCS bucket. The location of a bucket served by an HTTP
+// artifact server is its URL, which is kept in artifactsURL.
+func parseBucket(location string) (scheme, name string, err error) {
+	scheme = "gs"
+	if i := strings.Index(location, "://"); i != -1 {
+		scheme, location = location[:i], location[i+3:]
+	}
+	switch scheme {
+	case "gs", "s3":
+	case "http", "https":
+		// The bucket is the last segment of the path, e.g.
+		// https://gcsweb.k8s.io/gcs/kubernetes-jenkins.
+		location = strings.TrimSuffix(location, "/")
+		i := strings.Index(location, "/")
+		if i == -1 {
+			return "", "", fmt.Errorf("expected the URL of a bucket such as %s://gcsweb.example.com/gcs/my-bucket, got %s://%s", scheme, scheme, location)
+		}
+		return scheme, path.Base(location[i:]), nil
+	default:
+		return "", "", fmt.Errorf("unsupported storage %s://, expected gs://, s3://, or https://", scheme)
+	}
+	name = strings.TrimSuffix(location, "/")
+	if name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("expected a bucket name such as %s://my-bucket, got %s://%s", scheme, scheme, location)
+	}
+	return scheme, name, nil
+}
+
+// objectQuery selects the objects listed by objectStore.List. Like with
+// storage.Query, the objects whose name comes before StartOffset are skipped,
+// and a Delimiter of "/" makes List return the "directories" found right
+// under the Prefix instead of the objects they contain.
+type objectQuery struct {
+	Prefix      string
+	Delimiter   string
+	StartOffset string
+}
+
+// objectAttrs describes an object of the bucket, or a "directory" when only
+// Prefix is set.
+type objectAttrs struct {
+	Name   string
+	Prefix string
+	Size   int64
+
+	// GCS gives the CRC32C of the objects, S3 gives their ETag instead.
+	CRC32C uint32
+	ETag   string
+
+	// The HTTP artifact servers give neither the checksum nor the size of
+	// the objects, see httpStore.
+	Unchecked bool
+}
+
+// sameContent tells whether the given bytes are the content of the object.
+// The ETag of an S3 object is the MD5 of its content, except when the object
+// was uploaded in several parts, in which case only the sizes are compared.
+// Nothing is known about the objects listed by an HTTP artifact server; since
+// the artifacts of a finished build don't change once uploaded, and the
+// running builds aren't listed, any content is assumed to be theirs.
+func (o *objectAttrs) sameContent(bytes []byte) bool {
+	if o.Unchecked {
+		return true
+	}
+	h := o.newHash()
+	_, _ = h.Write(bytes)
+	return o.sameSum(h.Sum(nil), int64(len(bytes)))
+}
+
+// newHash returns the hash that gives the checksum of the object: CRC32C with
+// GCS, MD5 with S3.
+func (o *objectAttrs) newHash() hash.Hash {
+	if o.ETag == "" {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return md5.New()
+}
+
+// sameSum tells whether the given checksum, computed with newHash, and size
+// are the ones of the object. See sameContent.
+func (o *objectAttrs) sameSum(sum []byte, size int64) bool {
+	switch {
+	case o.Unchecked:
+		return true
+	case o.ETag == "":
+		return len(sum) == 4 && binary.BigEndian.Uint32(sum) == o.CRC32C
+	case strings.Contains(o.ETag, "-"):
+		return size == o.Size
+	default:
+		return hex.EncodeToString(sum) == o.ETag
+	}
+}
+
+// objectIterator returns iterator.Done once all the objects have been listed.
+type objectIterator interface {
+	Next() (*objectAttrs, error)
+}
+
+// objectStore gives access to the bucket in which Prow uploads the artifacts.
+// The objects are listed in lexicographic order.
+type objectStore interface {
+	List(ctx context.Context, query objectQuery) objectIterator
+	ReadObject(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// ReadTail reads the last n bytes of the object.
+	ReadTail(ctx context.Context, name string, n int64) (io.ReadCloser, error)
+}
+
+// newObjectStore returns the store of the current bucket, which lives in GCS,
+// in S3, or behind an HTTP artifact server depending on storageScheme.
+func newObjectStore() (objectStore, error) {
+	switch storageScheme {
+	case "s3":
+		return newS3Store(bucketName, s3Endpoint)
+	case "http", "https":
+		if strings.Contains(artifactsURL, "/job-history/") {
+			return newDeckStore(artifactsURL)
+		}
+		return newHTTPStore(artifactsURL)
+	}
+
+	gcs, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("Google Cloud storage: %v", err)
+	}
+	return &gcsStore{client: gcs, bucket: gcs.Bucket(bucketName)}, nil
+}
+
+type gcsStore struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+func (s *gcsStore) List(ctx context.Context, query objectQuery) objectIterator {
+	return gcsIterator{s.bucket.Objects(ctx, &storage.Query{
+		Prefix:      query.Prefix,
+		Delimiter:   query.Delimiter,
+		StartOffset: query.StartOffset,
+		Projection:  storage.ProjectionNoACL,
+	})}
+}
+
+func (s *gcsStore) ReadObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.bucket.Object(name).NewReader(ctx)
+}
+
+func (s *gcsStore) ReadTail(ctx context.Context, name string, n int64) (io.ReadCloser, error) {
+	return s.bucket.Object(name).NewRangeReader(ctx, -n, -1)
+}
+
+type gcsIterator struct {
+	it *storage.ObjectIterator
+}
+
+func (it gcsIterator) Next() (*objectAttrs, error) {
+	attrs, err := it.it.Next()
+	if err != nil {
+		return nil, err
+	}
+	return &objectAttrs{Name: attrs.Name, Prefix: attrs.Prefix, Size: attrs.Size, CRC32C: attrs.CRC32C}, nil
+}
+
+// s3Store talks to the S3 REST API, or to an S3-compatible store such as
+// MinIO, with plain HTTP requests signed with AWS Signature Version 4. The
+// requests are anonymous when no credentials are given.
+type s3Store struct {
+	// With the AWS endpoint, the bucket is part of the host name, e.g.
+	// https://prow-logs.s3.us-east-1.amazonaws.com. With a custom endpoint,
+	// the bucket is the first segment of the path, e.g.
+	// http://minio.example.com:9000/prow-logs.
+	base *url.URL
+
+	region                             string
+	accessKey, secretKey, sessionToken string
+}
+
+// newS3Store reads the credentials and the region from the standard AWS
+// environment variables. When the endpoint is empty, AWS_ENDPOINT_URL_S3 and
+// AWS_ENDPOINT_URL are tried before falling back to the AWS endpoint of the
+// region.
+func newS3Store(bucket, endpoint string) (*s3Store, error) {
+	s := &s3Store{
+		region:       firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	endpoint = firstNonEmpty(endpoint, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	var err error
+	if endpoint == "" {
+		s.base, err = url.Parse("https://" + bucket + ".s3." + s.region + ".amazonaws.com")
+	} else {
+		s.base, err = url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + bucket)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: %w", endpoint, err)
+	}
+	return s, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (s *s3Store) ReadObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) ReadTail(ctx context.Context, name string, n int64) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, name, nil, http.Header{"Range": {"bytes=-" + strconv.FormatInt(n, 10)}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) List(ctx context.Context, query objectQuery) objectIterator {
+	return &s3Iterator{store: s, ctx: ctx, query: query}
+}
+
+// get sends a signed GET request for the given object, or for the bucket
+// itself when the object name is empty. The given headers, e.g. Range, aren't
+// signed. The caller must close the body.
+func (s *s3Store) get(ctx context.Context, name string, query url.Values, header http.Header) (*http.Response, error) {
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + awsEscape(name, false)
+	u.RawQuery = awsQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	s.sign(req, time.Now())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		var s3Err struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		_ = xml.NewDecoder(resp.Body).Decode(&s3Err)
+		if s3Err.Code == "" {
+			return nil, fmt.Errorf("GET %s: %s", u.Redacted(), resp.Status)
+		}
+		return nil, fmt.Errorf("GET %s: %s: %s: %s", u.Redacted(), resp.Status, s3Err.Code, s3Err.Message)
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to the request, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+// The request must not have a body. Nothing is signed when there are no
+// credentials.
+func (s *s3Store) sign(req *http.Request, now time.Time) {
+	if s.accessKey == "" {
+		return
+	}
+
+	const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	headers := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + emptySHA256 + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if s.sessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+		headers += "x-amz-security-token:" + s.sessionToken + "\n"
+	}
+
+	query, _ := url.ParseQuery(req.URL.RawQuery)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		awsQuery(query),
+		headers,
+		strings.Join(signed, ";"),
+		emptySHA256,
+	}, "\n")
+
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, strings.Join(signed, ";"), hex.EncodeToString(key)))
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, as
+// required by Signature Version 4. The slashes are kept unless encodeSlash is
+// true.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsQuery encodes the query the way Signature Version 4 expects it: sorted
+// by key, and with awsEscape.
+func awsQuery(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(key, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Iterator lists the objects with ListObjectsV2, one page at a time.
+type s3Iterator struct {
+	store *s3Store
+	ctx   context.Context
+	query objectQuery
+
+	page    []*objectAttrs
+	token   string
+	started bool
+}
+
+func (it *s3Iterator) Next() (*objectAttrs, error) {
+	for len(it.page) == 0 {
+		if it.started && it.token == "" {
+			return nil, iterator.Done
+		}
+		err := it.fetch()
+		if err != nil {
+			return nil, err
+		}
+	}
+	next := it.page[0]
+	it.page = it.page[1:]
+	return next, nil
+}
+
+func (it *s3Iterator) fetch() error {
+	query := url.Values{"list-type": {"2"}, "prefix": {it.query.Prefix}}
+	if it.query.Delimiter != "" {
+		query.Set("delimiter", it.query.Delimiter)
+	}
+	if it.token != "" {
+		query.Set("continuation-token", it.token)
+	} else if it.query.StartOffset != "" {
+		// Unlike the StartOffset of GCS, start-after is exclusive. That's
+		// fine since the offsets used by prowdig are never object names.
+		query.Set("start-after", it.query.StartOffset)
+	}
+
+	resp, err := it.store.get(it.ctx, "", query, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list the S3 objects under %s: %w", it.query.Prefix, err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+		Contents              []struct {
+			Key  string `xml:"Key"`
+			Size int64  `xml:"Size"`
+			ETag string `xml:"ETag"`
+		} `xml:"Contents"`
+		CommonPrefixes []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("failed to parse the S3 listing under %s: %w", it.query.Prefix, err)
+	}
+
+	it.started = true
+	it.token = ""
+	if result.IsTruncated {
+		it.token = result.NextContinuationToken
+	}
+	for _, object := range result.Contents {
+		it.page = append(it.page, &objectAttrs{Name: object.Key, Size: object.Size, ETag: strings.Trim(object.ETag, `"`)})
+	}
+	for _, prefix := range result.CommonPrefixes {
+		it.page = append(it.page, &objectAttrs{Prefix: prefix.Prefix})
+	}
+
+	// S3 returns the objects and the "directories" separately, while GCS
+	// returns them in lexicographic order.
+	sort.SliceStable(it.page, func(i, j int) bool {
+		return it.page[i].Name+it.page[i].Prefix < it.page[j].Name+it.page[j].Prefix
+	})
+	return nil
+}
+
+// httpStore reads the artifacts from an HTTP artifact server, such as the
+// gcsweb instance that Prow deployments run next to Deck, for when the bucket
+// itself isn't accessible. The objects are fetched with plain GET requests,
+// and the "directories" are listed by following the links of their index
+// pages, e.g.:
+//
+//	GET https://gcsweb.k8s.io/gcs/kubernetes-jenkins/logs/ci-kubernetes-e2e-gci-gce/
+//	<a href="/gcs/kubernetes-jenkins/logs/ci-kubernetes-e2e-gci-gce/1542977259508338688/">1542977259508338688/</a>
+//
+// The index pages tell neither the size nor the checksum of the objects,
+// which is why the cached files are never downloaded again, and why the
+// builds that are still running are skipped.
+//
+// When only Deck is accessible, deckStore lists the builds from its job
+// history instead, but only gets their build-log.txt files.
+type httpStore struct {
+	// The URL of the bucket, e.g. https://gcsweb.k8s.io/gcs/kubernetes-jenkins.
+	base *url.URL
+}
+
+func newHTTPStore(bucketURL string) (*httpStore, error) {
+	base, err := url.Parse(strings.TrimSuffix(bucketURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact server URL %q: %w", bucketURL, err)
+	}
+	return &httpStore{base: base}, nil
+}
+
+func (s *httpStore) List(ctx context.Context, query objectQuery) objectIterator {
+	return &httpIterator{store: s, ctx: ctx, query: query}
+}
+
+func (s *httpStore) ReadObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *httpStore) ReadTail(ctx context.Context, name string, n int64) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, name, http.Header{"Range": {"bytes=-" + strconv.FormatInt(n, 10)}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// url returns the URL of the given object, or of the given directory when
+// the name ends with a slash.
+func (s *httpStore) url(name string) *url.URL {
+	u := *s.base
+	u.Path = u.Path + "/" + name
+	u.RawPath = ""
+	return &u
+}
+
+// get sends a GET request for the given object or directory. The caller must
+// close the body.
+func (s *httpStore) get(ctx context.Context, name string, header http.Header) (*http.Response, error) {
+	u := s.url(name)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u.Redacted(), resp.Status)
+	}
+	return resp, nil
+}
+
+var reHref = regexp.MustCompile(`href="([^"]+)"`)
+
+// listDir returns the objects and the "directories" found right under the
+// given directory, in lexicographic order. The directory is either empty or
+// ends with a slash. The links that point outside of the directory, such as
+// the link to the parent directory, are ignored.
+func (s *httpStore) listDir(ctx context.Context, dir string) ([]*objectAttrs, error) {
+	resp, err := s.get(ctx, dir, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the objects under %s: %w", dir, err)
+	}
+	defer resp.Body.Close()
+	page, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the objects under %s: %w", dir, err)
+	}
+
+	pageURL := s.url(dir)
+	seen := make(map[string]struct{})
+	var entries []*objectAttrs
+	for _, m := range reHref.FindAllStringSubmatch(string(page), -1) {
+		link, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil {
+			continue
+		}
+		link = pageURL.ResolveReference(link)
+		if link.Host != pageURL.Host || !strings.HasPrefix(link.Path, pageURL.Path) {
+			continue
+		}
+		entry := strings.TrimPrefix(link.Path, pageURL.Path)
+		if entry == "" || strings.Contains(strings.TrimSuffix(entry, "/"), "/") {
+			continue
+		}
+		if _, ok := seen[entry]; ok {
+			continue
+		}
+		seen[entry] = struct{}{}
+
+		if strings.HasSuffix(entry, "/") {
+			entries = append(entries, &objectAttrs{Prefix: dir + entry})
+		} else {
+			entries = append(entries, &objectAttrs{Name: dir + entry, Unchecked: true})
+		}
+	}
+
+	// A build that is still running has a started.json but no finished.json
+	// yet. Its build-log.txt would be cached truncated and never downloaded
+	// again (see sameContent), so the build is skipped until it finishes.
+	if _, started := seen["started.json"]; started {
+		if _, finished := seen["finished.json"]; !finished {
+			return nil, nil
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name+entries[i].Prefix < entries[j].Name+entries[j].Prefix
+	})
+	return entries, nil
+}
+
+// httpIterator walks the directories depth-first. Since the entries of each
+// directory are sorted, the objects come in lexicographic order, and the
+// directories that come before the StartOffset are never listed.
+type httpIterator struct {
+	store *httpStore
+	ctx   context.Context
+	query objectQuery
+
+	pending []*objectAttrs
+	started bool
+}
+
+func (it *httpIterator) Next() (*objectAttrs, error) {
+	if !it.started {
+		it.started = true
+		dir := it.query.Prefix[:strings.LastIndex(it.query.Prefix, "/")+1]
+		entries, err := it.store.listDir(it.ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		it.pending = entries
+	}
+
+	for len(it.pending) > 0 {
+		next := it.pending[0]
+		it.pending = it.pending[1:]
+
+		name := next.Name + next.Prefix
+		if !strings.HasPrefix(name, it.query.Prefix) && !strings.HasPrefix(it.query.Prefix, name) {
+			continue
+		}
+		if name < it.query.StartOffset && !strings.HasPrefix(it.query.StartOffset, name) {
+			continue
+		}
+		if next.Prefix == "" || (it.query.Delimiter == "/" && strings.HasPrefix(name, it.query.Prefix)) {
+			if name < it.query.StartOffset {
+				continue
+			}
+			return next, nil
+		}
+
+		entries, err := it.store.listDir(it.ctx, next.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		it.pending = append(entries, it.pending...)
+	}
+	return nil, iterator.Done
+}
+
+// deckStore reads the artifacts through Deck, the Prow UI, for when neither
+// the bucket nor an HTTP artifact server such as gcsweb is accessible. It is
+// given the URL of the job history of the bucket, e.g.
+// https://prow.k8s.io/job-history/gs/kubernetes-jenkins. The builds of a job
+// are listed from its job history page, which embeds them as JSON, newest
+// first, 20 at a time:
+//
+//	GET https://prow.k8s.io/job-history/gs/kubernetes-jenkins/logs/ci-kubernetes-e2e-gci-gce
+//	var allBuilds = [{"SpyglassLink":"/view/gs/...","ID":"1542977259508338688","Started":"...","Duration":5400000000000,"Result":"SUCCESS"}, ...];
+//
+// and the older builds with ?buildId=<the oldest build of the page>. The
+// prefixes must thus be the prefixes of jobs, e.g. logs/ci-kubernetes-e2e-gci-gce.
+//
+// Deck only serves the build-log.txt file of a build, with /log, so the
+// results come from the build-log.txt files and the junit files aren't read.
+// Since the builds are counted with their prowjob.json file, a prowjob.json
+// is made up from the job history: it only has the job name, the state, and
+// the start and completion times. Like with httpStore, the checksums are
+// unknown and the builds that are still running are skipped.
+type deckStore struct {
+	// Where Deck runs, e.g. https://prow.k8s.io.
+	root string
+
+	// The URL of the job history of the bucket, e.g.
+	// https://prow.k8s.io/job-history/gs/kubernetes-jenkins.
+	history string
+
+	// The builds seen while listing, by build dir, from which the
+	// prowjob.json files are made up.
+	mu     sync.Mutex
+	builds map[string]deckBuild
+}
+
+// deckBuild is a build as shown in the job history of Deck.
+type deckBuild struct {
+	ID       string        `json:"ID"`
+	Started  time.Time     `json:"Started"`
+	Duration time.Duration `json:"Duration"`
+	Result   string        `json:"Result"`
+}
+
+func newDeckStore(historyURL string) (*deckStore, error) {
+	historyURL = strings.TrimSuffix(historyURL, "/")
+	i := strings.Index(historyURL, "/job-history/")
+	if i == -1 {
+		return nil, fmt.Errorf("expected the URL of the job history of a bucket such as https://prow.example.com/job-history/gs/my-bucket, got %s", historyURL)
+	}
+	return &deckStore{root: historyURL[:i], history: historyURL, builds: make(map[string]deckBuild)}, nil
+}
+
+var reDeckBuilds = regexp.MustCompile(`(?s)var allBuilds = (\[.*?\]);`)
+
+// listBuilds returns the finished builds of the given job prefix shown on one
+// page of its job history, newest first. With an empty buildID, the page
+// shows the latest builds, and otherwise the builds older than buildID.
+func (s *deckStore) listBuilds(ctx context.Context, jobPrefix, buildID string) ([]deckBuild, error) {
+	u := s.history + "/" + jobPrefix
+	if buildID != "" {
+		u += "?buildId=" + url.QueryEscape(buildID)
+	}
+	body, err := s.get(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the builds of %s, is %s a job prefix?: %w", jobPrefix, jobPrefix, err)
+	}
+	m := reDeckBuilds.FindSubmatch(body)
+	if m == nil {
+		return nil, fmt.Errorf("failed to list the builds of %s: no builds found in %s, is %s a job prefix?", jobPrefix, u, jobPrefix)
+	}
+	var builds []deckBuild
+	err = json.Unmarshal(m[1], &builds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the builds of %s shown by %s: %w", jobPrefix, u, err)
+	}
+
+	var finished []deckBuild
+	for _, build := range builds {
+		if deckState(build.Result) == "" || !isNumber(build.ID) {
+			continue
+		}
+		s.mu.Lock()
+		s.builds[jobPrefix+"/"+build.ID] = build
+		s.mu.Unlock()
+		finished = append(finished, build)
+	}
+	return finished, nil
+}
+
+// deckState turns the result shown by Deck into the state of a ProwJob. The
+// builds that are still running give an empty state.
+func deckState(result string) string {
+	switch result {
+	case "SUCCESS":
+		return "success"
+	case "FAILURE", "ERROR":
+		return "failure"
+	case "ABORTED":
+		return "aborted"
+	}
+	return ""
+}
+
+func (s *deckStore) get(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *deckStore) List(ctx context.Context, query objectQuery) objectIterator {
+	return &deckIterator{store: s, ctx: ctx, query: query}
+}
+
+func (s *deckStore) ReadObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	dir, ok := buildDir(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: only the build-log.txt and prowjob.json files of the builds can be read through Deck", name)
+	}
+	_, job, _, _ := parseObjectName(name)
+	switch path.Base(name) {
+	case "build-log.txt":
+		body, err := s.get(ctx, s.root+"/log?job="+url.QueryEscape(job)+"&id="+url.QueryEscape(path.Base(dir)))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	case "prowjob.json":
+		s.mu.Lock()
+		build, ok := s.builds[dir]
+		s.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("%s: the build wasn't found in the job history", name)
+		}
+		var prowjob struct {
+			Spec struct {
+				Job string `json:"job"`
+			} `json:"spec"`
+			Status struct {
+				StartTime      time.Time `json:"startTime"`
+				CompletionTime time.Time `json:"completionTime"`
+				State          string    `json:"state"`
+				BuildID        string    `json:"build_id"`
+			} `json:"status"`
+		}
+		prowjob.Spec.Job = job
+		prowjob.Status.StartTime = build.Started
+		prowjob.Status.CompletionTime = build.Started.Add(build.Duration)
+		prowjob.Status.State = deckState(build.Result)
+		prowjob.Status.BuildID = build.ID
+		body, err := json.Marshal(prowjob)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil, fmt.Errorf("%s: only the build-log.txt and prowjob.json files of the builds can be read through Deck", name)
+}
+
+// ReadTail reads the whole object since Deck doesn't serve ranges.
+func (s *deckStore) ReadTail(ctx context.Context, name string, n int64) (io.ReadCloser, error) {
+	r, err := s.ReadObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > n {
+		body = body[int64(len(body))-n:]
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+// deckIterator lists the builds of a job page by page, newest first, and
+// stops paging once the builds are older than the StartOffset. The prefix is
+// either the prefix of a job, in which case the builds are listed, or a build
+// dir, in which case the files of that build are listed. With a delimiter,
+// the build dirs are returned instead of their files.
+type deckIterator struct {
+	store *deckStore
+	ctx   context.Context
+	query objectQuery
+
+	pending []*objectAttrs
+	next    string // The build ID from which the next page starts.
+	seen    map[string]struct{}
+	done    bool
+}
+
+func (it *deckIterator) Next() (*objectAttrs, error) {
+	for len(it.pending) == 0 {
+		if it.done {
+			return nil, iterator.Done
+		}
+		err := it.fetch()
+		if err != nil {
+			return nil, err
+		}
+	}
+	next := it.pending[0]
+	it.pending = it.pending[1:]
+	return next, nil
+}
+
+// fetch fills pending with the next page of builds.
+func (it *deckIterator) fetch() error {
+	prefix := strings.TrimSuffix(it.query.Prefix, "/")
+	jobPrefix, onlyBuild := prefix, ""
+	if dir, ok := buildDir(prefix); ok && dir == prefix {
+		jobPrefix, onlyBuild = path.Dir(prefix), path.Base(prefix)
+	}
+	if it.seen == nil {
+		it.seen = make(map[string]struct{})
+		// The page of a given build starts right after it.
+		if onlyBuild != "" {
+			id, err := strconv.ParseInt(onlyBuild, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: unexpected build ID", prefix)
+			}
+			it.next = strconv.FormatInt(id+1, 10)
+		}
+	}
+
+	builds, err := it.store.listBuilds(it.ctx, jobPrefix, it.next)
+	if err != nil {
+		return err
+	}
+	added := false
+	for _, build := range builds {
+		if _, ok := it.seen[build.ID]; ok {
+			continue
+		}
+		it.seen[build.ID] = struct{}{}
+		added = true
+		it.next = build.ID
+
+		dir := jobPrefix + "/" + build.ID
+		switch {
+		case onlyBuild != "" && build.ID != onlyBuild:
+		case dir+"/" < it.query.StartOffset && !strings.HasPrefix(it.query.StartOffset, dir+"/"):
+			// The builds are listed newest first, the next ones are even
+			// older.
+			it.done = true
+		case it.query.Delimiter == "/" && onlyBuild == "":
+			it.pending = append(it.pending, &objectAttrs{Prefix: dir + "/"})
+		default:
+			for _, name := range []string{dir + "/build-log.txt", dir + "/prowjob.json"} {
+				if strings.HasPrefix(name, it.query.Prefix) && name >= it.query.StartOffset {
+					it.pending = append(it.pending, &objectAttrs{Name: name, Unchecked: true})
+				}
+			}
+		}
+	}
+	if !added || onlyBuild != "" {
+		it.done = true
+	}
+	return nil
+}
+
+var (
+	reURL  = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	reIPv4 = regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`)
+	reIPv6 = regexp.MustCompile(`\b([0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b|\b([0-9a-fA-F]{1,4}:){1,6}:([0-9a-fA-F]{1,4})?\b`)
+
+	// The e2e tests create namespaces such as
+	// "e2e-tests-certificatesigningrequests-q7sg9".
+	reE2ENamespace = regexp.MustCompile(`\be2e-tests-[a-z0-9-]+`)
+
+	// Matches "namespace foo", "namespace: foo", `namespace "foo"`, and
+	// "namespaces/foo". The first group is kept.
+	reNamespace = regexp.MustCompile(`(namespaces?[ :=/"]+)[a-z0-9]([-a-z0-9]*[a-z0-9])?`)
+
+	// Matches in-cluster DNS names such as "cert-manager-webhook.cert-manager.svc".
+	reServiceDNS = regexp.MustCompile(`\b[a-z0-9]([-a-z0-9]*[a-z0-9])?\.[a-z0-9]([-a-z0-9]*[a-z0-9])?\.svc(\.cluster\.local)?\b`)
+)
+
+// anonymize scrubs the details that are specific to a given cluster (URLs,
+// IP addresses, in-cluster DNS names, and namespace names) so that the
+// string can be shared publicly. For example:
+//
+//	Post "https://cert-manager-webhook.cert-manager.svc:443/mutate?timeout=10s": dial tcp 10.96.139.176:443: connect: connection refused
+//
+// becomes:
+//
+//	Post "<url>": dial tcp <ip>: connect: connection refused
+func anonymize(s string) string {
+	s = reURL.ReplaceAllString(s, "<url>")
+	s = reServiceDNS.ReplaceAllString(s, "<service>")
+	s = reIPv4.ReplaceAllString(s, "<ip>")
+	s = reIPv6.ReplaceAllString(s, "<ip>")
+	s = reE2ENamespace.ReplaceAllString(s, "<namespace>")
+	s = reNamespace.ReplaceAllStringFunc(s, func(match string) string {
+		prefix := reNamespace.FindStringSubmatch(match)[1]
+		return prefix + "<namespace>"
+	})
+	return s
+}
+
+// anonymizeResults returns a copy of the results in which the error messages,
+// sources, and junit outputs went through anonymize. The test names, job
+// names, PR numbers, and build numbers are public and are kept as-is.
+func anonymizeResults(results []GinkgoResult) []GinkgoResult {
+	var anonymized []GinkgoResult
+	for _, res := range results {
+		res.Err = anonymize(res.Err)
+		res.Source = anonymize(res.Source)
+		res.SystemOut = anonymize(res.SystemOut)
+		res.SystemErr = anonymize(res.SystemErr)
+		anonymized = append(anonymized, res)
+	}
+	return anonymized
+}
+
+// The "bucket" string in input is used for displaying and logging. It is not
+// used to fetch anything from GCS.
+// parseError tells why a junit or build-log.txt file failed to parse.
+type parseError struct {
+	// The URL of the artifact, e.g.
+	// https://storage.googleapis.com/jetstack-logs/logs/.../build-log.txt
+	Source string `json:"source"`
+	Err    string `json:"err"`
+}
+
+func parseGinkgoResultsFromCache(bucketPrefixes []string, countBuilds int) ([]GinkgoResult, error) {
+	var ginkgoResults []GinkgoResult
+	var parseErrs []parseError
+	var err error
+	if fromJSON != "" {
+		ginkgoResults, err = readResultsJSON(fromJSON)
+		ginkgoResults = selectResults(ginkgoResults, countBuilds)
+	} else {
+		ginkgoResults, parseErrs, err = parseArtifactsFromCache(bucketPrefixes, countBuilds)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A single malformed artifact shouldn't prevent us from showing anything
+	// at all, so we skip it and tell the user about it. The warnings are
+	// printed once the progress bar is gone.
+	for _, parseErr := range parseErrs {
+		if strict {
+			return nil, fmt.Errorf("%s: %s", parseErr.Source, parseErr.Err)
+		}
+		fmt.Fprintf(os.Stderr, "warning: skipping %s: %s\n", parseErr.Source, parseErr.Err)
+	}
+
+	if !allowDuplicates {
+		var merged, count int
+		ginkgoResults, merged = mergeSources(ginkgoResults)
+		ginkgoResults, count = dedupResults(ginkgoResults)
+		count += merged
+		if count > 0 {
+			fmt.Fprintf(os.Stderr, "warning: ignored %d duplicate test results, use --allow-duplicates to keep them\n", count)
+		}
+	}
+
+	// The mass-failure builds are tagged before filtering the tests out so
+	// that the fraction of failed tests of a build doesn't depend on the
+	// filters.
+	tagMassFailures(ginkgoResults, massFailureThreshold)
+	ginkgoResults = filterNames(ginkgoResults, nameRegex, excludeName)
+	ginkgoResults = filterOwner(ginkgoResults, ownerFilter)
+
+	// Anonymizing here rather than in each command means that no command
+	// can forget about --anonymize.
+	if anonymized {
+		ginkgoResults = anonymizeResults(ginkgoResults)
+	}
+	return ginkgoResults, nil
+}
+
+// readResultsJSON reads the results from a JSON array such as the one printed
+// by 'prowdig tests list -ojson'. The fields that depend on the profile or on
+// the flags, such as the dimensions and the mass-failure tag, are computed
+// again.
+func readResultsJSON(file string) ([]GinkgoResult, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var results []GinkgoResult
+	err = json.Unmarshal(bytes, &results)
+	if err != nil {
+		return nil, fmt.Errorf("%s doesn't look like the output of 'prowdig tests list -ojson': %w", file, err)
+	}
+	for i := range results {
+		switch results[i].Status {
+		case statusPassed, statusFailed, statusError, statusTimedOut, statusPanicked:
+		default:
+			return nil, fmt.Errorf("%s: the test %q has the unknown status %q, expected one of passed, failed, error, timedout, or panicked", file, results[i].Name, results[i].Status)
+		}
+		results[i].Dimensions = extractDimensions(results[i].Name)
+		results[i].Owner = findOwner(results[i].Name)
+		results[i].MassFailure = false
+	}
+	return results, nil
+}
+
+// selectResults applies to the results read with --from-json the same
+// selection as the one findCachedArtifacts and sampleBuilds apply to the
+// cache: --job, --build, --builds-file, --days, --since, --until, --sample,
+// and then the last countBuilds builds.
+func selectResults(results []GinkgoResult, countBuilds int) []GinkgoResult {
+	type key struct {
+		job   string
+		build int
+	}
+	started := make(map[key]time.Time)
+	var selected []GinkgoResult
+	for _, res := range results {
+		if onlyJobs != nil && !onlyJobs.MatchString(res.Job) {
+			continue
+		}
+		if _, ok := onlyBuilds[res.Build]; onlyBuilds != nil && !ok {
+			continue
+		}
+		start := res.Started
+		if start.IsZero() {
+			start = buildStarted(res.Build)
+		}
+		if startedOutsideWindow(start) {
+			continue
+		}
+		if sampleFraction < 1 {
+			_, _, objectName, ok := parseArtifactURL(res.Source)
+			if ok {
+				objectName, ok = buildDir(objectName)
+			}
+			if ok && !isSampledBuild(objectName, sampleFraction, sampleSeed) {
+				continue
+			}
+		}
+		started[key{res.Job, res.Build}] = start
+		selected = append(selected, res)
+	}
+
+	if len(started) <= countBuilds {
+		return selected
+	}
+	var builds []key
+	for k := range started {
+		builds = append(builds, k)
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		if !started[builds[i]].Equal(started[builds[j]]) {
+			return started[builds[i]].After(started[builds[j]])
+		}
+		return builds[i].build > builds[j].build
+	})
+	kept := make(map[key]struct{})
+	for _, k := range builds[:countBuilds] {
+		kept[k] = struct{}{}
+	}
+	var last []GinkgoResult
+	for _, res := range selected {
+		if _, ok := kept[key{res.Job, res.Build}]; ok {
+			last = append(last, res)
+		}
+	}
+	return last
+}
+
+// errExplained is returned by parseArtifactsFromCache once --explain has
+// printed what would be parsed. The commands return from main when they get
+// it so that the deferred functions, e.g. the one that commits --output-file,
+// still run.
+var errExplained = errors.New("explained")
+
+// parseArtifactsFromCache parses the junit and build-log.txt files of the last
+// countBuilds builds. The files that fail to parse are returned separately
+// instead of failing the whole parsing.
+func parseArtifactsFromCache(bucketPrefixes []string, countBuilds int) ([]GinkgoResult, []parseError, error) {
+	// Let's only select the last few PRs.
+	artifacts, err := findCachedArtifacts(bucketPrefixes, countBuilds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find cached artifacts: %v", err)
+	}
+	if sampleFraction < 1 {
+		artifacts = sampleBuilds(artifacts, sampleFraction, sampleSeed)
+	}
+	if explain {
+		err = printExplain(os.Stdout, bucketPrefixes, countBuilds, artifacts)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errExplained
+	}
+
+	bar := pb.NewOptions(len(artifacts),
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(true),
+		pb.OptionShowCount(),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(false),
+		pb.OptionSetDescription("Parsing logs..."),
+		pb.OptionSetTheme(theme),
+	)
+	defer func() {
+		_ = bar.Finish()
+		_ = bar.Clear()
+	}()
+
+	if resultsIndex == nil || resultsIndex.dir != cacheDir {
+		resultsIndex = openIndex(cacheDir, CLI.ReadOnlyCache)
+	}
+
+	var ginkgoResults []GinkgoResult
+	var parseErrs []parseError
+	for _, artifact := range artifacts {
+		bar.Add(1)
+
+		if !isJunit(artifact) && !isBuildLogFile.MatchString(artifact) {
+			continue
+		}
+
+		var results []GinkgoResult
+		if noIndex {
+			results, err = parseArtifact(artifact)
+		} else {
+			results, err = parseArtifactIndexed(resultsIndex, artifact)
+		}
+		if err != nil {
+			parseErrs = append(parseErrs, parseError{
+				Source: "https://storage.googleapis.com/" + bucketName + "/" + strings.TrimPrefix(artifact, cacheDir+"/"),
+				Err:    err.Error(),
+			})
+			continue
+		}
+		ginkgoResults = append(ginkgoResults, results...)
+	}
+
+	return ginkgoResults, parseErrs, nil
+}
+
+// explainedBuild tells which files of a build are parsed.
+type explainedBuild struct {
+	Dir       string
+	Junits    int
+	BuildLogs int
+	Size      int64
+}
+
+// explainArtifacts groups the junit and build-log.txt files among the given
+// artifacts by prefix and by build, in the order in which the artifacts are
+// given. The other artifacts are skipped since they aren't parsed. The
+// prefixes are returned in the given order, including the ones without any
+// build.
+func explainArtifacts(bucketPrefixes []string, artifacts []string) (map[string][]explainedBuild, error) {
+	perPrefix := make(map[string][]explainedBuild)
+	index := make(map[string]int)
+	for _, artifact := range artifacts {
+		if !isJunit(artifact) && !isBuildLogFile.MatchString(artifact) {
+			continue
+		}
+		dir, ok := buildDir(strings.TrimPrefix(artifact, cacheDir+"/"))
+		if !ok {
+			continue
+		}
+		prefix := ""
+		for _, p := range bucketPrefixes {
+			if strings.HasPrefix(dir, p+"/") {
+				prefix = p
+				break
+			}
+		}
+
+		file, err := os.Stat(artifact)
+		if err != nil {
+			return nil, err
+		}
+
+		i, ok := index[dir]
+		if !ok {
+			i = len(perPrefix[prefix])
+			index[dir] = i
+			perPrefix[prefix] = append(perPrefix[prefix], explainedBuild{Dir: dir})
+		}
+		build := &perPrefix[prefix][i]
+		if isJunit(artifact) {
+			build.Junits++
+		} else {
+			build.BuildLogs++
+		}
+		build.Size += file.Size()
+	}
+	return perPrefix, nil
+}
+
+// printExplain prints what --explain shows: the selection criteria, and then
+// for each prefix, the builds and the number and size of the files that would
+// be parsed. The prefixes without any build are only counted.
+func printExplain(out io.Writer, bucketPrefixes []string, countBuilds int, artifacts []string) error {
+	perPrefix, err := explainArtifacts(bucketPrefixes, artifacts)
+	if err != nil {
+		return fmt.Errorf("failed to explain the cached artifacts: %w", err)
+	}
+
+	criteria := fmt.Sprintf("the last %d builds", countBuilds)
+	if !since.IsZero() {
+		criteria += " started after " + since.Format("2006-01-02 15:04")
+	}
+	if !until.IsZero() {
+		criteria += " started before " + until.Format("2006-01-02 15:04")
+	}
+	if onlyBuilds != nil {
+		criteria += fmt.Sprintf(" among the %d builds given with --build and --builds-file", len(onlyBuilds))
+	}
+	if onlyJobs != nil {
+		criteria += fmt.Sprintf(" of the jobs matching --job=%s", onlyJobs)
+	}
+	if sampleFraction < 1 {
+		criteria += fmt.Sprintf(", of which %.0f%% are sampled with the seed %d", 100*sampleFraction, sampleSeed)
+	}
+	fmt.Fprintf(out, "Reading %s from %s\n", criteria, cacheDir)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	var totalBuilds, totalFiles, usedPrefixes int
+	var totalSize int64
+	for _, prefix := range bucketPrefixes {
+		builds := perPrefix[prefix]
+		if len(builds) == 0 {
+			continue
+		}
+		usedPrefixes++
+		var files int
+		var size int64
+		for _, build := range builds {
+			files += build.Junits + build.BuildLogs
+			size += build.Size
+		}
+		fmt.Fprintf(w, "%s\t%d builds\t%d files\t%s\n", prefix, len(builds), files, ByteCountSI(size))
+		for _, build := range builds {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n",
+				gray(strings.TrimPrefix(build.Dir, prefix+"/")),
+				gray(fmt.Sprintf("%d junit", build.Junits)),
+				gray(fmt.Sprintf("%d build-log.txt", build.BuildLogs)),
+				gray(ByteCountSI(build.Size)),
+			)
+		}
+		totalBuilds += len(builds)
+		totalFiles += files
+		totalSize += size
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Total: %d builds in %d of the %d prefixes, %d files, %s\n", totalBuilds, usedPrefixes, len(bucketPrefixes), totalFiles, ByteCountSI(totalSize))
+	return nil
+}
+
+// parseSample parses a sampling fraction such as "20%" or "0.2".
+func parseSample(s string) (float64, error) {
+	fraction, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sample %q, expected a percentage such as '20%%' or a fraction such as '0.2'", s)
+	}
+	if strings.HasSuffix(s, "%") {
+		fraction /= 100
+	}
+	if fraction <= 0 || fraction > 1 {
+		return 0, fmt.Errorf("invalid sample %q, must be greater than 0%% and at most 100%%", s)
+	}
+	return fraction, nil
+}
+
+// sampleBuilds only keeps the artifacts of a random fraction of the builds.
+// Each build is picked by hashing the seed and its build dir, which means
+// that a build is either picked with all of its artifacts or not at all, and
+// that the same seed always picks the same builds. The artifacts that don't
+// belong to a build are kept.
+func sampleBuilds(artifacts []string, fraction float64, seed int64) []string {
+	var sampled []string
+	for _, artifact := range artifacts {
+		dir, ok := buildDir(strings.TrimPrefix(artifact, cacheDir+"/"))
+		if !ok {
+			sampled = append(sampled, artifact)
+			continue
+		}
+		if isSampledBuild(dir, fraction, seed) {
+			sampled = append(sampled, artifact)
+		}
+	}
+	return sampled
+}
+
+// isSampledBuild tells whether the given build dir is part of the sample. The
+// same seed always gives the same sample.
+func isSampledBuild(dir string, fraction float64, seed int64) bool {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(seed, 10) + "/" + dir))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < fraction
+}
+
+// scaleCount estimates the count over all the builds from the count over a
+// sample of the builds.
+func scaleCount(count int, fraction float64) int {
+	return int(math.Round(float64(count) / fraction))
+}
+
+// scaleStatsSummary scales the counts of the summary computed over a sample
+// of the builds. The rates don't need to be scaled.
+func scaleStatsSummary(summary *StatsSummary, fraction float64) {
+	if fraction >= 1 {
+		return
+	}
+	summary.Builds = scaleCount(summary.Builds, fraction)
+	summary.Runs = scaleCount(summary.Runs, fraction)
+	summary.CountPassed = scaleCount(summary.CountPassed, fraction)
+	summary.CountFailed = scaleCount(summary.CountFailed, fraction)
+	summary.CountError = scaleCount(summary.CountError, fraction)
+	summary.CountTimedOut = scaleCount(summary.CountTimedOut, fraction)
+	summary.CountPanicked = scaleCount(summary.CountPanicked, fraction)
+	summary.TopErrorCount = scaleCount(summary.TopErrorCount, fraction)
+}
+
+// scaleStatsMostFailures scales the counts of the stats computed over a
+// sample of the builds. The failure rates and their confidence intervals are
+// left as-is: the intervals are wider since they were computed over fewer
+// runs, which is what we want.
+func scaleStatsMostFailures(stats []StatsMostFailures, fraction float64) {
+	if fraction >= 1 {
+		return
+	}
+	for i := range stats {
+		stats[i].CountPassed = scaleCount(stats[i].CountPassed, fraction)
+		stats[i].CountFailed = scaleCount(stats[i].CountFailed, fraction)
+	}
+}
+
+// parseArtifact parses the given junit or build-log.txt file. The file is
+// expected to be already in cache.
+func parseArtifact(artifact string) ([]GinkgoResult, error) {
+	bytes, err := loadFromCache(artifact)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load from file, was expected to be already in cache: %w", err)
+	}
+	return parseArtifactContent(artifact, bytes)
+}
+
+// parseArtifactContent parses the content of the given junit or
+// build-log.txt file.
+func parseArtifactContent(artifact string, bytes []byte) ([]GinkgoResult, error) {
+	// The url below is meant for the 'source' field as well as for logging
+	// purposes.
+	// https://storage.googleapis.com/jetstack-logs/<object-name>
+	objectName := strings.TrimPrefix(artifact, cacheDir+"/")
+	url := "https://storage.googleapis.com/" + bucketName + "/" + objectName
+	pr, job, build, err := parseObjectName(objectName)
+	if err != nil {
+		return nil, fmt.Errorf("parsing object name %s: %w", objectName, err)
+	}
+	prefix := canonicalPrefix(objectName)
+
+	var ginkgoResults []GinkgoResult
+
+	switch {
+	case isJunit(artifact):
+		parsedBlocks, err := parseJunit(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse junit file: %w", err)
+		}
+
+		for _, parsed := range parsedBlocks {
+			ginkgoResults = append(ginkgoResults, GinkgoResult{
+				Name:     parsed.name,
+				Duration: parsed.duration,
+				Status:   parsed.status,
+				Err:      parsed.errStr,
+				ErrLoc:   parsed.errLoc,
+				Source:   url, // No line indication for junit files.
+
+				SystemOut:  parsed.systemOut,
+				SystemErr:  parsed.systemErr,
+				Properties: parsed.properties,
+				Attempts:   parsed.attempts,
+				PR:         pr,
+				Job:        job,
+				Build:      build,
+				Started:    buildStarted(build),
+				Prefix:     prefix,
+			})
+		}
+
+	case isBuildLogFile.MatchString(artifact):
+		parsedBlocks, err := parseBuildLog(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the build-log.txt file: %w", err)
+		}
+
+		results, err := ginkgoBlocksToGinkgoResults(url, job, pr, build, parsedBlocks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse one of the ginkgo blocks: %w", err)
+		}
+		results = append(results, ginkgoV2FailuresToGinkgoResults(url, job, pr, build, parseGinkgoV2Summary(bytes))...)
+		for i := range results {
+			results[i].Prefix = prefix
+			results[i].Started = buildStarted(build)
+		}
+
+		ginkgoResults = append(ginkgoResults, results...)
+
+	default:
+		return nil, fmt.Errorf("developer mistake: expected name %s but got %s", isToBeDownloaded.String(), url)
+	}
+
+	attempts := make(map[string]int)
+	for i := range ginkgoResults {
+		attempts[ginkgoResults[i].Name]++
+		ginkgoResults[i].Attempt = attempts[ginkgoResults[i].Name]
+		ginkgoResults[i].Dimensions = extractDimensions(ginkgoResults[i].Name)
+		ginkgoResults[i].Owner = findOwner(ginkgoResults[i].Name)
+	}
+
+	return ginkgoResults, nil
+}
+
+// extractDimensions applies the dimension rules to the given test name. The
+// dimensions that aren't found in the name are left out. Returns nil when no
+// dimension is found.
+func extractDimensions(name string) map[string]string {
+	var found map[string]string
+	for dim, re := range dimensions {
+		m := re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		if found == nil {
+			found = make(map[string]string)
+		}
+		found[dim] = m[1]
+	}
+	return found
+}
+
+// findOwner returns the owner of the first owner rule that matches the given
+// test name, or an empty string when no rule matches.
+func findOwner(name string) string {
+	for _, rule := range owners {
+		if rule.tests.MatchString(name) {
+			return rule.owner
+		}
+	}
+	return ""
+}
+
+// groupByDimension replaces the name of each result with the value of the
+// given dimension so that the stats are computed per value, e.g. per issuer
+// type instead of per test. The results without this dimension are dropped.
+func groupByDimension(results []GinkgoResult, dim string) []GinkgoResult {
+	var grouped []GinkgoResult
+	for _, res := range results {
+		value, ok := res.Dimensions[dim]
+		if !ok {
+			continue
+		}
+		res.Name = value
+		grouped = append(grouped, res)
+	}
+	return grouped
+}
+
+// filterNames only keeps the results whose name matches include and doesn't
+// match exclude. A nil include or exclude is ignored.
+func filterNames(results []GinkgoResult, include, exclude *regexp.Regexp) []GinkgoResult {
+	if include == nil && exclude == nil {
+		return results
+	}
+	filtered := results[:0]
+	for _, res := range results {
+		if include != nil && !include.MatchString(res.Name) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(res.Name) {
+			continue
+		}
+		filtered = append(filtered, res)
+	}
+	return filtered
+}
+
+// filterOwner only keeps the results owned by the given owner. An empty owner
+// is ignored.
+func filterOwner(results []GinkgoResult, owner string) []GinkgoResult {
+	if owner == "" {
+		return results
+	}
+	filtered := results[:0]
+	for _, res := range results {
+		if res.Owner == owner {
+			filtered = append(filtered, res)
+		}
+	}
+	return filtered
+}
+
+// mergeKey identifies the failures that mergeSources merges.
+type mergeKey struct {
+	Job    string
+	Build  int
+	Name   string
+	Status status
+}
+
+// mergeSources merges the results found both in a junit file and in a
+// build-log.txt file of the same build, i.e., that have the same job, build,
+// test name, status, and duration. The durations are compared to the second
+// since build-log.txt and the junit files don't round them the same way; the
+// results of the Ginkgo v2 summary, which have no duration, match any
+// duration. The record with the richer error message is kept, and the fields
+// that it doesn't have are taken from the other one, e.g. the system-out of
+// the junit file. Each record is merged at most once so that the re-runs of a
+// test stay apart. Returns the number of results removed.
+func mergeSources(results []GinkgoResult) ([]GinkgoResult, int) {
+	isFromJunit := func(res GinkgoResult) bool {
+		return isJunit(strings.SplitN(res.Source, "#", 2)[0])
+	}
+
+	// The junit results that haven't been merged yet, as indexes in results.
+	junits := make(map[mergeKey][]int)
+	for i, res := range results {
+		if isFromJunit(res) {
+			key := mergeKey{Job: res.Job, Build: res.Build, Name: res.Name, Status: res.Status}
+			junits[key] = append(junits[key], i)
+		}
+	}
+
+	merged := make([]GinkgoResult, len(results))
+	copy(merged, results)
+	removed := make(map[int]bool)
+	for i, res := range results {
+		if isFromJunit(res) {
+			continue
+		}
+		key := mergeKey{Job: res.Job, Build: res.Build, Name: res.Name, Status: res.Status}
+		for n, j := range junits[key] {
+			diff := res.Duration - results[j].Duration
+			if res.Duration != 0 && (diff <= -time.Second || diff >= time.Second) {
+				continue
+			}
+			merged[j] = mergeResults(results[j], res)
+			removed[i] = true
+			junits[key] = append(junits[key][:n], junits[key][n+1:]...)
+			break
+		}
+	}
+
+	var kept []GinkgoResult
+	for i, res := range merged {
+		if !removed[i] {
+			kept = append(kept, res)
+		}
+	}
+	return kept, len(removed)
+}
+
+// mergeResults merges the same failure found in a junit file and in a
+// build-log.txt file. The record with the longest error message wins, the
+// junit one in case of a tie.
+func mergeResults(fromJunit, fromBuildLog GinkgoResult) GinkgoResult {
+	res, other := fromJunit, fromBuildLog
+	if len(fromBuildLog.Err) > len(fromJunit.Err) {
+		res, other = fromBuildLog, fromJunit
+	}
+	if res.ErrLoc == "" {
+		res.ErrLoc = other.ErrLoc
+	}
+	if res.Duration == 0 {
+		res.Duration = other.Duration
+	}
+	if res.SystemOut == "" {
+		res.SystemOut = other.SystemOut
+	}
+	if res.SystemErr == "" {
+		res.SystemErr = other.SystemErr
+	}
+	if res.Properties == nil {
+		res.Properties = other.Properties
+	}
+	if res.Attempts == 0 {
+		res.Attempts = other.Attempts
+	}
+	return res
+}
+
+// resultKey identifies a test case outcome. The job is part of the key
+// because the build numbers of the older builds aren't unique across jobs.
+// The status is part of the key because the attempts are numbered per junit
+// or build-log.txt file: a test that failed and was retried successfully
+// may have its failure in the build-log.txt file and its success in the junit
+// file, both with the attempt 1.
+type resultKey struct {
+	Job     string
+	Build   int
+	Name    string
+	Attempt int
+	Status  status
+}
+
+// dedupResults removes the results that have the same resultKey as a previous
+// result. The first one is kept. Returns the number of results removed.
+func dedupResults(results []GinkgoResult) ([]GinkgoResult, int) {
+	seen := make(map[resultKey]struct{})
+	var deduped []GinkgoResult
+	for _, res := range results {
+		key := resultKey{Job: res.Job, Build: res.Build, Name: res.Name, Attempt: res.Attempt, Status: res.Status}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		deduped = append(deduped, res)
+	}
+	return deduped, len(results) - len(deduped)
+}
+
+// The line printed by Ginkgo (v1 and v2) at the end of each suite, e.g.:
+//
+//	Ran 462 of 624 Specs in 1624.482 seconds
+var reGinkgoRan = regexp.MustCompile(`Ran (\d+) of \d+ Specs? in ([0-9.]+) seconds`)
+
+// suiteDuration is the wall-clock duration of the Ginkgo suites of a build.
+type suiteDuration struct {
+	Job   string
+	Build int
+
+	// When a build runs several suites, their durations are added up.
+	Seconds float64
+
+	// The number of specs that ran.
+	Specs int
+}
+
+// parseSuiteDuration adds up the durations of the "Ran X of Y Specs in Z
+// seconds" lines of a build-log.txt. The boolean is false when no such line
+// is found, e.g. when the job timed out before the end of the suite.
+func parseSuiteDuration(buildLog []byte) (float64, int, bool) {
+	buildLog = rmAnsiColors.ReplaceAll(buildLog, []byte(""))
+	var seconds float64
+	var specs int
+	matches := reGinkgoRan.FindAllSubmatch(buildLog, -1)
+	for _, m := range matches {
+		n, _ := strconv.Atoi(string(m[1]))
+		secs, _ := strconv.ParseFloat(string(m[2]), 64)
+		specs += n
+		seconds += secs
+	}
+	return seconds, specs, len(matches) > 0
+}
+
+// parseSuiteDurationsFromCache reads the suite durations from the
+// build-log.txt files of the last builds.
+func parseSuiteDurationsFromCache(bucketPrefixes []string, countBuilds int) ([]suiteDuration, error) {
+	artifacts, err := findCachedArtifacts(bucketPrefixes, countBuilds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cached artifacts: %v", err)
+	}
+
+	var suites []suiteDuration
+	for _, artifact := range artifacts {
+		if !isBuildLogFile.MatchString(artifact) {
+			continue
+		}
+		bytes, err := loadFromCache(artifact)
+		if err != nil {
+			return nil, err
+		}
+		seconds, specs, ok := parseSuiteDuration(bytes)
+		if !ok {
+			continue
+		}
+		_, job, build, err := parseObjectName(strings.TrimPrefix(artifact, cacheDir+"/"))
+		if err != nil {
+			return nil, err
+		}
+		suites = append(suites, suiteDuration{Job: job, Build: build, Seconds: seconds, Specs: specs})
+	}
+	return suites, nil
+}
+
+// StatsSuiteOverhead compares, for a job, the wall-clock duration of the
+// suites to the sum of the durations of their specs. The durations are
+// averages per build, in seconds.
+type StatsSuiteOverhead struct {
+	Job    string `json:"job"`
+	Builds int    `json:"builds"`
+
+	AvgSuite int `json:"avgSuite"`
+	AvgSpecs int `json:"avgSpecs"`
+
+	// The fraction of the suite duration spent outside of the specs,
+	// (AvgSuite - AvgSpecs) / AvgSuite. Negative when the specs run in
+	// parallel.
+	Overhead float64 `json:"overhead"`
+}
+
+// computeSuiteOverhead groups the suite durations per job. The builds for
+// which no spec duration is known, e.g. because the junit files are missing,
+// are skipped since their overhead would look like 100%. The stats are sorted
+// by overhead in ascending order.
+func computeSuiteOverhead(suites []suiteDuration, results []GinkgoResult) []StatsSuiteOverhead {
+	type key struct {
+		job   string
+		build int
+	}
+	specs := make(map[key]float64)
+	for _, res := range results {
+		specs[key{job: res.Job, build: res.Build}] += res.Duration.Seconds()
+	}
+
+	type sums struct {
+		builds       int
+		suite, specs float64
+	}
+	perJob := make(map[string]*sums)
+	var jobs []string
+	for _, suite := range suites {
+		specSeconds, ok := specs[key{job: suite.Job, build: suite.Build}]
+		if !ok || specSeconds == 0 {
+			continue
+		}
+		s, ok := perJob[suite.Job]
+		if !ok {
+			s = &sums{}
+			perJob[suite.Job] = s
+			jobs = append(jobs, suite.Job)
+		}
+		s.builds++
+		s.suite += suite.Seconds
+		s.specs += float64(specSeconds)
+	}
+
+	var stats []StatsSuiteOverhead
+	for _, job := range jobs {
+		s := perJob[job]
+		stat := StatsSuiteOverhead{
+			Job:      job,
+			Builds:   s.builds,
+			AvgSuite: int(math.Round(s.suite / float64(s.builds))),
+			AvgSpecs: int(math.Round(s.specs / float64(s.builds))),
+		}
+		if s.suite > 0 {
+			stat.Overhead = (s.suite - s.specs) / s.suite
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Overhead != stats[j].Overhead {
+			return stats[i].Overhead < stats[j].Overhead
+		}
+		return stats[i].Job < stats[j].Job
+	})
+	return stats
+}
+
+// jobCoverage tells how many of the builds of a prefix made it into the
+// analysis.
+type jobCoverage struct {
+	Prefix string `json:"prefix"`
+
+	// Number of builds found in the GCS bucket under the prefix, up to
+	// --limit. It is -1 when the bucket wasn't listed, e.g. with
+	// --no-download.
+	Builds int `json:"builds"`
+
+	// Number of builds that have at least one artifact in the cache.
+	Downloaded int `json:"downloaded"`
+
+	// Number of builds from which at least one test result was parsed.
+	Parsed int `json:"parsed"`
+
+	// Number of junit and build-log.txt files that failed to parse.
+	ParseErrors int `json:"parseErrors"`
+}
+
+// countBucketBuilds returns the number of builds found in the GCS bucket under
+// each of the given prefixes. At most "limit" builds are counted per prefix,
+// and the builds that started before --days are ignored.
+func countBucketBuilds(prefixes []string, limit int) (map[string]int, error) {
+	bucket, err := newObjectStore()
+	if err != nil {
+		return nil, err
+	}
+
+	bar := pb.NewOptions(len(prefixes)*limit,
+		pb.OptionSetWriter(progressOut),
+		pb.OptionSetPredictTime(false),
+		pb.OptionEnableColorCodes(true),
+		pb.OptionShowBytes(false),
+		pb.OptionSetDescription("Counting the builds of each prefix..."),
+		pb.OptionSetTheme(theme),
+	)
+	defer func() {
+		_ = bar.Finish()
+		_ = bar.Clear()
+	}()
+
+	counts := make(map[string]int)
+	for _, prefix := range prefixes {
+		// listPRPrefixes appends a slash to the prefixes it is given.
+		prPrefixes, err := listPRPrefixes(bucket, []string{prefix})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the builds under %s: %w", prefix, err)
+		}
+		var queries []objectQuery
+		for _, prPrefix := range prPrefixes {
+			queries = append(queries, objectQuery{Prefix: prPrefix})
+		}
+		prowJobs, _, err := listBuildObjects(bucket, queries, limit, isProwJobFile, bar)
+		if err != nil {
+			return nil, err
+		}
+		counts[prefix] = len(prowJobs)
+	}
+	return counts, nil
+}
+
+// computeJobCoverage returns the coverage of each of the given prefixes using
+// the last "limit" builds of each prefix found in the cache. The listed map
+// gives the number of builds found in the bucket for each prefix, as returned
+// by countBucketBuilds. When listed is nil, the number of builds is unknown.
+func computeJobCoverage(prefixes []string, limit int, listed map[string]int) ([]jobCoverage, error) {
+	type buildKey struct {
+		job   string
+		build int
+	}
+
+	var coverage []jobCoverage
+	for _, prefix := range prefixes {
+		cov := jobCoverage{Prefix: prefix, Builds: -1}
+		if listed != nil {
+			cov.Builds = listed[prefix]
+		}
+
+		artifacts, err := findCachedArtifacts([]string{prefix}, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find cached artifacts: %w", err)
+		}
+		downloaded := make(map[buildKey]struct{})
+		for _, artifact := range artifacts {
+			_, job, build, err := parseObjectName(strings.TrimPrefix(artifact, cacheDir+"/"))
+			if err != nil {
+				continue
+			}
+			downloaded[buildKey{job, build}] = struct{}{}
+		}
+		cov.Downloaded = len(downloaded)
+
+		results, parseErrs, err := parseArtifactsFromCache([]string{prefix}, limit)
+		if err != nil {
+			return nil, err
+		}
+		parsed := make(map[buildKey]struct{})
+		for _, res := range results {
+			parsed[buildKey{res.Job, res.Build}] = struct{}{}
+		}
+		cov.Parsed = len(parsed)
+		cov.ParseErrors = len(parseErrs)
+
+		coverage = append(coverage, cov)
+	}
+	return coverage, nil
+}
+
+// canonicalPrefix returns the bucket prefix under which the given object is
+// stored, with the prefix aliases applied. The known prefixes are the
+// prBucketPrefixes, the ciBucketPrefixes, and the aliases themselves. For
+// example, with the default aliases:
+//
+//	pr-logs/pull/jetstack_cert-manager/4044/pull-cert-manager-e2e-v1-21/1395667201859522561/build-log.txt
+//	<------------ prefix ------------>
+//
+// gives "pr-logs/pull/cert-manager_cert-manager". An empty string is returned
+// when the object isn't stored under any of the known prefixes.
+func canonicalPrefix(objectName string) string {
+	var known []string
+	known = append(known, prBucketPrefixes...)
+	known = append(known, ciBucketPrefixes...)
+	for alias := range prefixAliases {
+		known = append(known, alias)
+	}
+
+	longest := ""
+	for _, prefix := range known {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if strings.HasPrefix(objectName, prefix+"/") && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+
+	if canonical, ok := prefixAliases[longest]; ok {
+		return canonical
+	}
+	return longest
+}
+
+func ginkgoV2FailuresToGinkgoResults(url, job string, pr, build int, failures []ginkgoV2Failure) []GinkgoResult {
+	var results []GinkgoResult
+	for _, failure := range failures {
+		results = append(results, GinkgoResult{
+			Name:   failure.parsed.name,
+			Status: failure.parsed.status,
+			ErrLoc: failure.parsed.errLoc,
+			Source: url + "#line=" + strconv.Itoa(failure.line),
+			PR:     pr,
+			Job:    job,
+			Build:  build,
+		})
+	}
+	return results
+}
+
+func ginkgoBlocksToGinkgoResults(url, job string, pr, build int, blocks []ginkgoBlock) ([]GinkgoResult, error) {
+	var results []GinkgoResult
+	for _, block := range blocks {
+		parsed, err := parseGinkgoBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ginkgo block at line %d in %s: %w", block.line, url, err)
+		}
+
+		results = append(results, GinkgoResult{
+			Name:     parsed.name,
+			Duration: parsed.duration,
+			Status:   parsed.status,
+			Err:      parsed.errStr,
+			ErrLoc:   parsed.errLoc,
+			Source:   url + "#line=" + strconv.Itoa(block.line),
+			PR:       pr,
+			Job:      job,
+			Build:    build,
+		})
+	}
+
+	return results, nil
+}
+
+type BuildStatus string
+
+const (
+	BuildSuccess BuildStatus = "success"
+	BuildFailed  BuildStatus = "failure"
+)
+
+type BuildResult struct {
+	// Can be "success" or "failure". We don't care about "pending" states.
+	Status BuildStatus `json:"status"`
+
+	// The duration in seconds of this build.
+	Duration int `json:"duration"`
+
+	// The number of seconds that the build waited before its pod was
+	// scheduled, i.e., between the startTime and the pendingTime of the
+	// ProwJob. Zero when the prowjob.json has no pendingTime.
+	Pending int `json:"pending"`
+
+	// URL to the Prow UI for this build.
+	URL string `json:"url"`
+
+	// Name of the job, e.g. "pull-cert-manager-e2e-v1-13"
+	JobName string `json:"jobName"`
+
+	// (optional) Show the error message if the build is "failure".
+	Err string `json:"err"`
+
+	// When the build started.
+	Started time.Time `json:"started"`
+
+	// The PR number is 0 for batches, postsubmits, and periodics. The build
+	// number is the build ID given by Prow, e.g. 1542977259508338688.
+	PR    int `json:"pr"`
+	Build int `json:"build"`
+
+	// (optional) The commit of the PR that was tested. Empty for batches,
+	// postsubmits, and periodics.
+	Sha string `json:"sha,omitempty"`
+}
+
+// StatsBuildDurations tells how long the builds of a job waited to be
+// scheduled and how long they ran. The durations are in seconds.
+type StatsBuildDurations struct {
+	Job    string `json:"job"`
+	Builds int    `json:"builds"`
+
+	MedianPending int `json:"medianPending"`
+	MaxPending    int `json:"maxPending"`
+
+	MedianDuration int `json:"medianDuration"`
+	MaxDuration    int `json:"maxDuration"`
+}
+
+// StatsJobFailures is the equivalent of 'tests most-failures' for the jobs.
+type StatsJobFailures struct {
+	Job    string `json:"job"`
+	Passed int    `json:"passed"`
+	Failed int    `json:"failed"`
+
+	// Failed divided by the number of builds, between 0 and 1.
+	FailureRate float64 `json:"failureRate"`
+
+	// The average duration of the builds in seconds.
+	AvgDuration int `json:"avgDuration"`
+
+	// The most common description of the failed builds, e.g. "Job failed.",
+	// and the number of failed builds that have it.
+	TopErr      string `json:"topErr"`
+	TopErrCount int    `json:"topErrCount"`
+}
+
+// computeJobFailures groups the builds per job. The stats are sorted by
+// failure rate in ascending order, then by job name. When several
+// descriptions are the most common, the first in alphabetical order is
+// picked.
+func computeJobFailures(builds []BuildResult) []StatsJobFailures {
+	byJob := make(map[string]*StatsJobFailures)
+	totalDuration := make(map[string]int)
+	errCounts := make(map[string]map[string]int)
+	var jobs []string
+	for _, build := range builds {
+		stat, ok := byJob[build.JobName]
+		if !ok {
+			stat = &StatsJobFailures{Job: build.JobName}
+			byJob[build.JobName] = stat
+			errCounts[build.JobName] = make(map[string]int)
+			jobs = append(jobs, build.JobName)
+		}
+		totalDuration[build.JobName] += build.Duration
+		switch build.Status {
+		case BuildSuccess:
+			stat.Passed++
+		case BuildFailed:
+			stat.Failed++
+			errCounts[build.JobName][build.Err]++
+		}
+	}
+
+	var stats []StatsJobFailures
+	for _, job := range jobs {
+		stat := byJob[job]
+		count := stat.Passed + stat.Failed
+		if count > 0 {
+			stat.FailureRate = float64(stat.Failed) / float64(count)
+			stat.AvgDuration = totalDuration[job] / count
+		}
+		for err, n := range errCounts[job] {
+			if n > stat.TopErrCount || (n == stat.TopErrCount && err < stat.TopErr) {
+				stat.TopErr, stat.TopErrCount = err, n
+			}
+		}
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].FailureRate != stats[j].FailureRate {
+			return stats[i].FailureRate < stats[j].FailureRate
+		}
+		return stats[i].Job < stats[j].Job
+	})
+	return stats
+}
+
+// computeBuildDurations groups the builds per job. The stats are sorted by
+// median wait in ascending order, then by job name.
+func computeBuildDurations(builds []BuildResult) []StatsBuildDurations {
+	pending := make(map[string][]int)
+	durations := make(map[string][]int)
+	var jobs []string
+	for _, build := range builds {
+		if _, ok := durations[build.JobName]; !ok {
+			jobs = append(jobs, build.JobName)
+		}
+		pending[build.JobName] = append(pending[build.JobName], build.Pending)
+		durations[build.JobName] = append(durations[build.JobName], build.Duration)
+	}
+
+	var stats []StatsBuildDurations
+	for _, job := range jobs {
+		// median sorts the values, which makes the last one the max.
+		stat := StatsBuildDurations{Job: job, Builds: len(durations[job])}
+		stat.MedianPending = median(pending[job])
+		stat.MaxPending = pending[job][len(pending[job])-1]
+		stat.MedianDuration = median(durations[job])
+		stat.MaxDuration = durations[job][len(durations[job])-1]
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].MedianPending != stats[j].MedianPending {
+			return stats[i].MedianPending < stats[j].MedianPending
+		}
+		return stats[i].Job < stats[j].Job
+	})
+	return stats
+}
+
+// PRRetests tells how many times the presubmits of a PR were re-run, e.g.
+// with /retest, and which tests failed in the builds that were re-run.
+type PRRetests struct {
+	PR int `json:"pr"`
+
+	// The number of failed builds that were followed by another build of
+	// the same job on the same commit.
+	Retests int `json:"retests"`
+
+	// The time in seconds spent running the builds that were re-run.
+	Wasted int `json:"wasted"`
+
+	// The tests that failed in the builds that were re-run. A build that
+	// failed without any failed test doesn't appear here.
+	Tests []NameCount `json:"tests"`
+}
+
+// MonthRetests sums up the re-runs of the presubmits that started during a
+// given month, e.g. "2022-06".
+type MonthRetests struct {
+	Month   string      `json:"month"`
+	PRs     int         `json:"prs"`
+	Retests int         `json:"retests"`
+	Wasted  int         `json:"wasted"`
+	Tests   []NameCount `json:"tests"`
+}
+
+// StatsRetests is what 'builds retests' shows.
+type StatsRetests struct {
+	PRs    []PRRetests    `json:"prs"`
+	Months []MonthRetests `json:"months"`
+}
+
+// buildKey identifies a build. The job is part of the key because the build
+// numbers of the older builds aren't unique across jobs.
+type buildKey struct {
+	Job   string
+	Build int
+}
+
+// failedTestsPerBuild returns the names of the "failed" and "error" tests of
+// each build.
+func failedTestsPerBuild(results []GinkgoResult) map[buildKey][]string {
+	failed := make(map[buildKey][]string)
+	for _, res := range results {
+		if !res.Status.isFailed() && res.Status != statusError {
+			continue
+		}
+		k := buildKey{Job: res.Job, Build: res.Build}
+		failed[k] = append(failed[k], res.Name)
+	}
+	return failed
+}
+
+// retestGroup is made of the presubmit builds of a job that tested the same
+// commit of a PR.
+type retestGroup struct {
+	pr       int
+	job, sha string
+
+	// The failed builds that were followed by another build in the group,
+	// ordered by build number.
+	retested []BuildResult
+}
+
+// retestGroups groups the presubmit builds per PR, job, and commit. Each
+// failed build that is followed by another build in its group is counted as
+// a re-run: the commit didn't change, so someone must have asked for the job
+// to run again. When the prowjob.json doesn't tell which commit was tested,
+// the builds of a PR and job are all in the same group. The builds that
+// aren't presubmits (PR number 0) are ignored, and so are the groups without
+// any re-run. The groups are sorted by PR, job, and commit.
+func retestGroups(builds []BuildResult) []retestGroup {
+	type group struct {
+		pr       int
+		job, sha string
+	}
+	perGroup := make(map[group][]BuildResult)
+	for _, build := range builds {
+		if build.PR == 0 {
+			continue
+		}
+		g := group{pr: build.PR, job: build.JobName, sha: build.Sha}
+		perGroup[g] = append(perGroup[g], build)
+	}
+
+	var groups []retestGroup
+	for g, groupBuilds := range perGroup {
+		// The build numbers increase with time.
+		sort.Slice(groupBuilds, func(i, j int) bool {
+			return groupBuilds[i].Build < groupBuilds[j].Build
+		})
+		var retested []BuildResult
+		for _, build := range groupBuilds[:len(groupBuilds)-1] {
+			if build.Status == BuildFailed {
+				retested = append(retested, build)
+			}
+		}
+		if len(retested) == 0 {
+			continue
+		}
+		groups = append(groups, retestGroup{pr: g.pr, job: g.job, sha: g.sha, retested: retested})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].pr != groups[j].pr {
+			return groups[i].pr < groups[j].pr
+		}
+		if groups[i].job != groups[j].job {
+			return groups[i].job < groups[j].job
+		}
+		return groups[i].sha < groups[j].sha
+	})
+	return groups
+}
+
+// RetestStorm is a job of a PR that was re-run many times in a short time.
+type RetestStorm struct {
+	PR  int    `json:"pr"`
+	Job string `json:"job"`
+
+	// The number of re-runs within the window.
+	Retests int `json:"retests"`
+
+	// When the first and the last re-run builds of the window started.
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+
+	// The tests that failed in the builds that were re-run within the window.
+	Tests []NameCount `json:"tests"`
+
+	// The URLs of the builds that were re-run within the window.
+	Builds []string `json:"builds"`
+}
+
+// computeRetestStorms finds, for each group given by retestGroups, the window
+// of the given length in which the job was re-run the most. When it was re-run
+// more than maxRetests times in that window, it is a storm. When several
+// windows have the same number of re-runs, the earliest one is kept.
+//
+// The storms are sorted by number of re-runs in ascending order, then by PR
+// and job.
+func computeRetestStorms(builds []BuildResult, results []GinkgoResult, maxRetests int, window time.Duration) []RetestStorm {
+	failedTests := failedTestsPerBuild(results)
+
+	var storms []RetestStorm
+	for _, g := range retestGroups(builds) {
+		retested := g.retested
+		sort.SliceStable(retested, func(i, j int) bool {
+			return retested[i].Started.Before(retested[j].Started)
+		})
+
+		// Sliding window: retested[first:last+1] all started within the
+		// window.
+		bestFirst, bestLast := 0, 0
+		first := 0
+		for last := range retested {
+			for retested[last].Started.Sub(retested[first].Started) > window {
+				first++
+			}
+			if last-first > bestLast-bestFirst {
+				bestFirst, bestLast = first, last
+			}
+		}
+		if bestLast-bestFirst+1 <= maxRetests {
+			continue
+		}
+
+		storm := RetestStorm{
+			PR:      g.pr,
+			Job:     g.job,
+			Retests: bestLast - bestFirst + 1,
+			First:   retested[bestFirst].Started,
+			Last:    retested[bestLast].Started,
+		}
+		perTest := make(map[string]int)
+		for _, build := range retested[bestFirst : bestLast+1] {
+			for _, name := range failedTests[buildKey{Job: build.JobName, Build: build.Build}] {
+				perTest[name]++
+			}
+			storm.Builds = append(storm.Builds, build.URL)
+		}
+		storm.Tests = sortNameCounts(perTest)
+		storms = append(storms, storm)
+	}
+
+	sort.SliceStable(storms, func(i, j int) bool {
+		return storms[i].Retests < storms[j].Retests
+	})
+	return storms
+}
+
+// computeRetests counts the re-runs found by retestGroups.
+//
+// The PRs are sorted by number of re-runs in ascending order, then by PR
+// number, and the PRs that were never re-run are left out. The months are
+// sorted in chronological order.
+func computeRetests(builds []BuildResult, results []GinkgoResult) StatsRetests {
+	failedTests := failedTestsPerBuild(results)
+
+	perPR := make(map[int]*PRRetests)
+	perPRTests := make(map[int]map[string]int)
+	perMonth := make(map[string]*MonthRetests)
+	perMonthPRs := make(map[string]map[int]bool)
+	perMonthTests := make(map[string]map[string]int)
+	for _, g := range retestGroups(builds) {
+		for _, build := range g.retested {
+			if perPR[g.pr] == nil {
+				perPR[g.pr] = &PRRetests{PR: g.pr}
+				perPRTests[g.pr] = make(map[string]int)
+			}
+			perPR[g.pr].Retests++
+			perPR[g.pr].Wasted += build.Duration
+
+			month := build.Started.In(timezone).Format("2006-01")
+			if perMonth[month] == nil {
+				perMonth[month] = &MonthRetests{Month: month}
+				perMonthPRs[month] = make(map[int]bool)
+				perMonthTests[month] = make(map[string]int)
+			}
+			perMonth[month].Retests++
+			perMonth[month].Wasted += build.Duration
+			perMonthPRs[month][g.pr] = true
+
+			for _, name := range failedTests[buildKey{Job: build.JobName, Build: build.Build}] {
+				perPRTests[g.pr][name]++
+				perMonthTests[month][name]++
+			}
+		}
+	}
+
+	var stats StatsRetests
+	for pr, stat := range perPR {
+		stat.Tests = sortNameCounts(perPRTests[pr])
+		stats.PRs = append(stats.PRs, *stat)
+	}
+	sort.Slice(stats.PRs, func(i, j int) bool {
+		if stats.PRs[i].Retests != stats.PRs[j].Retests {
+			return stats.PRs[i].Retests < stats.PRs[j].Retests
+		}
+		return stats.PRs[i].PR < stats.PRs[j].PR
+	})
+	for month, stat := range perMonth {
+		stat.PRs = len(perMonthPRs[month])
+		stat.Tests = sortNameCounts(perMonthTests[month])
+		stats.Months = append(stats.Months, *stat)
+	}
+	sort.Slice(stats.Months, func(i, j int) bool {
+		return stats.Months[i].Month < stats.Months[j].Month
+	})
+	return stats
+}
+
+// githubClient talks to the GitHub REST API about the issues of a repository.
+// The token may be empty, in which case the requests are anonymous.
+type githubClient struct {
+	api, repo, token string
+}
+
+type githubIssue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+}
+
+// do sends a request to the GitHub API and decodes the JSON response into
+// "out" unless it is nil. The message given by GitHub is part of the error
+// when the status code isn't 2xx.
+func (gh githubClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		bytes, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(string(bytes))
+	}
+	req, err := http.NewRequest(method, gh.api+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if gh.token != "" {
+		req.Header.Set("Authorization", "Bearer "+gh.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("GitHub API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var ghErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&ghErr)
+		return fmt.Errorf("GitHub API: %s %s: %s: %s", method, strings.SplitN(path, "?", 2)[0], resp.Status, ghErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("GitHub API: %s %s: failed to decode the response: %w", method, strings.SplitN(path, "?", 2)[0], err)
+	}
+	return nil
+}
+
+// findIssue returns the open issue whose body contains the given marker, or
+// nil if there is none. The search API matches the words of the marker
+// loosely, which is why the body of each result is checked.
+func (gh githubClient) findIssue(marker string) (*githubIssue, error) {
+	var found struct {
+		Items []githubIssue `json:"items"`
+	}
+	q := fmt.Sprintf("repo:%s is:issue is:open in:body %q", gh.repo, marker)
+	err := gh.do("GET", "/search/issues?q="+url.QueryEscape(q), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	for _, issue := range found.Items {
+		if strings.Contains(issue.Body, marker) {
+			issue := issue
+			return &issue, nil
+		}
+	}
+	return nil, nil
+}
+
+// changedFiles returns the paths of the files changed by the given PR. GitHub
+// gives up to 3000 files, 100 per page.
+func (gh githubClient) changedFiles(pr int) ([]string, error) {
+	var files []string
+	for page := 1; ; page++ {
+		var got []struct {
+			Filename string `json:"filename"`
+		}
+		err := gh.do("GET", fmt.Sprintf("/repos/%s/pulls/%d/files?per_page=100&page=%d", gh.repo, pr, page), nil, &got)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range got {
+			files = append(files, file.Filename)
+		}
+		if len(got) < 100 {
+			return files, nil
+		}
+	}
+}
+
+func (gh githubClient) createIssue(title, body string, labels []string) (githubIssue, error) {
+	var created githubIssue
+	err := gh.do("POST", "/repos/"+gh.repo+"/issues", map[string]interface{}{"title": title, "body": body, "labels": labels}, &created)
+	return created, err
+}
+
+func (gh githubClient) updateIssue(number int, body string) (githubIssue, error) {
+	var updated githubIssue
+	err := gh.do("PATCH", "/repos/"+gh.repo+"/issues/"+strconv.Itoa(number), map[string]interface{}{"body": body}, &updated)
+	return updated, err
+}
+
+// FiledIssue tells what "tests file-issues" did for a test.
+type FiledIssue struct {
+	Test  string `json:"test"`
+	Title string `json:"title"`
+
+	// Either "created", "updated", "would create", or "would update".
+	Action string `json:"action"`
+
+	// The number and URL of the issue. Zero and empty when the issue would
+	// be created.
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+// issueSlug returns the identifier of the issue of the given test. It is
+// made of the lowercased words of the test name so that it stays the same
+// across runs, followed by a short hash so that two long test names that
+// only differ at the end don't share the same slug.
+func issueSlug(name string) string {
+	slug := strings.Trim(reNonAlnum.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > 60 {
+		slug = strings.TrimRight(slug[:60], "-")
+	}
+	sum := sha256.Sum256([]byte(name))
+	return slug + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// issueBody writes the body of the issue of a test: the failure counts, the
+// last error, and links to the builds in which the test failed, the most
+// recent first. The slug is written in an HTML comment so that the issue can
+// be found again, see findIssue.
+func issueBody(stat StatsMostFailures) string {
+	failures := append([]GinkgoResult(nil), stat.Errors...)
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].Build > failures[j].Build
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!-- prowdig: %s -->\n", issueSlug(stat.Name))
+	fmt.Fprintf(&b, "The test `%s` failed %d times and passed %d times in the last builds (failure rate: %.0f%%).\n", stat.Name, stat.CountFailed, stat.CountPassed, 100*stat.FailureRate)
+	if len(failures) > 0 && failures[0].Err != "" {
+		fmt.Fprintf(&b, "\nLast error:\n\n```\n%s\n```\n", strings.TrimSpace(failures[0].Err+"\n"+failures[0].ErrLoc))
+	}
+	fmt.Fprintf(&b, "\nFailed in:\n\n")
+	for i, res := range failures {
+		if i == 10 {
+			fmt.Fprintf(&b, "- and %d more\n", len(failures)-i)
+			break
+		}
+		started := ""
+		if !res.Started.IsZero() {
+			started = " on " + res.Started.UTC().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(&b, "- [%s #%d](%s)%s\n", res.Job, res.Build, spyglassURL(res.Source), started)
+	}
+	fmt.Fprintf(&b, "\nThis issue is updated by `prowdig tests file-issues`.\n")
+	return b.String()
+}
+
+// fileIssues creates or updates the issue of each of the given tests. With
+// dryRun, the existing issues are looked up but nothing is written. The
+// issues filed before an error are returned along with the error.
+func fileIssues(gh githubClient, stats []StatsMostFailures, labels []string, dryRun bool) ([]FiledIssue, error) {
+	var filed []FiledIssue
+	for _, stat := range stats {
+		title := "Flaky test: " + stat.Name
+		existing, err := gh.findIssue("prowdig: " + issueSlug(stat.Name))
+		if err != nil {
+			return filed, fmt.Errorf("failed to look up the issue of %q: %w", stat.Name, err)
+		}
+
+		body := issueBody(stat)
+		switch {
+		case existing != nil && dryRun:
+			filed = append(filed, FiledIssue{Test: stat.Name, Title: existing.Title, Action: "would update", Number: existing.Number, URL: existing.HTMLURL})
+		case existing != nil:
+			updated, err := gh.updateIssue(existing.Number, body)
+			if err != nil {
+				return filed, fmt.Errorf("failed to update the issue #%d of %q: %w", existing.Number, stat.Name, err)
+			}
+			filed = append(filed, FiledIssue{Test: stat.Name, Title: updated.Title, Action: "updated", Number: updated.Number, URL: updated.HTMLURL})
+		case dryRun:
+			filed = append(filed, FiledIssue{Test: stat.Name, Title: title, Action: "would create"})
+		default:
+			created, err := gh.createIssue(title, body, labels)
+			if err != nil {
+				return filed, fmt.Errorf("failed to create the issue of %q: %w", stat.Name, err)
+			}
+			filed = append(filed, FiledIssue{Test: stat.Name, Title: created.Title, Action: "created", Number: created.Number, URL: created.HTMLURL})
+		}
+	}
+	return filed, nil
+}
+
+// HealthWeights are the weights given to each component of the health score.
+type HealthWeights struct {
+	Failure, Infra, Trend, Flake float64
+}
+
+// JobHealth is the health score of a job, see computeJobHealth.
+type JobHealth struct {
+	Job    string `json:"job"`
+	Builds int    `json:"builds"`
+
+	// 100 minus 100 times the weighted average of the four components below.
+	// A job with no failure, no flake, and no slowdown has a score of 100.
+	Score float64 `json:"score"`
+
+	// The components of the score, each between 0 and 1.
 	//
-	//     $ gsutil ls gs://jetstack-logs/logs | grep ci-cert-manager
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-18/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-19-previous/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-24-previous/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-feature-gates-disabled-v1-24/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-21/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-22/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-23/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-24/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-7/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-8/
-	//     gs://jetstack-logs/logs/ci-cert-manager-e2e-v1-9/
-	//     gs://jetstack-logs/logs/ci-cert-manager-next-bazel/
-	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-11/
-	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-12/
-	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-13/
-	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-22/
-	//     gs://jetstack-logs/logs/ci-cert-manager-next-e2e-v1-23/
-	//     gs://jetstack-logs/logs/ci-cert-manager-previous-e2e-v1-11/
-	//     gs://jetstack-logs/logs/ci-cert-manager-previous-e2e-v1-12/
-	//     ...
+	// The failure rate is the fraction of builds that failed. The infra
+	// failure rate is the fraction of builds that failed without any failed
+	// test, e.g. because the cluster couldn't be created, or that are
+	// mass-failure builds. The duration trend is the median duration of the
+	// recent builds divided by the median duration of the older builds,
+	// minus 1, capped to [0, 1]: it is 0 when the job didn't get slower and 1
+	// when it got twice as slow. The flake rate is the fraction of builds in
+	// which a test failed and then passed, see computeStatsFlakes.
+	FailureRate      float64 `json:"failureRate"`
+	InfraFailureRate float64 `json:"infraFailureRate"`
+	DurationTrend    float64 `json:"durationTrend"`
+	FlakeRate        float64 `json:"flakeRate"`
+}
+
+// computeJobHealth computes the health score of each job from its builds and
+// from the test results of these builds. The builds are matched with the test
+// results using the job name and the build number. The "recent" most recent
+// builds of each job are compared to the older ones to compute the duration
+// trend. The stats are sorted by score in descending order, meaning that the
+// least healthy jobs come last.
+func computeJobHealth(builds []BuildResult, results []GinkgoResult, recent int, weights HealthWeights) []JobHealth {
+	type key struct {
+		job   string
+		build int
+	}
+	type testRuns struct {
+		passed, failed bool
+	}
+	failedTests := make(map[key]bool)
+	massFailure := make(map[key]bool)
+	runs := make(map[key]map[string]*testRuns)
+	for _, res := range results {
+		k := key{job: res.Job, build: res.Build}
+		if res.MassFailure {
+			massFailure[k] = true
+		}
+		if res.Status.isFailed() || res.Status == statusError {
+			failedTests[k] = true
+		}
+		if runs[k] == nil {
+			runs[k] = make(map[string]*testRuns)
+		}
+		r, ok := runs[k][res.Name]
+		if !ok {
+			r = &testRuns{}
+			runs[k][res.Name] = r
+		}
+		switch {
+		case res.Status == statusPassed && res.Attempts > 1:
+			r.passed, r.failed = true, true
+		case res.Status == statusPassed:
+			r.passed = true
+		case res.Status.isFailed():
+			r.failed = true
+		}
+	}
+
+	byJob := make(map[string][]BuildResult)
+	var jobs []string
+	for _, build := range builds {
+		if _, ok := byJob[build.JobName]; !ok {
+			jobs = append(jobs, build.JobName)
+		}
+		byJob[build.JobName] = append(byJob[build.JobName], build)
+	}
+
+	totalWeight := weights.Failure + weights.Infra + weights.Trend + weights.Flake
+
+	var stats []JobHealth
+	for _, job := range jobs {
+		jobBuilds := byJob[job]
+		failed, infra, flaky := 0, 0, 0
+		for _, build := range jobBuilds {
+			k := key{job: job, build: build.Build}
+			if build.Status == BuildFailed {
+				failed++
+				if !failedTests[k] || massFailure[k] {
+					infra++
+				}
+			}
+			for _, r := range runs[k] {
+				if r.passed && r.failed {
+					flaky++
+					break
+				}
+			}
+		}
+
+		// The build numbers increase with time.
+		sort.SliceStable(jobBuilds, func(i, j int) bool {
+			return jobBuilds[i].Build > jobBuilds[j].Build
+		})
+		trend := 0.0
+		if recent > 0 && len(jobBuilds) > recent {
+			var recentDurations, olderDurations []int
+			for i, build := range jobBuilds {
+				if i < recent {
+					recentDurations = append(recentDurations, build.Duration)
+				} else {
+					olderDurations = append(olderDurations, build.Duration)
+				}
+			}
+			if older := median(olderDurations); older > 0 {
+				trend = math.Min(math.Max(float64(median(recentDurations))/float64(older)-1, 0), 1)
+			}
+		}
+
+		stat := JobHealth{
+			Job:              job,
+			Builds:           len(jobBuilds),
+			FailureRate:      float64(failed) / float64(len(jobBuilds)),
+			InfraFailureRate: float64(infra) / float64(len(jobBuilds)),
+			DurationTrend:    trend,
+			FlakeRate:        float64(flaky) / float64(len(jobBuilds)),
+		}
+		stat.Score = 100
+		if totalWeight > 0 {
+			stat.Score = 100 - 100*(weights.Failure*stat.FailureRate+weights.Infra*stat.InfraFailureRate+weights.Trend*stat.DurationTrend+weights.Flake*stat.FlakeRate)/totalWeight
+		}
+		stats = append(stats, stat)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Score != stats[j].Score {
+			return stats[i].Score > stats[j].Score
+		}
+		return stats[i].Job < stats[j].Job
+	})
+	return stats
+}
+
+// The "bucket" string in input is used for displaying and logging. It is not
+// used to fetch anything from GCS.
+func parseBuildsFromCache(bucketPrefixes []string, limit int) ([]BuildResult, error) {
+	// Let's only select the last few PRs.
+	artifacts, err := findCachedArtifacts(bucketPrefixes, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cached artifacts: %v", err)
+	}
+
+	var results []BuildResult
+	for _, artifact := range artifacts {
+		if !isProwJobFile.MatchString(artifact) {
+			continue
+		}
+
+		bytes, err := loadFromCache(artifact)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load from file %s, was expected to be already in cache: %w", artifact, err)
+		}
+
+		type prowJobV1 struct {
+			Spec struct {
+				Type      string `json:"type"`
+				Agent     string `json:"agent"`
+				Cluster   string `json:"cluster"`
+				Namespace string `json:"namespace"`
+				Job       string `json:"job"`
+				Refs      struct {
+					Org      string `json:"org"`
+					Repo     string `json:"repo"`
+					RepoLink string `json:"repo_link"`
+					BaseRef  string `json:"base_ref"`
+					BaseSha  string `json:"base_sha"`
+					BaseLink string `json:"base_link"`
+					Pulls    []struct {
+						Number     int    `json:"number"`
+						Author     string `json:"author"`
+						Sha        string `json:"sha"`
+						Title      string `json:"title"`
+						Link       string `json:"link"`
+						CommitLink string `json:"commit_link"`
+						AuthorLink string `json:"author_link"`
+					} `json:"pulls"`
+				} `json:"refs"`
+				Report         bool   `json:"report"`
+				Context        string `json:"context"`
+				RerunCommand   string `json:"rerun_command"`
+				MaxConcurrency int    `json:"max_concurrency"`
+				PodSpec        struct {
+					Volumes []struct {
+						Name     string `json:"name"`
+						HostPath struct {
+							Path string `json:"path"`
+							Type string `json:"type"`
+						} `json:"hostPath,omitempty"`
+						Secret struct {
+							SecretName string `json:"secretName"`
+						} `json:"secret,omitempty"`
+						EmptyDir struct {
+						} `json:"emptyDir,omitempty"`
+					} `json:"volumes"`
+					Containers []struct {
+						Name  string   `json:"name"`
+						Image string   `json:"image"`
+						Args  []string `json:"args"`
+						Env   []struct {
+							Name  string `json:"name"`
+							Value string `json:"value"`
+						} `json:"env"`
+						Resources struct {
+							Requests struct {
+								CPU    string `json:"cpu"`
+								Memory string `json:"memory"`
+							} `json:"requests"`
+						} `json:"resources"`
+						VolumeMounts []struct {
+							Name      string `json:"name"`
+							ReadOnly  bool   `json:"readOnly,omitempty"`
+							MountPath string `json:"mountPath"`
+						} `json:"volumeMounts"`
+						SecurityContext struct {
+							Capabilities struct {
+								Add []string `json:"add"`
+							} `json:"capabilities"`
+							Privileged bool `json:"privileged"`
+						} `json:"securityContext"`
+					} `json:"containers"`
+					DNSConfig struct {
+						Options []struct {
+							Name  string `json:"name"`
+							Value string `json:"value"`
+						} `json:"options"`
+					} `json:"dnsConfig"`
+				} `json:"pod_spec"`
+			} `json:"spec"`
+			Status struct {
+				StartTime      time.Time `json:"startTime"`
+				PendingTime    time.Time `json:"pendingTime"`
+				CompletionTime time.Time `json:"completionTime"`
+				State          string    `json:"state"`
+				Description    string    `json:"description"`
+				URL            string    `json:"url"`
+				PodName        string    `json:"pod_name"`
+				BuildID        string    `json:"build_id"`
+			} `json:"status"`
+		}
+
+		prowjob := prowJobV1{}
+		err = json.Unmarshal(bytes, &prowjob)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prowjob.json file %s: %w", artifact, err)
+		}
+
+		duration := int(math.Floor(prowjob.Status.CompletionTime.Sub(prowjob.Status.StartTime).Seconds()))
+		pending := 0
+		if !prowjob.Status.PendingTime.IsZero() {
+			pending = int(math.Floor(prowjob.Status.PendingTime.Sub(prowjob.Status.StartTime).Seconds()))
+		}
+		var status BuildStatus
+		switch prowjob.Status.State {
+		case "success":
+			status = BuildSuccess
+		case "failure":
+			status = BuildFailed
+		case "pending", "aborted":
+			// We don't care about pending builds. Aborted builds are not
+			// interesting either since their duration won't make sense.
+			continue
+		default:
+			return nil, fmt.Errorf("developer mistake: unknown state %s", prowjob.Status.State)
+		}
+
+		errStr := ""
+		if prowjob.Status.State != "success" {
+			errStr = prowjob.Status.Description
+		}
+
+		objectName := strings.TrimPrefix(artifact, cacheDir+"/")
+		pr, _, build, err := parseObjectName(objectName)
+		if err != nil {
+			return nil, fmt.Errorf("parsing object name %s: %w", objectName, err)
+		}
+
+		sha := ""
+		if len(prowjob.Spec.Refs.Pulls) == 1 {
+			sha = prowjob.Spec.Refs.Pulls[0].Sha
+		}
+
+		results = append(results, BuildResult{
+			JobName:  prowjob.Spec.Job,
+			Status:   status,
+			Duration: duration,
+			Pending:  pending,
+			URL:      prowjob.Status.URL,
+			Err:      errStr,
+			Started:  prowjob.Status.StartTime,
+			PR:       pr,
+			Build:    build,
+			Sha:      sha,
+		})
+	}
+
+	if anonymized {
+		for i := range results {
+			results[i].Err = anonymize(results[i].Err)
+			results[i].URL = anonymize(results[i].URL)
+		}
+	}
+	return results, nil
+}
+
+// The findCachedArtifacts function returns the paths of the artifacts that
+// have already been downloaded for a given bucket prefix. A bucket prefix
+// is the string that you would give to gsutil when trying to list all the
+// builds. For example, "pr-logs/pull/cert-manager_cert-manager" is a valid
+// bucket prefix that you could use to list builds:
+//
+//	gsutil ls "gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/**"
+//	                              <------------------------------------>
+//	                                           bucket prefix
+//
+// Just for the sake of completeness, this gsutil command returns something
+// like this:
+//
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_bazel.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_make-test-ci.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/latest-build.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/artifacts/junit_bazel.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/artifacts/junit_bazel.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/artifacts/junit_bazel.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/latest-build.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/artifacts/junit_bazel.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/artifacts/junit_bazel.xml
+//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
+//	                                                          <-->                         <----------------->
+//	                                                        pr number                          build number
+//
+// In the case of findCachedArtifacts, imagining that the above artifacts
+// were previously downloaded by downloadBuildArtifactsToCache, then the
+// following paths get returned:
+//
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_bazel.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_make-test-ci.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/latest-build.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/artifacts/junit_bazel.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/artifacts/junit_bazel.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/artifacts/junit_bazel.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/latest-build.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/artifacts/junit_bazel.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/artifacts/junit_bazel.xml
+//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
+func findCachedArtifacts(bucketPrefixes []string, countBuilds int) ([]string, error) {
+	var prDirs []string
+	for _, bucketPrefix := range bucketPrefixes {
+		prDirEntries, err := os.ReadDir(cacheDir + "/" + bucketPrefix)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read current directory: %v", err)
+		}
+		for _, dirEntry := range prDirEntries {
+			if !dirEntry.IsDir() {
+				continue
+			}
+			prDirs = append(prDirs, cacheDir+"/"+bucketPrefix+"/"+dirEntry.Name())
+		}
+	}
+
+	prDirs, err := sortNumericDesc(prDirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sort PR prefixes: %w", err)
+	}
+
+	countJobs := 0
+	var artifacts []string
+	for _, prDir := range prDirs {
+		err := filepath.Walk(prDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() && isCachedBuildOutsideWindow(path) {
+				return filepath.SkipDir
+			}
+
+			if isOutsideWindow(strings.TrimPrefix(path, cacheDir+"/")) || !isSelectedBuild(strings.TrimPrefix(path, cacheDir+"/")) {
+				return nil
+			}
+
+			if isProwJobFile.MatchString(path) {
+				countJobs++
+			}
+
+			artifacts = append(artifacts, path)
+
+			if countJobs >= countBuilds {
+				return io.EOF
+			}
+
+			return nil
+		})
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to recurse into %s: %w", prDir, err)
+		}
+		if countJobs >= countBuilds {
+			break
+		}
+	}
+	return artifacts, nil
+}
+
+type StatsMaxDuration struct {
+	Name              string  `json:"name"`
+	MaxDurationPassed float64 `json:"maxDurationPassed"` // in seconds
+	MaxDurationFailed float64 `json:"maxDurationFailed"`
+}
+
+func computeStatsMaxDuration(results []GinkgoResult) []StatsMaxDuration {
+	type max struct {
+		success time.Duration
+		failed  time.Duration
+	}
+
+	// The key is the test name.
+	maxMap := make(map[string]max)
+
+	var testNames []string
+	for _, test := range results {
+		if _, ok := maxMap[test.Name]; !ok {
+			testNames = append(testNames, test.Name)
+			maxMap[test.Name] = max{success: 0, failed: 0}
+		}
+		cur := maxMap[test.Name]
+		switch test.Status {
+		case statusPassed:
+			if cur.success < test.Duration {
+				cur.success = test.Duration
+			}
+		case statusFailed, statusTimedOut, statusPanicked:
+			if cur.failed < test.Duration {
+				cur.failed = test.Duration
+			}
+		}
+		maxMap[test.Name] = cur
+	}
+
+	// If there has been no failure, then we cannot say anything about the
+	// timeout. So we filter out the tests that have no failure.
+	var testNamesFiltered []string
+	for _, name := range testNames {
+		if maxMap[name].failed == 0 {
+			continue
+		}
+		testNamesFiltered = append(testNamesFiltered, name)
+	}
+	testNames = testNamesFiltered
+
+	// We want to see the test cases for which the
+	sort.Slice(testNames, func(i, j int) bool {
+		di := maxMap[testNames[i]].failed - maxMap[testNames[i]].success
+		dj := maxMap[testNames[j]].failed - maxMap[testNames[j]].success
+		if di != dj {
+			return di < dj
+		}
+		return testNames[i] < testNames[j]
+	})
+
+	var stats []StatsMaxDuration
+	for _, name := range testNames {
+		stats = append(stats, StatsMaxDuration{
+			Name:              name,
+			MaxDurationPassed: maxMap[name].success.Seconds(),
+			MaxDurationFailed: maxMap[name].failed.Seconds(),
+		})
+	}
+	return stats
+}
+
+type StatsMostFailures struct {
+	Name        string         `json:"name"`
+	CountPassed int            `json:"countPassed"`
+	CountFailed int            `json:"countFailed"`
+	Errors      []GinkgoResult `json:"errors"`
+
+	// CountFailed divided by the sum of CountPassed and CountFailed, along
+	// with its 95% confidence interval computed with the Wilson score.
+	FailureRate     float64 `json:"failureRate"`
+	FailureRateLow  float64 `json:"failureRateLow"`
+	FailureRateHigh float64 `json:"failureRateHigh"`
+
+	// The start time of the builds in which the test failed for the first
+	// and for the last time. Tells a fresh regression apart from a known
+	// flake. Zero when the build IDs don't tell when the builds started.
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// filterStatsMostFailures only keeps the tests that failed at least
+// minFailures times and that ran at least minRuns times.
+func filterStatsMostFailures(stats []StatsMostFailures, minFailures, minRuns int) []StatsMostFailures {
+	var filtered []StatsMostFailures
+	for _, stat := range stats {
+		if stat.CountFailed < minFailures || stat.CountPassed+stat.CountFailed < minRuns {
+			continue
+		}
+		filtered = append(filtered, stat)
+	}
+	return filtered
+}
+
+// Sorted by ascending order of count of failures. Tests with no failures
+// are skipped.
+func computeStatsMostFailures(results []GinkgoResult) []StatsMostFailures {
+	type count struct {
+		passed int
+		failed []GinkgoResult
+	}
+
+	// The key is the test name. The value is a list of failure messages.
+	countMap := make(map[string]count)
+
+	var testNames []string
+	for _, test := range results {
+		if !test.Status.isFailed() && test.Status != statusPassed {
+			continue
+		}
+
+		if _, ok := countMap[test.Name]; !ok {
+			testNames = append(testNames, test.Name)
+			countMap[test.Name] = count{}
+		}
+
+		cur := countMap[test.Name]
+		switch test.Status {
+		case statusPassed:
+			cur.passed += 1
+		case statusFailed, statusTimedOut, statusPanicked:
+			cur.failed = append(cur.failed, test)
+		}
+		countMap[test.Name] = cur
+	}
+
+	sort.Slice(testNames, func(i, j int) bool {
+		fi, fj := len(countMap[testNames[i]].failed), len(countMap[testNames[j]].failed)
+		if fi != fj {
+			return fi < fj
+		}
+		return testNames[i] < testNames[j]
+	})
+
+	var stats []StatsMostFailures
+	for _, name := range testNames {
+		if len(countMap[name].failed) == 0 {
+			continue
+		}
+
+		passed, failed := countMap[name].passed, len(countMap[name].failed)
+		low, high := wilsonInterval(failed, passed+failed)
+
+		var first, last time.Time
+		for _, result := range countMap[name].failed {
+			if result.Started.IsZero() {
+				continue
+			}
+			if first.IsZero() || result.Started.Before(first) {
+				first = result.Started
+			}
+			if result.Started.After(last) {
+				last = result.Started
+			}
+		}
+
+		stats = append(stats, StatsMostFailures{
+			Name:            name,
+			CountPassed:     passed,
+			CountFailed:     failed,
+			Errors:          countMap[name].failed,
+			FailureRate:     float64(failed) / float64(passed+failed),
+			FailureRateLow:  low,
+			FailureRateHigh: high,
+			FirstSeen:       first,
+			LastSeen:        last,
+		})
+	}
+	return stats
+}
+
+// sortStatsMostFailures sorts the stats in ascending order so that the worst
+// tests are shown at the bottom, right above the prompt. The "by" string is
+// one of "count", "rate", or "lower-bound".
+func sortStatsMostFailures(stats []StatsMostFailures, by string) {
+	key := func(stat StatsMostFailures) float64 {
+		switch by {
+		case "count":
+			return float64(stat.CountFailed)
+		case "rate":
+			return stat.FailureRate
+		case "lower-bound":
+			return stat.FailureRateLow
+		default:
+			panic("developer mistake: unknown sort: " + by)
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		if key(stats[i]) != key(stats[j]) {
+			return key(stats[i]) < key(stats[j])
+		}
+		return stats[i].Name < stats[j].Name
+	})
+}
+
+// wilsonInterval returns the 95% confidence interval of the proportion
+// successes/total using the Wilson score, which behaves well with small
+// totals, unlike the normal approximation. For example, 1 failure out of 2
+// runs gives [9%, 91%] while 20 failures out of 200 runs gives [7%, 15%].
+func wilsonInterval(successes, total int) (low, high float64) {
+	if total == 0 {
+		return 0, 1
+	}
+	const z = 1.96 // 95% confidence.
+	n := float64(total)
+	p := float64(successes) / n
+	denominator := 1 + z*z/n
+	center := (p + z*z/(2*n)) / denominator
+	halfWidth := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / denominator
+	return math.Max(0, center-halfWidth), math.Min(1, center+halfWidth)
+}
+
+// binomialTail returns the chance of seeing at least k successes out of n
+// trials when each trial succeeds with the probability p.
+func binomialTail(n, k int, p float64) float64 {
+	if k <= 0 {
+		return 1
+	}
+	if k > n {
+		return 0
+	}
+	// C(n, i) p^i (1-p)^(n-i) is computed with logarithms since C(n, i)
+	// overflows quickly.
+	lnC, _ := math.Lgamma(float64(n + 1))
+	var tail float64
+	for i := k; i <= n; i++ {
+		lnI, _ := math.Lgamma(float64(i + 1))
+		lnNI, _ := math.Lgamma(float64(n - i + 1))
+		tail += math.Exp(lnC-lnI-lnNI) * math.Pow(p, float64(i)) * math.Pow(1-p, float64(n-i))
+	}
+	return math.Min(1, tail)
+}
+
+// lessResult orders the results by name, then job, then build number, then
+// source, so that the outputs don't depend on the order in which the files
+// were parsed.
+func lessResult(a, b GinkgoResult) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Job != b.Job {
+		return a.Job < b.Job
+	}
+	if a.Build != b.Build {
+		return a.Build < b.Build
+	}
+	return a.Source < b.Source
+}
+
+// StatsPerJob is a matrix of the test results per test and per job.
+type StatsPerJob struct {
+	// The columns of the matrix, sorted alphabetically.
+	Jobs []string `json:"jobs"`
+
+	// The rows of the matrix, in the same order as with
+	// computeStatsMostFailures.
+	Tests []StatsPerJobRow `json:"tests"`
+}
+
+type StatsPerJobRow struct {
+	Name string `json:"name"`
+
+	// The counts for each job, in the same order as StatsPerJob.Jobs.
+	CountPassed []int `json:"countPassed"`
+	CountFailed []int `json:"countFailed"`
+}
+
+// Only the tests that have at least one failure are shown, and only the jobs
+// in which these tests ran are shown.
+func computeStatsPerJob(results []GinkgoResult) StatsPerJob {
+	matrix := StatsPerJob{Jobs: []string{}, Tests: []StatsPerJobRow{}}
+
+	failing := computeStatsMostFailures(results)
+	isFailing := make(map[string]bool)
+	for _, stat := range failing {
+		isFailing[stat.Name] = true
+	}
+
+	jobIndex := make(map[string]int)
+	for _, res := range results {
+		if !isFailing[res.Name] {
+			continue
+		}
+		if _, ok := jobIndex[res.Job]; !ok {
+			jobIndex[res.Job] = 0
+			matrix.Jobs = append(matrix.Jobs, res.Job)
+		}
+	}
+	sort.Strings(matrix.Jobs)
+	for i, job := range matrix.Jobs {
+		jobIndex[job] = i
+	}
+
+	rowIndex := make(map[string]int)
+	for i, stat := range failing {
+		rowIndex[stat.Name] = i
+		matrix.Tests = append(matrix.Tests, StatsPerJobRow{
+			Name:        stat.Name,
+			CountPassed: make([]int, len(matrix.Jobs)),
+			CountFailed: make([]int, len(matrix.Jobs)),
+		})
+	}
+	for _, res := range results {
+		if !isFailing[res.Name] {
+			continue
+		}
+		row := matrix.Tests[rowIndex[res.Name]]
+		switch res.Status {
+		case statusPassed:
+			row.CountPassed[jobIndex[res.Job]]++
+		case statusFailed, statusTimedOut, statusPanicked:
+			row.CountFailed[jobIndex[res.Job]]++
+		}
+	}
+	return matrix
+}
+
+// printStatsPerJob shows the matrix in a compact form. Since the job names are
+// long, the jobs are numbered and the numbers are used as column headers:
+//
+//	1: pull-cert-manager-e2e-v1-20
+//	2: pull-cert-manager-e2e-v1-24
+//
+//	1 2
+//	3 .  Vault Issuer should generate a new certificate
+//	- 1  ACME Certificate (HTTP01) should obtain a signed certificate
+func printStatsPerJob(out io.Writer, matrix StatsPerJob, sources map[string]string) error {
+	for i, job := range matrix.Jobs {
+		fmt.Fprintf(out, "%d: %s\n", i+1, job)
+	}
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	var header []string
+	for i := range matrix.Jobs {
+		header = append(header, strconv.Itoa(i+1))
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range matrix.Tests {
+		for i := range matrix.Jobs {
+			switch {
+			case row.CountFailed[i] > 0:
+				fmt.Fprintf(w, "%s\t", red(row.CountFailed[i]))
+			case row.CountPassed[i] > 0:
+				fmt.Fprintf(w, "%s\t", green("."))
+			default:
+				fmt.Fprintf(w, "%s\t", gray("-"))
+			}
+		}
+		fmt.Fprintf(w, " %s%s\n", row.Name, link(sources[row.Name]))
+	}
+	return w.Flush()
+}
+
+// excludeMassFailures returns the results that don't belong to a mass-failure
+// build.
+func excludeMassFailures(results []GinkgoResult) []GinkgoResult {
+	var kept []GinkgoResult
+	for _, res := range results {
+		if res.MassFailure {
+			continue
+		}
+		kept = append(kept, res)
+	}
+	return kept
+}
+
+// StatsFlakes tells how often a test failed and then passed when retried
+// within the same build, e.g. with Ginkgo's --flake-attempts.
+type StatsFlakes struct {
+	Name string `json:"name"`
+
+	// Number of builds in which the test ran, and number of builds in which
+	// it both failed and passed.
+	Builds      int `json:"builds"`
+	FlakyBuilds int `json:"flakyBuilds"`
+
+	// FlakyBuilds divided by Builds.
+	FlakeRate float64 `json:"flakeRate"`
+
+	// The start time of the last build in which the test flaked. Zero when
+	// the build IDs don't tell when the builds started.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// computeStatsFlakes looks for the tests that both failed and passed within
+// the same build. A build is identified by its PR, job, and build number. A
+// test is also considered to have flaked when it passed after more than one
+// attempt, since the failed attempts aren't always reported. The tests that
+// never flaked are skipped. The stats are sorted by flake rate in ascending
+// order, and then by number of flaky builds.
+func computeStatsFlakes(results []GinkgoResult) []StatsFlakes {
+	type key struct {
+		pr    int
+		job   string
+		build int
+		name  string
+	}
+	type runs struct {
+		passed, failed bool
+		started        time.Time
+	}
+	perBuild := make(map[key]*runs)
+	var keys []key
+	for _, res := range results {
+		k := key{pr: res.PR, job: res.Job, build: res.Build, name: res.Name}
+		r, ok := perBuild[k]
+		if !ok {
+			r = &runs{started: res.Started}
+			perBuild[k] = r
+			keys = append(keys, k)
+		}
+		switch {
+		case res.Status == statusPassed && res.Attempts > 1:
+			r.passed, r.failed = true, true
+		case res.Status == statusPassed:
+			r.passed = true
+		case res.Status.isFailed():
+			r.failed = true
+		}
+	}
+
+	statsMap := make(map[string]*StatsFlakes)
+	var names []string
+	for _, k := range keys {
+		stat, ok := statsMap[k.name]
+		if !ok {
+			stat = &StatsFlakes{Name: k.name}
+			statsMap[k.name] = stat
+			names = append(names, k.name)
+		}
+		stat.Builds++
+
+		r := perBuild[k]
+		if !r.passed || !r.failed {
+			continue
+		}
+		stat.FlakyBuilds++
+		if r.started.After(stat.LastSeen) {
+			stat.LastSeen = r.started
+		}
+	}
+
+	var stats []StatsFlakes
+	for _, name := range names {
+		stat := statsMap[name]
+		if stat.FlakyBuilds == 0 {
+			continue
+		}
+		stat.FlakeRate = float64(stat.FlakyBuilds) / float64(stat.Builds)
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].FlakeRate != stats[j].FlakeRate {
+			return stats[i].FlakeRate < stats[j].FlakeRate
+		}
+		if stats[i].FlakyBuilds != stats[j].FlakyBuilds {
+			return stats[i].FlakyBuilds < stats[j].FlakyBuilds
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// FlakeWeights are the weights given to each component of the flake score.
+type FlakeWeights struct {
+	Rate, Recency, Spread, Diversity float64
+}
+
+type FlakeScore struct {
+	Name        string `json:"name"`
+	CountPassed int    `json:"countPassed"`
+	CountFailed int    `json:"countFailed"`
+
+	// The weighted average of the four components below.
+	Score float64 `json:"score"`
+
+	// The components of the score, each between 0 and 1.
 	//
-	// Our heuristic chooses the
+	// The failure rate is CountFailed divided by the sum of CountPassed and
+	// CountFailed. The recency is the average of 0.5^(age/half-life) over
+	// the failures, where the age is computed from the most recent build,
+	// so it is 1 when all the failures are recent. The spread is the ratio
+	// of jobs in which the test failed over the jobs in which it ran,
+	// averaged with the same ratio for PRs. The diversity is the number of
+	// error clusters over the number of failures.
+	FailureRate float64 `json:"failureRate"`
+	Recency     float64 `json:"recency"`
+	Spread      float64 `json:"spread"`
+	Diversity   float64 `json:"diversity"`
+}
+
+// computeFlakeScores returns the flake score of each test that failed at least
+// once, sorted by score in ascending order.
+func computeFlakeScores(results []GinkgoResult, weights FlakeWeights, halfLife time.Duration) []FlakeScore {
+	var newest time.Time
+	byName := make(map[string][]GinkgoResult)
+	var names []string
+	for _, res := range results {
+		if res.Started.After(newest) {
+			newest = res.Started
+		}
+		if _, ok := byName[res.Name]; !ok {
+			names = append(names, res.Name)
+		}
+		byName[res.Name] = append(byName[res.Name], res)
+	}
+	sort.Strings(names)
+
+	totalWeight := weights.Rate + weights.Recency + weights.Spread + weights.Diversity
+
+	var scores []FlakeScore
+	for _, name := range names {
+		var failed []GinkgoResult
+		passed := 0
+		ranJobs, ranPRs := make(map[string]struct{}), make(map[int]struct{})
+		failedJobs, failedPRs := make(map[string]struct{}), make(map[int]struct{})
+		recency := 0.0
+		for _, res := range byName[name] {
+			switch res.Status {
+			case statusPassed:
+				passed++
+			case statusFailed, statusTimedOut, statusPanicked:
+				failed = append(failed, res)
+				failedJobs[res.Job] = struct{}{}
+				failedPRs[res.PR] = struct{}{}
+
+				// The failures for which we don't know when they happened
+				// are considered recent.
+				age := time.Duration(0)
+				if !res.Started.IsZero() {
+					age = newest.Sub(res.Started)
+				}
+				recency += math.Pow(0.5, float64(age)/float64(halfLife))
+			default:
+				continue
+			}
+			ranJobs[res.Job] = struct{}{}
+			ranPRs[res.PR] = struct{}{}
+		}
+		if len(failed) == 0 {
+			continue
+		}
+
+		score := FlakeScore{
+			Name:        name,
+			CountPassed: passed,
+			CountFailed: len(failed),
+			FailureRate: float64(len(failed)) / float64(passed+len(failed)),
+			Recency:     recency / float64(len(failed)),
+			Spread:      (float64(len(failedJobs))/float64(len(ranJobs)) + float64(len(failedPRs))/float64(len(ranPRs))) / 2,
+			Diversity:   float64(len(clusterErrors(failed, 0.8))) / float64(len(failed)),
+		}
+		if totalWeight > 0 {
+			score.Score = (weights.Rate*score.FailureRate + weights.Recency*score.Recency + weights.Spread*score.Spread + weights.Diversity*score.Diversity) / totalWeight
+		}
+		scores = append(scores, score)
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score < scores[j].Score
+		}
+		return scores[i].Name < scores[j].Name
+	})
+	return scores
+}
+
+// Slowdown is a test for which the recent durations are higher than the
+// baseline.
+type Slowdown struct {
+	Name string `json:"name"`
+
+	// Median durations in seconds of the "passed" runs in the baseline builds
+	// and in the recent builds, and the number of runs in each.
+	BaselineMedian float64 `json:"baselineMedian"`
+	BaselineRuns   int     `json:"baselineRuns"`
+	RecentMedian   float64 `json:"recentMedian"`
+	RecentRuns     int     `json:"recentRuns"`
+
+	// RecentMedian divided by BaselineMedian.
+	Factor float64 `json:"factor"`
+}
+
+// computeSlowdowns splits the builds into the "recent" most recent builds and
+// the baseline builds, and returns the tests for which the median duration of
+// the recent "passed" runs is at least "factor" times the median duration of
+// the baseline "passed" runs. The builds are ordered using the build number,
+// which increases with time. The slowdowns are sorted by factor in ascending
+// order.
+func computeSlowdowns(results []GinkgoResult, recent int, factor float64, minRuns int) []Slowdown {
+	var builds []int
+	seen := make(map[int]struct{})
+	for _, res := range results {
+		if _, ok := seen[res.Build]; ok {
+			continue
+		}
+		seen[res.Build] = struct{}{}
+		builds = append(builds, res.Build)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(builds)))
+	isRecent := make(map[int]bool)
+	for i := 0; i < recent && i < len(builds); i++ {
+		isRecent[builds[i]] = true
+	}
+
+	type durations struct {
+		baseline, recent []time.Duration
+	}
+	byName := make(map[string]*durations)
+	var names []string
+	for _, res := range results {
+		if res.Status != statusPassed {
+			continue
+		}
+		d, ok := byName[res.Name]
+		if !ok {
+			d = &durations{}
+			byName[res.Name] = d
+			names = append(names, res.Name)
+		}
+		if isRecent[res.Build] {
+			d.recent = append(d.recent, res.Duration)
+		} else {
+			d.baseline = append(d.baseline, res.Duration)
+		}
+	}
+	sort.Strings(names)
+
+	var slowdowns []Slowdown
+	for _, name := range names {
+		d := byName[name]
+		if len(d.recent) == 0 || len(d.baseline) < minRuns {
+			continue
+		}
+		baseline, recentMedian := medianDuration(d.baseline), medianDuration(d.recent)
+		if baseline == 0 || float64(recentMedian) < factor*float64(baseline) {
+			continue
+		}
+		slowdowns = append(slowdowns, Slowdown{
+			Name:           name,
+			BaselineMedian: baseline.Seconds(),
+			BaselineRuns:   len(d.baseline),
+			RecentMedian:   recentMedian.Seconds(),
+			RecentRuns:     len(d.recent),
+			Factor:         float64(recentMedian) / float64(baseline),
+		})
+	}
+
+	sort.SliceStable(slowdowns, func(i, j int) bool {
+		return slowdowns[i].Factor < slowdowns[j].Factor
+	})
+	return slowdowns
+}
+
+const (
+	attributionPreExisting = "pre-existing"
+	attributionUnknown     = "unknown"
+	attributionIntroduced  = "introduced"
+)
+
+// FailureAttribution tells whether a test that failed in a PR is likely a
+// pre-existing flake or likely introduced by the PR.
+type FailureAttribution struct {
+	Name string `json:"name"`
+
+	// Label is either "pre-existing", "introduced", or "unknown" when the
+	// test didn't run enough times in the periodic builds.
+	Label string `json:"label"`
+
+	// The runs (passed or failed) and the failures in the builds of the PR
+	// and in the periodic builds, and the failure rate of the periodic
+	// builds.
+	PRRuns           int     `json:"prRuns"`
+	PRFailures       int     `json:"prFailures"`
+	BaselineRuns     int     `json:"baselineRuns"`
+	BaselineFailures int     `json:"baselineFailures"`
+	BaselineRate     float64 `json:"baselineRate"`
+
+	// The chance of the test failing at least PRFailures times out of PRRuns
+	// runs given BaselineRate, between 0 and 1.
+	Chance float64 `json:"chance"`
+
+	// The last error of the test in the PR, and where it was found.
+	Err    string `json:"err"`
+	Source string `json:"source"`
+
+	// (optional) The files changed by the PR that contain the location of
+	// one of the failures of the test in the PR (errLoc), or that are in the
+	// same package. Only known when a GitHub token is given.
+	ChangedFiles []string `json:"changedFiles,omitempty"`
+}
+
+// attributeFailures labels each test that failed in the builds of the given
+// PR by comparing its failures to its failure rate in the baseline builds. A
+// test that fails 1% of the time in the baseline and failed once in the PR is
+// likely a pre-existing flake; a test that never failed in the baseline is
+// likely broken by the PR. The failure is likely introduced by the PR when the
+// chance of seeing at least as many failures, given the baseline failure
+// rate, is lower than the threshold. The tests that ran fewer than minRuns
+// times in the baseline are labeled "unknown", unless one of their failures
+// is located in the files changed by the PR (see overlappingFiles), in which
+// case they are likely introduced by the PR. The pre-existing flakes come
+// first, then the unknown ones, then the introduced ones. Within each label,
+// the failures that touch the changed files come last, and the others are
+// sorted by decreasing chance so that the worst are shown last.
+func attributeFailures(pr int, prResults, baseline []GinkgoResult, changed []string, minRuns int, threshold float64) []FailureAttribution {
+	type counts struct {
+		runs, failures int
+	}
+	inBaseline := make(map[string]*counts)
+	for _, res := range baseline {
+		if res.Status != statusPassed && !res.Status.isFailed() {
+			continue
+		}
+		c, ok := inBaseline[res.Name]
+		if !ok {
+			c = &counts{}
+			inBaseline[res.Name] = c
+		}
+		c.runs++
+		if res.Status.isFailed() {
+			c.failures++
+		}
+	}
+
+	byName := make(map[string]*FailureAttribution)
+	var names []string
+	lastFailure := make(map[string]GinkgoResult)
+	errLocs := make(map[string][]string)
+	for _, res := range prResults {
+		if res.PR != pr || (res.Status != statusPassed && !res.Status.isFailed()) {
+			continue
+		}
+		a, ok := byName[res.Name]
+		if !ok {
+			a = &FailureAttribution{Name: res.Name}
+			byName[res.Name] = a
+			names = append(names, res.Name)
+		}
+		a.PRRuns++
+		if !res.Status.isFailed() {
+			continue
+		}
+		a.PRFailures++
+		if res.ErrLoc != "" {
+			errLocs[res.Name] = append(errLocs[res.Name], res.ErrLoc)
+		}
+		if last, ok := lastFailure[res.Name]; !ok || res.Build > last.Build {
+			lastFailure[res.Name] = res
+		}
+	}
+	sort.Strings(names)
 
-	// We need to "filter" the ProwJobs by the prefix of the GCS bucket.
-	//     gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
+	var attributions []FailureAttribution
+	for _, name := range names {
+		a := byName[name]
+		if a.PRFailures == 0 {
+			continue
+		}
+		a.Err, a.Source = lastFailure[name].Err, lastFailure[name].Source
+		if c, ok := inBaseline[name]; ok {
+			a.BaselineRuns, a.BaselineFailures = c.runs, c.failures
+		}
+		if a.BaselineRuns > 0 {
+			a.BaselineRate = float64(a.BaselineFailures) / float64(a.BaselineRuns)
+		}
+		a.Chance = binomialTail(a.PRRuns, a.PRFailures, a.BaselineRate)
+		a.ChangedFiles = overlappingFiles(errLocs[name], changed)
+		switch {
+		case (a.BaselineRuns < minRuns || a.BaselineRuns == 0) && len(a.ChangedFiles) > 0:
+			a.Label = attributionIntroduced
+		case a.BaselineRuns < minRuns || a.BaselineRuns == 0:
+			a.Label = attributionUnknown
+		case a.Chance < threshold:
+			a.Label = attributionIntroduced
+		default:
+			a.Label = attributionPreExisting
+		}
+		attributions = append(attributions, *a)
+	}
 
-	//   gsutil ls gs://jetstack-logs/logs/ci-cert-manager-previous-e2e-v1-20/latest-build.txt
-	//
-	//
-	url := "https://prow.build-infra.jetstack.net/prowjobs.js?var=allBuilds"
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to list the latest builds from prow.build-infra.jetstack.net: %w", err)
+	rank := map[string]int{attributionPreExisting: 0, attributionUnknown: 1, attributionIntroduced: 2}
+	sort.SliceStable(attributions, func(i, j int) bool {
+		a, b := attributions[i], attributions[j]
+		if a.Label != b.Label {
+			return rank[a.Label] < rank[b.Label]
+		}
+		if (len(a.ChangedFiles) > 0) != (len(b.ChangedFiles) > 0) {
+			return len(b.ChangedFiles) > 0
+		}
+		return a.Chance > b.Chance
+	})
+	return attributions
+}
+
+// overlappingFiles returns the changed files that contain one of the given
+// error locations, or that are in the same directory, i.e. the same Go
+// package. The error locations look like "test/e2e/suite/issuers/vault.go:202"
+// and may be absolute paths, e.g.
+// "/home/prow/go/src/github.com/cert-manager/cert-manager/test/e2e/suite/issuers/vault.go:202",
+// which is why the changed files, relative to the root of the repository, are
+// matched against the end of the locations. The files at the root of the
+// repository are only matched when they contain the location.
+func overlappingFiles(errLocs, changed []string) []string {
+	endsWith := func(loc, file string) bool {
+		return loc == file || strings.HasSuffix(loc, "/"+file)
 	}
-	defer resp.Body.Close()
+	var files []string
+	for _, file := range changed {
+		for _, loc := range errLocs {
+			if i := strings.LastIndex(loc, ":"); i != -1 && isNumber(loc[i+1:]) {
+				loc = loc[:i]
+			}
+			if endsWith(loc, file) || (path.Dir(file) != "." && endsWith(path.Dir(loc), path.Dir(file))) {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	return files
+}
 
-	type ProwJob struct {
-		Kind       string `json:"kind"`
-		APIVersion string `json:"apiVersion"`
-		Metadata   struct {
-			Name              string    `json:"name"`
-			Namespace         string    `json:"namespace"`
-			UID               string    `json:"uid"`
-			ResourceVersion   string    `json:"resourceVersion"`
-			Generation        int       `json:"generation"`
-			CreationTimestamp time.Time `json:"creationTimestamp"`
-			Labels            struct {
-				CreatedByProw                         string `json:"created-by-prow"`
-				PresetCloudflareCredentials           string `json:"preset-cloudflare-credentials"`
-				PresetDefaultE2EVolumes               string `json:"preset-default-e2e-volumes"`
-				PresetDindEnabled                     string `json:"preset-dind-enabled"`
-				PresetEnableAllFeatureGatesDisableSsa string `json:"preset-enable-all-feature-gates-disable-ssa"`
-				PresetGinkgoSkipDefault               string `json:"preset-ginkgo-skip-default"`
-				PresetMakeVolumes                     string `json:"preset-make-volumes"`
-				PresetServiceAccount                  string `json:"preset-service-account"`
-				ProwK8SIoBuildID                      string `json:"prow.k8s.io/build-id"`
-				ProwK8SIoContext                      string `json:"prow.k8s.io/context"`
-				ProwK8SIoID                           string `json:"prow.k8s.io/id"`
-				ProwK8SIoJob                          string `json:"prow.k8s.io/job"`
-				ProwK8SIoRefsBaseRef                  string `json:"prow.k8s.io/refs.base_ref"`
-				ProwK8SIoRefsOrg                      string `json:"prow.k8s.io/refs.org"`
-				ProwK8SIoRefsRepo                     string `json:"prow.k8s.io/refs.repo"`
-				ProwK8SIoType                         string `json:"prow.k8s.io/type"`
-			} `json:"labels"`
-			Annotations struct {
-				Description             string `json:"description"`
-				ProwK8SIoContext        string `json:"prow.k8s.io/context"`
-				ProwK8SIoJob            string `json:"prow.k8s.io/job"`
-				TestgridAlertEmail      string `json:"testgrid-alert-email"`
-				TestgridCreateTestGroup string `json:"testgrid-create-test-group"`
-				TestgridDashboards      string `json:"testgrid-dashboards"`
-			} `json:"annotations"`
-		} `json:"metadata"`
-		Spec struct {
-			Type      string `json:"type"`
-			Agent     string `json:"agent"`
-			Cluster   string `json:"cluster"`
-			Namespace string `json:"namespace"`
-			Job       string `json:"job"`
-			ExtraRefs []struct {
-				Org     string `json:"org"`
-				Repo    string `json:"repo"`
-				BaseRef string `json:"base_ref"`
-			} `json:"extra_refs"`
-			Report  bool `json:"report"`
-			PodSpec struct {
-				Volumes []struct {
-					Name   string `json:"name"`
-					Secret struct {
-						SecretName string `json:"secretName"`
-					} `json:"secret,omitempty"`
-					EmptyDir struct {
-					} `json:"emptyDir,omitempty"`
-					HostPath struct {
-						Path string `json:"path"`
-						Type string `json:"type"`
-					} `json:"hostPath,omitempty"`
-				} `json:"volumes"`
-				Containers []struct {
-					Name  string   `json:"name"`
-					Image string   `json:"image"`
-					Args  []string `json:"args"`
-					Env   []struct {
-						Name      string `json:"name"`
-						Value     string `json:"value,omitempty"`
-						ValueFrom struct {
-							SecretKeyRef struct {
-								Name string `json:"name"`
-								Key  string `json:"key"`
-							} `json:"secretKeyRef"`
-						} `json:"valueFrom,omitempty"`
-					} `json:"env"`
-					Resources struct {
-						Requests struct {
-							CPU    string `json:"cpu"`
-							Memory string `json:"memory"`
-						} `json:"requests"`
-					} `json:"resources"`
-					VolumeMounts []struct {
-						Name      string `json:"name"`
-						ReadOnly  bool   `json:"readOnly,omitempty"`
-						MountPath string `json:"mountPath"`
-					} `json:"volumeMounts"`
-					SecurityContext struct {
-						Capabilities struct {
-							Add []string `json:"add"`
-						} `json:"capabilities"`
-						Privileged bool `json:"privileged"`
-					} `json:"securityContext"`
-				} `json:"containers"`
-				DNSConfig struct {
-					Options []struct {
-						Name  string `json:"name"`
-						Value string `json:"value"`
-					} `json:"options"`
-				} `json:"dnsConfig"`
-			} `json:"pod_spec"`
-			DecorationConfig struct {
-				Timeout       string `json:"timeout"`
-				GracePeriod   string `json:"grace_period"`
-				UtilityImages struct {
-					Clonerefs  string `json:"clonerefs"`
-					Initupload string `json:"initupload"`
-					Entrypoint string `json:"entrypoint"`
-					Sidecar    string `json:"sidecar"`
-				} `json:"utility_images"`
-				GcsConfiguration struct {
-					Bucket       string `json:"bucket"`
-					PathStrategy string `json:"path_strategy"`
-					DefaultOrg   string `json:"default_org"`
-					DefaultRepo  string `json:"default_repo"`
-				} `json:"gcs_configuration"`
-				GcsCredentialsSecret string `json:"gcs_credentials_secret"`
-			} `json:"decoration_config"`
-			ProwjobDefaults struct {
-				TenantID string `json:"tenant_id"`
-			} `json:"prowjob_defaults"`
-		} `json:"spec"`
-		Status struct {
-			StartTime        time.Time `json:"startTime"`
-			PendingTime      time.Time `json:"pendingTime"`
-			State            string    `json:"state"`
-			Description      string    `json:"description"`
-			URL              string    `json:"url"`
-			PodName          string    `json:"pod_name"`
-			BuildID          string    `json:"build_id"`
-			PrevReportStates struct {
-				Gcsk8Sreporter string `json:"gcsk8sreporter"`
-				Gcsreporter    string `json:"gcsreporter"`
-			} `json:"prev_report_states"`
-		} `json:"status"`
+// compareSide is one of the two sets of builds compared by "tests compare":
+// the builds that started within [From, To) and whose job matches Job. A zero
+// From or To and a nil Job mean no constraint.
+type compareSide struct {
+	From, To time.Time
+	Job      *regexp.Regexp
+}
+
+// filter returns the results that belong to the builds of this side. When a
+// time window is set, the results for which the start time of the build isn't
+// known are left out.
+func (side compareSide) filter(results []GinkgoResult) []GinkgoResult {
+	var kept []GinkgoResult
+	for _, res := range results {
+		if !side.From.IsZero() || !side.To.IsZero() {
+			if res.Started.IsZero() {
+				continue
+			}
+			if !side.From.IsZero() && res.Started.Before(side.From) {
+				continue
+			}
+			if !side.To.IsZero() && !res.Started.Before(side.To) {
+				continue
+			}
+		}
+		if side.Job != nil && !side.Job.MatchString(res.Job) {
+			continue
+		}
+		kept = append(kept, res)
+	}
+	return kept
+}
+
+// parseWindow parses the value of --before and --after, e.g.
+// "2024-05-01..2024-05-15" or "30d..14d". Each side is parsed with parseWhen,
+// and the date on the right side is included. An empty side, as in "14d..",
+// leaves the window open on that side; an empty string means no window.
+func parseWindow(s string, now time.Time) (from, to time.Time, err error) {
+	if s == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	parts := strings.SplitN(s, "..", 2)
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid window %q, expected the form 'from..to', e.g. '2024-05-01..2024-05-15'", s)
+	}
+	if parts[0] != "" {
+		from, err = parseWhen(parts[0], now, false)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if parts[1] != "" {
+		to, err = parseWhen(parts[1], now, true)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid window %q, %s is not before %s", s, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	return from, to, nil
+}
+
+const (
+	changeFixed      = "fixed"
+	changeSlower     = "slower"
+	changeNewlyFlaky = "newly-flaky"
+)
+
+// TestComparison is a test whose results changed between the "before" and
+// the "after" builds. A test that both got newly flaky and slower appears
+// twice, once per change.
+type TestComparison struct {
+	Name string `json:"name"`
+
+	// Change is either "fixed", "slower", or "newly-flaky".
+	Change string `json:"change"`
+
+	// Number of runs (passed or failed) and of failures in each set of builds.
+	BeforeRuns     int `json:"beforeRuns"`
+	BeforeFailures int `json:"beforeFailures"`
+	AfterRuns      int `json:"afterRuns"`
+	AfterFailures  int `json:"afterFailures"`
+
+	// Median durations in seconds of the "passed" runs in each set of builds,
+	// and AfterMedian divided by BeforeMedian. Only set for "slower".
+	BeforeMedian float64 `json:"beforeMedian,omitempty"`
+	AfterMedian  float64 `json:"afterMedian,omitempty"`
+	Factor       float64 `json:"factor,omitempty"`
+}
+
+// compareTests returns the tests that got fixed, slower, or newly flaky
+// between the "before" and the "after" results. A test is newly flaky when it
+// ran at least minRuns times without failing before and failed after, and
+// fixed when it failed before and ran at least minRuns times without failing
+// after. A test got slower when the median duration of its "after" passed
+// runs is at least "factor" times the median of its "before" passed runs,
+// both medians being computed from at least minRuns runs. The fixed tests come
+// first, then the slower ones by factor, then the newly flaky ones by number
+// of failures, so that the worst are shown last.
+func compareTests(before, after []GinkgoResult, factor float64, minRuns int) []TestComparison {
+	type counts struct {
+		runs, failures int
+		passed         []time.Duration
+	}
+	type sides struct {
+		before, after counts
+	}
+	byName := make(map[string]*sides)
+	var names []string
+	count := func(results []GinkgoResult, get func(*sides) *counts) {
+		for _, res := range results {
+			if res.Status != statusPassed && !res.Status.isFailed() {
+				continue
+			}
+			s, ok := byName[res.Name]
+			if !ok {
+				s = &sides{}
+				byName[res.Name] = s
+				names = append(names, res.Name)
+			}
+			c := get(s)
+			c.runs++
+			if res.Status.isFailed() {
+				c.failures++
+			} else {
+				c.passed = append(c.passed, res.Duration)
+			}
+		}
+	}
+	count(before, func(s *sides) *counts { return &s.before })
+	count(after, func(s *sides) *counts { return &s.after })
+	sort.Strings(names)
+
+	var comparisons []TestComparison
+	for _, name := range names {
+		s := byName[name]
+		c := TestComparison{
+			Name:           name,
+			BeforeRuns:     s.before.runs,
+			BeforeFailures: s.before.failures,
+			AfterRuns:      s.after.runs,
+			AfterFailures:  s.after.failures,
+		}
+		switch {
+		case s.before.failures == 0 && s.after.failures > 0 && s.before.runs >= minRuns:
+			c.Change = changeNewlyFlaky
+			comparisons = append(comparisons, c)
+		case s.before.failures > 0 && s.after.failures == 0 && s.after.runs >= minRuns:
+			c.Change = changeFixed
+			comparisons = append(comparisons, c)
+		}
+
+		if len(s.before.passed) == 0 || len(s.after.passed) == 0 || len(s.before.passed) < minRuns || len(s.after.passed) < minRuns {
+			continue
+		}
+		beforeMedian, afterMedian := medianDuration(s.before.passed), medianDuration(s.after.passed)
+		if beforeMedian == 0 || float64(afterMedian) < factor*float64(beforeMedian) {
+			continue
+		}
+		c.Change = changeSlower
+		c.BeforeMedian = beforeMedian.Seconds()
+		c.AfterMedian = afterMedian.Seconds()
+		c.Factor = float64(afterMedian) / float64(beforeMedian)
+		comparisons = append(comparisons, c)
+	}
+
+	rank := map[string]int{changeFixed: 0, changeSlower: 1, changeNewlyFlaky: 2}
+	sort.SliceStable(comparisons, func(i, j int) bool {
+		a, b := comparisons[i], comparisons[j]
+		if a.Change != b.Change {
+			return rank[a.Change] < rank[b.Change]
+		}
+		switch a.Change {
+		case changeSlower:
+			return a.Factor < b.Factor
+		case changeNewlyFlaky:
+			return a.AfterFailures < b.AfterFailures
+		}
+		return false
+	})
+	return comparisons
+}
+
+// median returns the median of the given values. With an even number of
+// values, the lower of the two middle values is returned so that the median
+// is one of the values. The given slice is sorted in place.
+func median(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Ints(values)
+	return values[(len(values)-1)/2]
+}
+
+// medianDuration is the same as median, for durations.
+func medianDuration(values []time.Duration) time.Duration {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values[(len(values)-1)/2]
+}
+
+// SeriesPoint is one run of a test, as exported by "prowdig export series".
+type SeriesPoint struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	Status    status    `json:"status"`
+	Duration  float64   `json:"duration"` // in seconds
+	Build     int       `json:"build"`
+	Job       string    `json:"job"`
+}
+
+// toSeries returns the points of the test with the given name, or of all the
+// tests when name is empty. The points are sorted by timestamp, then by name.
+func toSeries(results []GinkgoResult, name string) []SeriesPoint {
+	points := []SeriesPoint{}
+	for _, res := range results {
+		if name != "" && res.Name != name {
+			continue
+		}
+		points = append(points, SeriesPoint{
+			Name:      res.Name,
+			Timestamp: res.Started,
+			Status:    res.Status,
+			Duration:  res.Duration.Seconds(),
+			Build:     res.Build,
+			Job:       res.Job,
+		})
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if !points[i].Timestamp.Equal(points[j].Timestamp) {
+			return points[i].Timestamp.Before(points[j].Timestamp)
+		}
+		return points[i].Name < points[j].Name
+	})
+	return points
+}
+
+// Snapshot is what "prowdig snapshot" writes to disk.
+type Snapshot struct {
+	Taken   time.Time      `json:"taken"`
+	Summary StatsSummary   `json:"summary"`
+	Tests   []SnapshotTest `json:"tests"`
+}
+
+type SnapshotTest struct {
+	Name        string  `json:"name"`
+	CountPassed int     `json:"countPassed"`
+	CountFailed int     `json:"countFailed"`
+	FailureRate float64 `json:"failureRate"`
+}
+
+// takeSnapshot computes the stats that are stored in a snapshot. Only the
+// "passed" and "failed" results count towards the failure rate of each test.
+// The tests are sorted by name.
+func takeSnapshot(results []GinkgoResult, now time.Time) Snapshot {
+	byName := make(map[string]*SnapshotTest)
+	for _, res := range results {
+		test, ok := byName[res.Name]
+		if !ok {
+			test = &SnapshotTest{Name: res.Name}
+			byName[res.Name] = test
+		}
+		switch res.Status {
+		case statusPassed:
+			test.CountPassed++
+		case statusFailed, statusTimedOut, statusPanicked:
+			test.CountFailed++
+		}
+	}
+
+	snapshot := Snapshot{Taken: now.UTC(), Summary: computeStatsSummary(results), Tests: []SnapshotTest{}}
+	for _, test := range byName {
+		if test.CountPassed+test.CountFailed > 0 {
+			test.FailureRate = float64(test.CountFailed) / float64(test.CountPassed+test.CountFailed)
+		}
+		snapshot.Tests = append(snapshot.Tests, *test)
+	}
+	sort.Slice(snapshot.Tests, func(i, j int) bool {
+		return snapshot.Tests[i].Name < snapshot.Tests[j].Name
+	})
+	return snapshot
+}
+
+// writeSnapshot writes the snapshot atomically in the given directory and
+// returns the path of the file written. The file is named after the time at
+// which the snapshot was taken so that the snapshots sort chronologically.
+func writeSnapshot(dir string, snapshot Snapshot) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
 	}
 
-	bytes, err := ioutil.ReadAll(resp.Body)
+	path := filepath.Join(dir, snapshot.Taken.Format("2006-01-02T150405Z")+".json")
+	f, err := createAtomic(path)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return "", err
 	}
-
-	// The response from prow.k8s.io is a JavaScript blob that can be
-	// interpreted. We need to turn it into JSON. The blob looks like this:
-	//
-	//   var allBuilds = {"items":[{"kind":"ProwJob", ...}]};
-	//   <------------->                                    ^
-	//    remove this                                    remove this
-	//
-	regex := regexp.MustCompile(`var allBuilds = {"items":(.*)};`)
-	match := regex.FindStringSubmatch(string(bytes))
-	if len(match) != 2 {
-		return fmt.Errorf(`the body was expected to look like '{"items":[{"kind":"ProwJob", ...}]};' but it was '%s'`, string(bytes))
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(snapshot)
+	if err != nil {
+		f.Discard()
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
 	}
+	return path, f.Commit()
+}
 
-	var builds []ProwJob
-	err = json.Unmarshal([]byte(match[1]), &builds)
+func readSnapshot(path string) (Snapshot, error) {
+	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("while unmarshalling the ProwJob JSON object: %w", err)
+		return Snapshot{}, err
 	}
-
-	if len(builds) == 0 {
-		return fmt.Errorf("no ProwJobs found, please check the body returned by ")
+	var snapshot Snapshot
+	err = json.Unmarshal(bytes, &snapshot)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("%s doesn't look like a snapshot written by 'prowdig snapshot': %w", path, err)
 	}
+	return snapshot, nil
+}
 
-	buildIDPrefix := builds[len(builds)-1].Status.BuildID[:3]
+// SnapshotChange is a test for which the failure rate differs between two
+// snapshots. A test that is missing from one of the snapshots is considered
+// to have a failure rate of 0 in that snapshot.
+type SnapshotChange struct {
+	Name           string  `json:"name"`
+	OldFailureRate float64 `json:"oldFailureRate"`
+	NewFailureRate float64 `json:"newFailureRate"`
+}
 
-	gcs, err := storage.NewClient(context.Background())
-	if err != nil {
-		return fmt.Errorf("error: Google Cloud storage: %v\n", err)
+// diffSnapshots returns the tests for which the failure rate changed, sorted
+// by the change of failure rate in ascending order: the tests that got better
+// come first and the ones that got worse come last.
+func diffSnapshots(old, new Snapshot) []SnapshotChange {
+	rates := make(map[string]*SnapshotChange)
+	var names []string
+	get := func(name string) *SnapshotChange {
+		change, ok := rates[name]
+		if !ok {
+			change = &SnapshotChange{Name: name}
+			rates[name] = change
+			names = append(names, name)
+		}
+		return change
 	}
-	bucket := gcs.Bucket(bucketName)
+	for _, test := range old.Tests {
+		get(test.Name).OldFailureRate = test.FailureRate
+	}
+	for _, test := range new.Tests {
+		get(test.Name).NewFailureRate = test.FailureRate
+	}
+	sort.Strings(names)
 
-	// Now, let's list the files under each CI prefix.
-	var objects []storage.ObjectAttrs
-	totalSize := int64(0)
+	var changes []SnapshotChange
+	for _, name := range names {
+		if rates[name].OldFailureRate == rates[name].NewFailureRate {
+			continue
+		}
+		changes = append(changes, *rates[name])
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].NewFailureRate-changes[i].OldFailureRate < changes[j].NewFailureRate-changes[j].OldFailureRate
+	})
+	return changes
+}
 
-	bar2 := pb.NewOptions(limit,
-		pb.OptionSetWriter(os.Stderr),
-		pb.OptionSetPredictTime(false),
-		pb.OptionEnableColorCodes(true),
-		pb.OptionShowBytes(false),
-		pb.OptionSetDescription(fmt.Sprintf("Finding the last %d jobs...", limit)),
-		pb.OptionSetTheme(theme),
-	)
-	_ = bar2.RenderBlank()
-	countJobs := 0 // One prowjob.json = one build.
-	for _, prefix := range ciBucketPrefixes {
-		objectIter := bucket.Objects(context.Background(), &storage.Query{
-			Prefix: prefix + "/" + buildIDPrefix, Projection: storage.ProjectionNoACL,
-		})
+// MassFailureBuild is a build in which an unusually large fraction of the tests
+// failed.
+type MassFailureBuild struct {
+	Job     string    `json:"job"`
+	PR      int       `json:"pr"`
+	Build   int       `json:"build"`
+	Started time.Time `json:"started"`
+
+	// Number of test results in this build, and how many of them have the
+	// status "failed" or "error".
+	Runs   int `json:"runs"`
+	Failed int `json:"failed"`
+
+	// Failed divided by Runs.
+	FailureRate float64 `json:"failureRate"`
+
+	// The most common error message of the most common error cluster of
+	// the build, along with the number of failures in that cluster.
+	TopError      string `json:"topError"`
+	TopErrorCount int    `json:"topErrorCount"`
+}
 
-		for countJobs < limit {
-			object, err := objectIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("failed to iterate over GCS objects: %s: %w", object.Name, err)
+// tagMassFailures sets MassFailure on the results that belong to builds in
+// which the fraction of "failed" and "error" results is greater or equal to
+// the threshold. The mass-failure builds are returned sorted by failure rate
+// in descending order.
+func tagMassFailures(results []GinkgoResult, threshold float64) []MassFailureBuild {
+	type build struct {
+		job   string
+		build int
+	}
+	byBuild := make(map[build][]int)
+	var order []build
+	for i, res := range results {
+		b := build{job: res.Job, build: res.Build}
+		if _, ok := byBuild[b]; !ok {
+			order = append(order, b)
+		}
+		byBuild[b] = append(byBuild[b], i)
+	}
+
+	var massFailures []MassFailureBuild
+	for _, b := range order {
+		var failed []GinkgoResult
+		for _, i := range byBuild[b] {
+			if results[i].Status != statusPassed {
+				failed = append(failed, results[i])
 			}
+		}
+		rate := float64(len(failed)) / float64(len(byBuild[b]))
+		if len(failed) == 0 || rate < threshold {
+			continue
+		}
 
-			if strings.HasSuffix(object.Name, "prowjob.json") {
-				countJobs++
-				_ = bar2.Add(1)
+		for _, i := range byBuild[b] {
+			results[i].MassFailure = true
+		}
+
+		first := results[byBuild[b][0]]
+		massFailure := MassFailureBuild{
+			Job:         b.job,
+			PR:          first.PR,
+			Build:       b.build,
+			Started:     first.Started,
+			Runs:        len(byBuild[b]),
+			Failed:      len(failed),
+			FailureRate: rate,
+		}
+		clusters := clusterErrors(failed, 0.8)
+		if len(clusters) > 0 {
+			massFailure.TopError = clusters[0].Err
+			massFailure.TopErrorCount = clusters[0].Count
+		}
+		massFailures = append(massFailures, massFailure)
+	}
+
+	sort.SliceStable(massFailures, func(i, j int) bool {
+		a, b := massFailures[i], massFailures[j]
+		if a.FailureRate != b.FailureRate {
+			return a.FailureRate > b.FailureRate
+		}
+		if a.Job != b.Job {
+			return a.Job < b.Job
+		}
+		return a.Build < b.Build
+	})
+	return massFailures
+}
+
+// CoFailure is a pair of tests that failed in the same builds.
+type CoFailure struct {
+	TestA string `json:"testA"`
+	TestB string `json:"testB"`
+
+	// Number of builds in which TestA failed, in which TestB failed, and in
+	// which both failed.
+	CountA   int `json:"countA"`
+	CountB   int `json:"countB"`
+	Together int `json:"together"`
+
+	// How many times more often the two tests failed together than if they
+	// were failing independently of each other. A lift of 1 means that the
+	// two failures are unrelated.
+	Lift float64 `json:"lift"`
+}
+
+// writeCoFailuresDot writes the pairs as a Graphviz graph in which the tests
+// are the nodes and the pairs are the edges. The more builds in which the two
+// tests failed together, the thicker the edge. It looks like this:
+//
+//	graph "co-failures" {
+//	  "foo" -- "bar" [label="3 (×2.5)", penwidth=3];
+//	}
+func writeCoFailuresDot(out io.Writer, pairs []CoFailure) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "graph \"co-failures\" {\n")
+	fmt.Fprintf(w, "  node [shape=box];\n")
+	for _, pair := range pairs {
+		fmt.Fprintf(w, "  %s -- %s [label=%s, penwidth=%d];\n", dotQuote(pair.TestA), dotQuote(pair.TestB), dotQuote(fmt.Sprintf("%d (×%.1f)", pair.Together, pair.Lift)), pair.Together)
+	}
+	fmt.Fprintf(w, "}\n")
+	return w.Flush()
+}
+
+// computeCoFailures looks at the builds in which two tests both failed. A
+// build is identified by its job name and build number. Only the pairs that
+// failed together at least minTogether times and with a lift of at least
+// minLift are returned, sorted by the number of builds in which both tests
+// failed, and then by lift.
+func computeCoFailures(results []GinkgoResult, minTogether int, minLift float64) []CoFailure {
+	type build struct {
+		job   string
+		build int
+	}
+	builds := make(map[build]struct{})
+	failedIn := make(map[string]map[build]struct{})
+	for _, res := range results {
+		b := build{job: res.Job, build: res.Build}
+		builds[b] = struct{}{}
+		if !res.Status.isFailed() {
+			continue
+		}
+		if failedIn[res.Name] == nil {
+			failedIn[res.Name] = make(map[build]struct{})
+		}
+		failedIn[res.Name][b] = struct{}{}
+	}
+
+	var names []string
+	for name := range failedIn {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	n := float64(len(builds))
+	var pairs []CoFailure
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			a, b := failedIn[names[i]], failedIn[names[j]]
+			together := 0
+			for build := range a {
+				if _, ok := b[build]; ok {
+					together++
+				}
+			}
+			if together == 0 || together < minTogether {
+				continue
 			}
 
-			if filter != nil && !filter.MatchString(object.Name) {
+			// The lift is P(A and B) / (P(A) * P(B)), which simplifies to
+			// the expression below.
+			lift := float64(together) * n / float64(len(a)*len(b))
+			if lift < minLift {
 				continue
 			}
+			pairs = append(pairs, CoFailure{
+				TestA:    names[i],
+				TestB:    names[j],
+				CountA:   len(a),
+				CountB:   len(b),
+				Together: together,
+				Lift:     lift,
+			})
+		}
+	}
 
-			totalSize += object.Size
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Together != pairs[j].Together {
+			return pairs[i].Together > pairs[j].Together
+		}
+		return pairs[i].Lift > pairs[j].Lift
+	})
+	return pairs
+}
+
+type StatsSummary struct {
+	// Number of distinct builds in which at least one test result was found.
+	Builds int `json:"builds"`
+
+	// Number of test results, all statuses included.
+	Runs int `json:"runs"`
+
+	CountPassed int `json:"countPassed"`
+	CountFailed int `json:"countFailed"`
+	CountError  int `json:"countError"`
+
+	// The failures that hit their timeout or panicked. They are included
+	// in CountFailed.
+	CountTimedOut int `json:"countTimedOut"`
+	CountPanicked int `json:"countPanicked"`
+
+	// The ratio of "failed" and "error" results over all the results, between
+	// 0 and 1.
+	FailureRate float64 `json:"failureRate"`
+
+	// Number of distinct test names that have at least one "failed" result.
+	FailingTests int `json:"failingTests"`
 
-			// Why "*object"? No one else is going to touch the
-			// *storage.ObjectAttrs pointer, so it makes sense to do a shallow
-			// copy here since all the "shared" fields like object.Metadata
-			// won't be used by anyone else.
-			objects = append(objects, *object)
+	// The most common error message amongst the "failed" results, along with
+	// the number of times it was seen. Only the first line of each error
+	// message is considered.
+	TopError      string `json:"topError"`
+	TopErrorCount int    `json:"topErrorCount"`
+}
+
+// printStatsSummary shows the summary as a two-column table. The topErrSource
+// is the URL appended to the most common error with --links, and can be left
+// empty.
+func printStatsSummary(out io.Writer, summary StatsSummary, topErrSource string) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Builds analyzed:\t%d\n", summary.Builds)
+	fmt.Fprintf(w, "Test runs:\t%d\n", summary.Runs)
+	fmt.Fprintf(w, "Passed:\t%s\n", green(summary.CountPassed))
+	fmt.Fprintf(w, "Failed:\t%s (%d timed out, %d panicked)\n", red(summary.CountFailed), summary.CountTimedOut, summary.CountPanicked)
+	fmt.Fprintf(w, "Errored:\t%s\n", blue(summary.CountError))
+	fmt.Fprintf(w, "Failure rate:\t%.1f%%\n", 100*summary.FailureRate)
+	fmt.Fprintf(w, "Distinct failing tests:\t%d\n", summary.FailingTests)
+	if summary.TopErrorCount > 0 {
+		fmt.Fprintf(w, "Most common error (%d×):\t%s%s\n", summary.TopErrorCount, gray(fitErr(summary.TopError, "\t")), link(topErrSource))
+	}
+	return w.Flush()
+}
+
+// BuildAnalysis is what 'prowdig builds analyze' shows about a single build.
+type BuildAnalysis struct {
+	Job   string `json:"job"`
+	PR    int    `json:"pr"`
+	Build int    `json:"build"`
+
+	// (optional) The status, duration, and URL of the build as found in its
+	// prowjob.json. Nil when the prowjob.json is missing or when the build
+	// is still running.
+	Result *BuildResult `json:"result,omitempty"`
+
+	// The counts of test results. Summary.Builds is 0 when no test result
+	// was found.
+	Summary StatsSummary `json:"summary"`
+
+	// The "failed" and "error" results, in the order of 'tests list'.
+	Failures []GinkgoResult `json:"failures"`
 
+	// The failures grouped by normalized error message, see computeTriage.
+	Triage []TriageBucket `json:"triage"`
+}
+
+// analyzeBuild puts together what is known about the given build directory
+// from the builds and the test results parsed from its artifacts.
+func analyzeBuild(dir string, builds []BuildResult, results []GinkgoResult) BuildAnalysis {
+	pr, job, build, _ := parseObjectName(dir)
+	analysis := BuildAnalysis{
+		Job:      job,
+		PR:       pr,
+		Build:    build,
+		Summary:  computeStatsSummary(results),
+		Failures: []GinkgoResult{},
+		Triage:   computeTriage(results, 0),
+	}
+	for i := range builds {
+		if builds[i].Build == build {
+			analysis.Result = &builds[i]
 		}
-		if countJobs >= limit {
-			break
+	}
+	for _, res := range results {
+		if res.Status.isFailed() || res.Status == statusError {
+			analysis.Failures = append(analysis.Failures, res)
 		}
 	}
-	_ = bar2.Finish()
-	_ = bar2.Clear()
+	sort.SliceStable(analysis.Failures, func(i, j int) bool {
+		return lessResult(analysis.Failures[i], analysis.Failures[j])
+	})
+	if analysis.Triage == nil {
+		analysis.Triage = []TriageBucket{}
+	}
+	return analysis
+}
 
-	bar3 := pb.NewOptions64(totalSize,
-		pb.OptionSetWriter(os.Stderr),
-		pb.OptionSetPredictTime(true),
-		pb.OptionShowCount(),
-		pb.OptionEnableColorCodes(true),
-		pb.OptionShowBytes(true),
-		pb.OptionSetDescription("Downloading logs for each job..."),
-		pb.OptionSetTheme(theme),
-	)
-	_ = bar3.RenderBlank()
-	for _, object := range objects {
-		if CLI.Debug {
-			fmt.Fprintf(os.Stderr, "downloading %s\n", object.Name)
+// printBuildAnalysis shows the analysis of a build. It looks like this:
+//
+//	Job:     ci-cert-manager-e2e-v1-24
+//	Build:   1542977259508338688
+//	Status:  failure after 1h2m0s: Job failed.
+//	URL:     https://prow.build-infra.jetstack.net/view/gs/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688
+//	Tests:   212 passed, 3 failed (1 timed out, 0 panicked), 0 errored
+//
+//	✗ 5m0s  [cert-manager] Vault Issuer should be ready with a valid AppRole: timed out waiting for the condition
+//	...
+//
+//	2 failures in 2 tests: timed out waiting for the condition
+//	  1 [cert-manager] Vault Issuer should be ready with a valid AppRole
+//	  ...
+func printBuildAnalysis(out io.Writer, analysis BuildAnalysis) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Job:\t%s\n", analysis.Job)
+	if analysis.PR != 0 {
+		fmt.Fprintf(w, "PR:\t#%d\n", analysis.PR)
+	}
+	fmt.Fprintf(w, "Build:\t%d\n", analysis.Build)
+	if res := analysis.Result; res != nil {
+		duration := formatDuration(time.Duration(res.Duration) * time.Second)
+		switch res.Status {
+		case BuildSuccess:
+			fmt.Fprintf(w, "Status:\t%s after %s\n", green(res.Status), duration)
+		default:
+			fmt.Fprintf(w, "Status:\t%s after %s: %s\n", red(res.Status), duration, gray(res.Err))
+		}
+		fmt.Fprintf(w, "URL:\t%s\n", blue(res.URL))
+	}
+	summary := analysis.Summary
+	fmt.Fprintf(w, "Tests:\t%s passed, %s failed (%d timed out, %d panicked), %s errored\n",
+		green(summary.CountPassed), red(summary.CountFailed), summary.CountTimedOut, summary.CountPanicked, blue(summary.CountError))
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(analysis.Failures) > 0 {
+		fmt.Fprintln(out)
+		w = tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+		for _, res := range analysis.Failures {
+			color := red
+			if res.Status == statusError {
+				color = blue
+			}
+			fmt.Fprintf(w, "%s %s\t%s: %s%s\n", icon(res.Status), color(formatDuration(res.Duration)), res.Name, gray(fitErr(res.Err, "\t")), link(res.Source))
 		}
-		err := downloadToCache(&object, bucket)
+		err = w.Flush()
 		if err != nil {
-			return fmt.Errorf("failed to download jobs artifacts for %s: %w", object.Name, err)
+			return err
 		}
-		_ = bar3.Add64(object.Size)
 	}
-	_ = bar3.Finish()
-	_ = bar3.Clear()
 
+	if len(analysis.Triage) > 0 {
+		fmt.Fprintln(out)
+		return printTriage(out, analysis.Triage)
+	}
 	return nil
 }
 
-// The "bucket" string in input is used for displaying and logging. It is not
-// used to fetch anything from GCS.
-func parseGinkgoResultsFromCache(bucketPrefixes []string, countBuilds int) ([]GinkgoResult, error) {
-	// Let's only select the last few PRs.
-	artifacts, err := findCachedArtifacts(bucketPrefixes, countBuilds)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find cached artifacts: %v", err)
-	}
+// ProfileReport is the section of 'prowdig report' about one profile.
+type ProfileReport struct {
+	Profile string `json:"profile"`
 
-	bar := pb.NewOptions(len(artifacts),
-		pb.OptionSetWriter(os.Stderr),
-		pb.OptionSetPredictTime(true),
-		pb.OptionShowCount(),
-		pb.OptionEnableColorCodes(true),
-		pb.OptionShowBytes(false),
-		pb.OptionSetDescription("Parsing logs..."),
-		pb.OptionSetTheme(theme),
-	)
-	defer func() {
-		_ = bar.Finish()
-		_ = bar.Clear()
-	}()
+	// Either the summary or the error is set.
+	Summary *StatsSummary `json:"summary,omitempty"`
+	Err     string        `json:"err,omitempty"`
+}
 
-	var ginkgoResults []GinkgoResult
-	for _, artifact := range artifacts {
-		bar.Add(1)
+// reportProfiles runs 'self [--profile=<name>] <args>' for each of the given
+// profiles concurrently and decodes the JSON summary that each process
+// prints. The args are expected to end with 'tests summary --output=json'.
+// The reports are in the same order as the profiles.
+func reportProfiles(self string, config Config, profiles []string, args []string) []ProfileReport {
+	// The prowdig processes read the PROWDIG_* environment variables too,
+	// which would override the profile, or make each process write to the
+	// --output-file.
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "PROWDIG_") {
+			env = append(env, kv)
+		}
+	}
 
-		if !isJunitFile.MatchString(artifact) && !isBuildLogFile.MatchString(artifact) {
-			continue
+	reports := make([]ProfileReport, len(profiles))
+	_ = forEachConcurrently(len(profiles), func(i int) error {
+		reports[i].Profile = profiles[i]
+
+		// Without --profile, the built-in settings and cache directory are
+		// used, unless the config file overrides the built-in profile.
+		profileArgs := args
+		if _, inConfig := config.Profiles[profiles[i]]; profiles[i] != defaultProfile || inConfig {
+			profileArgs = append([]string{"--profile=" + profiles[i]}, args...)
 		}
 
-		bytes, err := loadFromCache(artifact)
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(self, profileArgs...)
+		cmd.Env = env
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
 		if err != nil {
-			return nil, fmt.Errorf("failed to load from file %s, was expected to be already in cache: %w", artifact, err)
+			reports[i].Err = strings.TrimSpace(stderr.String())
+			if reports[i].Err == "" {
+				reports[i].Err = err.Error()
+			}
+			return nil
 		}
 
-		// The url below is meant for the 'source' field as well as for logging
-		// purposes.
-		// https://storage.googleapis.com/jetstack-logs/<object-name>
-		objectName := strings.TrimPrefix(artifact, cacheDir+"/")
-		url := "https://storage.googleapis.com/" + bucketName + "/" + objectName
-		pr, job, build, err := parseObjectName(objectName)
+		var summary StatsSummary
+		err = json.Unmarshal(stdout.Bytes(), &summary)
 		if err != nil {
-			return nil, fmt.Errorf("parsing object name %s: %w", objectName, err)
+			reports[i].Err = fmt.Sprintf("while decoding the summary: %v", err)
+			return nil
+		}
+		reports[i].Summary = &summary
+		return nil
+	})
+	return reports
+}
+
+func computeStatsSummary(results []GinkgoResult) StatsSummary {
+	type build struct {
+		job   string
+		build int
+	}
+	builds := make(map[build]struct{})
+	failingTests := make(map[string]struct{})
+	errCount := make(map[string]int)
+
+	var summary StatsSummary
+	for _, res := range results {
+		builds[build{job: res.Job, build: res.Build}] = struct{}{}
+		summary.Runs++
+
+		switch res.Status {
+		case statusTimedOut:
+			summary.CountTimedOut++
+		case statusPanicked:
+			summary.CountPanicked++
 		}
 
+		switch res.Status {
+		case statusPassed:
+			summary.CountPassed++
+		case statusFailed, statusTimedOut, statusPanicked:
+			summary.CountFailed++
+			failingTests[res.Name] = struct{}{}
+			if res.Err != "" {
+				errCount[strings.SplitN(res.Err, "\n", 2)[0]]++
+			}
+		case statusError:
+			summary.CountError++
+		}
+	}
+
+	summary.Builds = len(builds)
+	summary.FailingTests = len(failingTests)
+	if summary.Runs > 0 {
+		summary.FailureRate = float64(summary.CountFailed+summary.CountError) / float64(summary.Runs)
+	}
+
+	for errStr, count := range errCount {
+		// Ties are broken using the alphabetical order so that the output
+		// doesn't change from one run to the next.
+		if count > summary.TopErrorCount || (count == summary.TopErrorCount && errStr < summary.TopError) {
+			summary.TopError = errStr
+			summary.TopErrorCount = count
+		}
+	}
+
+	return summary
+}
+
+// icon returns the emoji shown in front of a test result. When stdout isn't a
+// terminal or with --plain, a word is shown instead so that the output only
+// contains ASCII characters.
+func icon(s status) string {
+	switch {
+	case s == statusPassed && asciiIcons:
+		return "PASS"
+	case s == statusFailed && asciiIcons:
+		return "FAIL"
+	case s == statusError && asciiIcons:
+		return "ERROR"
+	case s == statusTimedOut && asciiIcons:
+		return "TIMEOUT"
+	case s == statusPanicked && asciiIcons:
+		return "PANIC"
+	case s == statusPassed:
+		return "✅"
+	case s == statusFailed:
+		return "❌"
+	case s == statusError:
+		return "💣️"
+	case s == statusTimedOut:
+		return "⏰"
+	case s == statusPanicked:
+		return "💥"
+	default:
+		panic("developer mistake: unknown status: " + string(s))
+	}
+}
+
+// wideColumns returns the job, PR, build, and "when" columns shown with
+// --wide, or an empty string when --wide isn't given. A dash is shown for the
+// values that are unknown, e.g. the PR number of periodic jobs.
+func wideColumns(res GinkgoResult) string {
+	if !CLI.Tests.Wide {
+		return ""
+	}
+	job, pr, build := "-", "-", "-"
+	if res.Job != "" {
+		job = res.Job
+	}
+	if res.PR != 0 {
+		pr = strconv.Itoa(res.PR)
+	}
+	if res.Build != 0 {
+		build = strconv.Itoa(res.Build)
+	}
+	return job + "\t" + pr + "\t" + build + "\t" + formatTime(res.Started) + "\t"
+}
+
+// link returns the URL to be appended to a row of the text output when
+// --links is given, or an empty string otherwise.
+func link(url string) string {
+	if !CLI.Links || url == "" {
+		return ""
+	}
+	return " " + blue(url)
+}
+
+// testSources returns, for each test name, the source of its most recent
+// failure, or of its most recent run if it never failed. Used to give a link
+// to the rows that aggregate the runs of a test.
+func testSources(results []GinkgoResult) map[string]string {
+	isFailure := func(res GinkgoResult) bool {
+		return res.Status.isFailed() || res.Status == statusError
+	}
+	latest := make(map[string]GinkgoResult)
+	for _, res := range results {
+		cur, ok := latest[res.Name]
 		switch {
-		case isJunitFile.MatchString(artifact):
-			parsedBlocks, err := parseJunit(bytes)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse junit file %s: %w", url, err)
+		case !ok:
+		case isFailure(res) != isFailure(cur):
+			if !isFailure(res) {
+				continue
 			}
+		case res.Build <= cur.Build:
+			continue
+		}
+		latest[res.Name] = res
+	}
+
+	sources := make(map[string]string)
+	for name, res := range latest {
+		sources[name] = res.Source
+	}
+	return sources
+}
+
+// errSources returns, for each error message, the source of the most recent
+// failure with this exact message.
+func errSources(results []GinkgoResult) map[string]string {
+	latest := make(map[string]GinkgoResult)
+	for _, res := range results {
+		if !res.Status.isFailed() && res.Status != statusError {
+			continue
+		}
+		if cur, ok := latest[res.Err]; ok && res.Build <= cur.Build {
+			continue
+		}
+		latest[res.Err] = res
+	}
+
+	sources := make(map[string]string)
+	for err, res := range latest {
+		sources[err] = res.Source
+	}
+	return sources
+}
+
+// spyglassURL turns the storage.googleapis.com URL of an artifact into the URL
+// of the page of its build in the Prow UI, e.g.:
+//
+//	https://storage.googleapis.com/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688/build-log.txt#line=42
+//	https://prow.build-infra.jetstack.net/view/gs/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688
+//
+// When the artifacts are stored in S3, the page is under /view/s3/ instead. The
+// URL is returned as-is when it can't be turned into a Spyglass URL, e.g. when
+// no Deck URL is configured.
+func spyglassURL(source string) string {
+	prefix := "https://storage.googleapis.com/" + bucketName + "/"
+	if deckURL == "" || !strings.HasPrefix(source, prefix) {
+		return source
+	}
+	objectName := strings.TrimPrefix(source, prefix)
+	if i := strings.Index(objectName, "#"); i != -1 {
+		objectName = objectName[:i]
+	}
+	dir, ok := buildDir(objectName)
+	if !ok {
+		return source
+	}
+	// The HTTP artifact servers, such as gcsweb, front GCS buckets.
+	scheme := storageScheme
+	if scheme == "http" || scheme == "https" {
+		scheme = "gs"
+	}
+	return deckURL + "/view/" + scheme + "/" + bucketName + "/" + dir
+}
+
+// parseArtifactURL parses the links to the artifacts that people paste, i.e.
+// the Spyglass page of a build in the Prow UI, a gcsweb page, or a
+// storage.googleapis.com URL, e.g.:
+//
+//	https://prow.build-infra.jetstack.net/view/gs/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688
+//	https://gcsweb.infra.cert-manager.io/gcs/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688/build-log.txt
+//	https://storage.googleapis.com/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688/build-log.txt
+//
+// The scheme is "gs" or "s3". The object name has no trailing slash; it is a
+// build directory in the case of the Spyglass pages. The last return value is
+// false when the URL isn't one of these.
+func parseArtifactURL(s string) (scheme, bucket, objectName string, ok bool) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", "", false
+	}
+	p := strings.Trim(u.Path, "/")
+	switch {
+	case strings.HasPrefix(p, "view/gs/"):
+		scheme, p = "gs", strings.TrimPrefix(p, "view/gs/")
+	case strings.HasPrefix(p, "view/gcs/"):
+		scheme, p = "gs", strings.TrimPrefix(p, "view/gcs/")
+	case strings.HasPrefix(p, "view/s3/"):
+		scheme, p = "s3", strings.TrimPrefix(p, "view/s3/")
+	case strings.HasPrefix(p, "gcs/"):
+		scheme, p = "gs", strings.TrimPrefix(p, "gcs/")
+	case u.Host == "storage.googleapis.com":
+		scheme = "gs"
+	default:
+		return "", "", "", false
+	}
+	parts := strings.SplitN(p, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", false
+	}
+	return scheme, parts[0], parts[1], true
+}
+
+// formatDuration formats the durations shown in the text output according to
+// --duration-format. The durations are rounded to the millisecond.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Millisecond)
+	switch CLI.DurationFormat {
+	case "seconds":
+		return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+	case "ms":
+		return strconv.FormatInt(d.Milliseconds(), 10)
+	default:
+		return d.String()
+	}
+}
+
+// formatTime formats the timestamps shown in the text output. The timestamps
+// are shown relative to now unless --absolute-time is given. A dash is shown
+// when the timestamp is unknown.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if CLI.AbsoluteTime {
+		return t.In(timezone).Format(time.RFC3339)
+	}
+	return relativeTime(t, time.Now())
+}
+
+// relativeTime returns a short and human-readable version of the time elapsed
+// between t and now, e.g. "5m ago" or "2d ago", similarly to the AGE column
+// of kubectl.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < 0:
+		return "in the future"
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	case d < 365*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	default:
+		return fmt.Sprintf("%dy ago", int(d/(365*24*time.Hour)))
+	}
+}
+
+// Prow build numbers are snowflake IDs: the 41 upper bits are the number of
+// milliseconds since the Twitter epoch (2010-11-04T01:42:54.657Z). For
+// example, the build 1542977259508338688 started on 2022-07-01T21:03:40Z.
+const snowflakeEpoch = 1288834974657
+
+// buildStarted returns the time at which the given build started using the
+// build number. The builds created before Prow switched to snowflake IDs
+// have small build numbers, in which case a zero time is returned.
+func buildStarted(build int) time.Time {
+	if build < 1<<40 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(build>>22) + snowflakeEpoch).UTC()
+}
+
+// fitErr applies --error-width and --wrap to the given error message. The
+// continuation lines are prefixed with the given string, which is meant to be
+// made of tabs so that the tabwriter aligns the continuation lines with the
+// column in which the error message is shown. The tabwriter must not be
+// created with tabwriter.TabIndent: with a tab width of 0, it would emit
+// nothing for the leading empty cells and the continuation lines would start
+// at column 0 instead of being padded with spaces.
+func fitErr(msg, cont string) string {
+	return fitText(msg, CLI.Tests.ErrorWidth, CLI.Tests.Wrap, cont)
+}
+
+// fitText makes the given text fit in the given width, either by truncating it
+// with an ellipsis or by wrapping it onto several lines. The whitespace,
+// including the line breaks, is collapsed first. A width of 0 means that the
+// text is returned as-is.
+func fitText(text string, width int, wrap bool, cont string) string {
+	if width <= 0 {
+		return text
+	}
+	words := strings.Fields(text)
 
-			for _, parsed := range parsedBlocks {
-				ginkgoResults = append(ginkgoResults, GinkgoResult{
-					Name:     parsed.name,
-					Duration: parsed.duration,
-					Status:   parsed.status,
-					Err:      parsed.errStr,
-					ErrLoc:   parsed.errLoc,
-					Source:   url, // No line indication for junit files.
-					PR:       pr,
-					Job:      job,
-					Build:    build,
-				})
-			}
+	if !wrap {
+		runes := []rune(strings.Join(words, " "))
+		if len(runes) <= width {
+			return string(runes)
+		}
+		return strings.TrimRight(string(runes[:width-1]), " ") + "…"
+	}
 
-		case isBuildLogFile.MatchString(artifact):
-			parsedBlocks, err := parseBuildLog(bytes)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse the build-log.txt file %s: %w", url, err)
-			}
+	var lines []string
+	var line []rune
+	for _, word := range words {
+		w := []rune(word)
 
-			results, err := ginkgoBlocksToGinkgoResults(url, job, pr, build, parsedBlocks)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse one of the ginkgo blocks from the build-log.txt file %s: %w", url, err)
+		// Words that are longer than the width are split.
+		for len(w) > width {
+			if len(line) > 0 {
+				lines = append(lines, string(line))
+				line = nil
 			}
+			lines = append(lines, string(w[:width]))
+			w = w[width:]
+		}
 
-			ginkgoResults = append(ginkgoResults, results...)
+		switch {
+		case len(line) == 0:
+			line = w
+		case len(line)+1+len(w) <= width:
+			line = append(append(line, ' '), w...)
 		default:
-			return nil, fmt.Errorf("developer mistake: expected name %s but got %s", isToBeDownloaded.String(), url)
+			lines = append(lines, string(line))
+			line = w
 		}
 	}
-	return ginkgoResults, nil
-}
-
-func ginkgoBlocksToGinkgoResults(url, job string, pr, build int, blocks []ginkgoBlock) ([]GinkgoResult, error) {
-	var results []GinkgoResult
-	for _, block := range blocks {
-		parsed, err := parseGinkgoBlock(block)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse ginkgo block at line %d in %s: %w", block.line, url, err)
-		}
-
-		results = append(results, GinkgoResult{
-			Name:     parsed.name,
-			Duration: parsed.duration,
-			Status:   parsed.status,
-			Err:      parsed.errStr,
-			ErrLoc:   parsed.errLoc,
-			Source:   url + "#line=" + strconv.Itoa(block.line),
-			PR:       pr,
-			Job:      job,
-			Build:    build,
-		})
+	if len(line) > 0 {
+		lines = append(lines, string(line))
 	}
-
-	return results, nil
+	return strings.Join(lines, "\n"+cont)
 }
 
-type BuildStatus string
-
-const (
-	BuildSuccess BuildStatus = "success"
-	BuildFailed  BuildStatus = "failure"
-)
-
-type BuildResult struct {
-	// Can be "success" or "failure". We don't care about "pending" states.
-	Status BuildStatus `json:"status"`
+// ErrorCluster is a group of similar error messages.
+type ErrorCluster struct {
+	// The most common error message of the cluster.
+	Err string `json:"err"`
 
-	// The duration in seconds of this build.
-	Duration int `json:"duration"`
+	// The fingerprint of Err, see fingerprint. Used to look up the cluster
+	// with 'prowdig errors history'.
+	Fingerprint string `json:"fingerprint"`
 
-	// URL to the Prow UI for this build.
-	URL string `json:"url"`
+	// The number of "failed" and "error" results in this cluster.
+	Count int `json:"count"`
 
-	// Name of the job, e.g. "pull-cert-manager-e2e-v1-13"
-	JobName string `json:"jobName"`
+	// The distinct error messages of the cluster, the most common first.
+	Errs []string `json:"errs"`
 
-	// (optional) Show the error message if the build is "failure".
-	Err string `json:"err"`
+	// The names of the tests that failed with one of the error messages of
+	// this cluster, sorted alphabetically.
+	Tests []string `json:"tests"`
 }
 
-// The "bucket" string in input is used for displaying and logging. It is not
-// used to fetch anything from GCS.
-func parseBuildsFromCache(bucketPrefixes []string, limit int) ([]BuildResult, error) {
-	// Let's only select the last few PRs.
-	artifacts, err := findCachedArtifacts(bucketPrefixes, limit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find cached artifacts: %v", err)
+// clusterErrors groups the error messages of the "failed" and "error" results
+// using the similarity between the messages. The identical messages are
+// grouped first, and then each message is added to the first cluster whose
+// most common message is similar enough, starting with the most common
+// messages. The clusters are sorted by count in descending order.
+func clusterErrors(results []GinkgoResult, threshold float64) []ErrorCluster {
+	type group struct {
+		err   string
+		count int
+		tests map[string]struct{}
 	}
-
-	var results []BuildResult
-	for _, artifact := range artifacts {
-		if !strings.HasSuffix(artifact, "prowjob.json") {
+	groupByErr := make(map[string]*group)
+	var groups []*group
+	for _, res := range results {
+		if res.Status == statusPassed || res.Err == "" {
 			continue
 		}
-
-		bytes, err := loadFromCache(artifact)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load from file %s, was expected to be already in cache: %w", artifact, err)
+		g, ok := groupByErr[res.Err]
+		if !ok {
+			g = &group{err: res.Err, tests: make(map[string]struct{})}
+			groupByErr[res.Err] = g
+			groups = append(groups, g)
 		}
-
-		type prowJobV1 struct {
-			Spec struct {
-				Type      string `json:"type"`
-				Agent     string `json:"agent"`
-				Cluster   string `json:"cluster"`
-				Namespace string `json:"namespace"`
-				Job       string `json:"job"`
-				Refs      struct {
-					Org      string `json:"org"`
-					Repo     string `json:"repo"`
-					RepoLink string `json:"repo_link"`
-					BaseRef  string `json:"base_ref"`
-					BaseSha  string `json:"base_sha"`
-					BaseLink string `json:"base_link"`
-					Pulls    []struct {
-						Number     int    `json:"number"`
-						Author     string `json:"author"`
-						Sha        string `json:"sha"`
-						Title      string `json:"title"`
-						Link       string `json:"link"`
-						CommitLink string `json:"commit_link"`
-						AuthorLink string `json:"author_link"`
-					} `json:"pulls"`
-				} `json:"refs"`
-				Report         bool   `json:"report"`
-				Context        string `json:"context"`
-				RerunCommand   string `json:"rerun_command"`
-				MaxConcurrency int    `json:"max_concurrency"`
-				PodSpec        struct {
-					Volumes []struct {
-						Name     string `json:"name"`
-						HostPath struct {
-							Path string `json:"path"`
-							Type string `json:"type"`
-						} `json:"hostPath,omitempty"`
-						Secret struct {
-							SecretName string `json:"secretName"`
-						} `json:"secret,omitempty"`
-						EmptyDir struct {
-						} `json:"emptyDir,omitempty"`
-					} `json:"volumes"`
-					Containers []struct {
-						Name  string   `json:"name"`
-						Image string   `json:"image"`
-						Args  []string `json:"args"`
-						Env   []struct {
-							Name  string `json:"name"`
-							Value string `json:"value"`
-						} `json:"env"`
-						Resources struct {
-							Requests struct {
-								CPU    string `json:"cpu"`
-								Memory string `json:"memory"`
-							} `json:"requests"`
-						} `json:"resources"`
-						VolumeMounts []struct {
-							Name      string `json:"name"`
-							ReadOnly  bool   `json:"readOnly,omitempty"`
-							MountPath string `json:"mountPath"`
-						} `json:"volumeMounts"`
-						SecurityContext struct {
-							Capabilities struct {
-								Add []string `json:"add"`
-							} `json:"capabilities"`
-							Privileged bool `json:"privileged"`
-						} `json:"securityContext"`
-					} `json:"containers"`
-					DNSConfig struct {
-						Options []struct {
-							Name  string `json:"name"`
-							Value string `json:"value"`
-						} `json:"options"`
-					} `json:"dnsConfig"`
-				} `json:"pod_spec"`
-			} `json:"spec"`
-			Status struct {
-				StartTime      time.Time `json:"startTime"`
-				PendingTime    time.Time `json:"pendingTime"`
-				CompletionTime time.Time `json:"completionTime"`
-				State          string    `json:"state"`
-				Description    string    `json:"description"`
-				URL            string    `json:"url"`
-				PodName        string    `json:"pod_name"`
-				BuildID        string    `json:"build_id"`
-			} `json:"status"`
+		g.count++
+		g.tests[res.Name] = struct{}{}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
 		}
+		return groups[i].err < groups[j].err
+	})
 
-		prowjob := prowJobV1{}
-		err = json.Unmarshal(bytes, &prowjob)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse prowjob.json file %s: %w", artifact, err)
+	var clusters []ErrorCluster
+	var tokenSets []map[string]struct{}
+	var tests []map[string]struct{}
+	for _, g := range groups {
+		toks := tokens(g.err)
+		found := -1
+		for i := range clusters {
+			if jaccard(toks, tokenSets[i]) >= threshold {
+				found = i
+				break
+			}
 		}
+		if found == -1 {
+			clusters = append(clusters, ErrorCluster{Err: g.err, Fingerprint: fingerprint(g.err)})
+			tokenSets = append(tokenSets, toks)
+			tests = append(tests, make(map[string]struct{}))
+			found = len(clusters) - 1
+		}
+		clusters[found].Count += g.count
+		clusters[found].Errs = append(clusters[found].Errs, g.err)
+		for name := range g.tests {
+			tests[found][name] = struct{}{}
+		}
+	}
 
-		duration := int(math.Floor(prowjob.Status.CompletionTime.Sub(prowjob.Status.StartTime).Seconds()))
-		var status BuildStatus
-		switch prowjob.Status.State {
-		case "success":
-			status = BuildSuccess
-		case "failure":
-			status = BuildFailed
-		case "pending", "aborted":
-			// We don't care about pending builds. Aborted builds are not
-			// interesting either since their duration won't make sense.
-			continue
-		default:
-			return nil, fmt.Errorf("developer mistake: unknown state %s", prowjob.Status.State)
+	for i := range clusters {
+		for name := range tests[i] {
+			clusters[i].Tests = append(clusters[i].Tests, name)
 		}
+		sort.Strings(clusters[i].Tests)
+	}
 
-		errStr := ""
-		if prowjob.Status.State != "success" {
-			errStr = prowjob.Status.Description
+	// The clusters were created from the most common message to the least
+	// common one, but a cluster may have grown bigger than the ones created
+	// before it.
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
 		}
+		return clusters[i].Err < clusters[j].Err
+	})
+	return clusters
+}
 
-		results = append(results, BuildResult{
-			JobName:  prowjob.Spec.Job,
-			Status:   status,
-			Duration: duration,
-			URL:      prowjob.Status.URL,
-			Err:      errStr,
-		})
+// writeClustersDot writes the clusters as a Graphviz graph in which each
+// error cluster is linked to the tests that failed with one of its errors.
+// The clusters are labeled with their fingerprint, count, and error message.
+// It looks like this:
+//
+//	graph "clusters" {
+//	  "1f2e3d4c" [shape=box, label="1f2e3d4c (4): timed out waiting for the condition"];
+//	  "1f2e3d4c" -- "foo";
+//	}
+func writeClustersDot(out io.Writer, clusters []ErrorCluster) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "graph \"clusters\" {\n")
+	for _, cluster := range clusters {
+		label := fmt.Sprintf("%s (%d): %s", cluster.Fingerprint, cluster.Count, fitText(cluster.Err, 80, false, ""))
+		fmt.Fprintf(w, "  %s [shape=box, label=%s];\n", dotQuote(cluster.Fingerprint), dotQuote(label))
+		for _, test := range cluster.Tests {
+			fmt.Fprintf(w, "  %s -- %s;\n", dotQuote(cluster.Fingerprint), dotQuote(test))
+		}
 	}
+	fmt.Fprintf(w, "}\n")
+	return w.Flush()
+}
 
-	return results, nil
+// dotQuote returns the given string as a double-quoted Graphviz ID. The line
+// breaks are collapsed into spaces.
+func dotQuote(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
 }
 
-// The findCachedArtifacts function returns the paths of the artifacts that
-// have already been downloaded for a given bucket prefix. A bucket prefix
-// is the string that you would give to gsutil when trying to list all the
-// builds. For example, "pr-logs/pull/cert-manager_cert-manager" is a valid
-// bucket prefix that you could use to list builds:
-//
-//	gsutil ls "gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/**"
-//	                              <------------------------------------>
-//	                                           bucket prefix
-//
-// Just for the sake of completeness, this gsutil command returns something
-// like this:
+// fingerprint returns a short identifier of the given error message. The
+// namespaces, IP addresses, and URLs are scrubbed first so that the same error
+// found in two builds gets the same fingerprint.
+func fingerprint(err string) string {
+	sum := sha256.Sum256([]byte(anonymize(err)))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+var (
+	reUUID      = regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`)
+	reTimestamp = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+
+	// The names generated by Kubernetes end with a random suffix of 5
+	// characters taken from an alphabet without vowels, e.g.
+	// "certificate-x2vqk". The pods of a Deployment also have the hash of
+	// their ReplicaSet, e.g. "cert-manager-webhook-7d9f8b6c5-x2vqk". The
+	// first group is kept.
+	reGeneratedName = regexp.MustCompile(`\b([a-z][-a-z0-9]*?)(-[bcdfghjklmnpqrstvwxz2456789]{6,10})?-[bcdfghjklmnpqrstvwxz2456789]{5}\b`)
+)
+
+// normalizeErr replaces the variable parts of an error message so that the
+// same failure found in different builds gives the same string. On top of
+// what anonymize scrubs, the UUIDs, timestamps, and generated resource names
+// are replaced. For example:
 //
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_bazel.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_make-test-ci.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/latest-build.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/artifacts/junit_bazel.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/artifacts/junit_bazel.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/artifacts/junit_bazel.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/latest-build.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/artifacts/junit_bazel.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/artifacts/junit_bazel.xml
-//	gs://jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
-//	                                                          <-->                         <----------------->
-//	                                                        pr number                          build number
+//	pod "cert-manager-webhook-7d9f8b6c5-x2vqk" in namespace "e2e-tests-certificate-q7sg9" is not ready
 //
-// In the case of findCachedArtifacts, imagining that the above artifacts
-// were previously downloaded by downloadBuildArtifactsToCache, then the
-// following paths get returned:
+// becomes:
 //
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_bazel.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/artifacts/junit_make-test-ci.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/1542891685103538176/build-log.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-make-test/latest-build.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/artifacts/junit_bazel.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542425759740596224/build-log.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/artifacts/junit_bazel.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542438055447629824/build-log.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/artifacts/junit_bazel.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/1542891685250338816/build-log.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5250/pull-cert-manager-upgrade/latest-build.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/artifacts/junit_bazel.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542472529862463488/build-log.txt
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/artifacts/junit_bazel.xml
-//	~/.cache/prowdig/jetstack-logs/pr-logs/pull/cert-manager_cert-manager/5251/pull-cert-manager-chart/1542474955155836928/build-log.txt
-func findCachedArtifacts(bucketPrefixes []string, countBuilds int) ([]string, error) {
-	var prDirs []string
-	for _, bucketPrefix := range bucketPrefixes {
-		prDirEntries, err := os.ReadDir(cacheDir + "/" + bucketPrefix)
-		if os.IsNotExist(err) {
+//	pod "cert-manager-webhook-<id>" in namespace "<namespace>" is not ready
+func normalizeErr(err string) string {
+	err = reUUID.ReplaceAllString(err, "<uuid>")
+	err = reTimestamp.ReplaceAllString(err, "<time>")
+	err = anonymize(err)
+	return reGeneratedName.ReplaceAllString(err, "$1-<id>")
+}
+
+// TriageBucket is a group of error messages that are identical once
+// normalized, see normalizeErr.
+type TriageBucket struct {
+	// The normalized error message shared by the messages of the bucket.
+	Pattern string `json:"pattern"`
+
+	// The number of "failed" and "error" results in this bucket.
+	Count int `json:"count"`
+
+	// The tests that failed with this error, the most frequent first.
+	Tests []NameCount `json:"tests"`
+
+	// The most recent error message of the bucket, as it was found.
+	Err string `json:"err"`
+
+	// The URLs of the most recent builds in which this error was found.
+	Builds []string `json:"builds"`
+}
+
+// computeTriage groups the error messages of the "failed" and "error" results
+// by normalized message. Up to "examples" builds are given for each bucket.
+// The buckets are sorted by count in descending order.
+func computeTriage(results []GinkgoResult, examples int) []TriageBucket {
+	type bucket struct {
+		TriageBucket
+		tests  map[string]int
+		latest []GinkgoResult
+	}
+	bucketByPattern := make(map[string]*bucket)
+	var buckets []*bucket
+	for _, res := range results {
+		if (!res.Status.isFailed() && res.Status != statusError) || res.Err == "" {
 			continue
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read current directory: %v", err)
+		pattern := normalizeErr(res.Err)
+		b, ok := bucketByPattern[pattern]
+		if !ok {
+			b = &bucket{TriageBucket: TriageBucket{Pattern: pattern}, tests: make(map[string]int)}
+			bucketByPattern[pattern] = b
+			buckets = append(buckets, b)
 		}
-		for _, dirEntry := range prDirEntries {
-			if !dirEntry.IsDir() {
+		b.Count++
+		b.tests[res.Name]++
+		b.latest = append(b.latest, res)
+	}
+
+	var triage []TriageBucket
+	for _, b := range buckets {
+		for name, count := range b.tests {
+			b.Tests = append(b.Tests, NameCount{Name: name, Count: count})
+		}
+		sort.Slice(b.Tests, func(i, j int) bool {
+			if b.Tests[i].Count != b.Tests[j].Count {
+				return b.Tests[i].Count > b.Tests[j].Count
+			}
+			return b.Tests[i].Name < b.Tests[j].Name
+		})
+
+		sort.SliceStable(b.latest, func(i, j int) bool {
+			return b.latest[i].Build > b.latest[j].Build
+		})
+		b.Err = b.latest[0].Err
+		seen := make(map[string]struct{})
+		for _, res := range b.latest {
+			if len(b.Builds) >= examples {
+				break
+			}
+			url := spyglassURL(res.Source)
+			if url == "" {
 				continue
 			}
-			prDirs = append(prDirs, cacheDir+"/"+bucketPrefix+"/"+dirEntry.Name())
+			key := res.Job + "/" + strconv.Itoa(res.Build)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			b.Builds = append(b.Builds, url)
 		}
+		triage = append(triage, b.TriageBucket)
 	}
 
-	prDirs, err := sortNumericDesc(prDirs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to sort PR prefixes: %w", err)
+	sort.Slice(triage, func(i, j int) bool {
+		if triage[i].Count != triage[j].Count {
+			return triage[i].Count > triage[j].Count
+		}
+		return triage[i].Pattern < triage[j].Pattern
+	})
+	return triage
+}
+
+// printTriage shows the buckets computed by computeTriage. It looks like this:
+//
+//	4 failures in 2 tests: pod "cert-manager-webhook-<id>" in namespace "<namespace>" is not ready
+//	  3 [cert-manager] Vault Issuer should be ready with a valid AppRole
+//	  1 [cert-manager] Vault ClusterIssuer should be ready with a valid AppRole
+//	  https://prow.build-infra.jetstack.net/view/gs/jetstack-logs/logs/ci-cert-manager-e2e-v1-24/1542977259508338688
+func printTriage(out io.Writer, buckets []TriageBucket) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	for i, b := range buckets {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s failures in %d tests: %s\n", red(b.Count), len(b.Tests), fitText(b.Pattern, CLI.Tests.ErrorWidth, CLI.Tests.Wrap, "  "))
+		for _, test := range b.Tests {
+			fmt.Fprintf(w, "  %s\t%s\n", red(test.Count), test.Name)
+		}
+		for _, url := range b.Builds {
+			fmt.Fprintf(w, "  %s\n", blue(url))
+		}
 	}
+	return w.Flush()
+}
 
-	countJobs := 0
-	var artifacts []string
-	for _, prDir := range prDirs {
-		err := filepath.Walk(prDir, func(path string, _ os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+// ErrorHistory tells when the errors of a cluster happened.
+type ErrorHistory struct {
+	Fingerprint string `json:"fingerprint"`
 
-			if strings.HasSuffix(path, "prowjob.json") {
-				countJobs++
-			}
+	// The error message that has the fingerprint.
+	Err string `json:"err"`
 
-			artifacts = append(artifacts, path)
+	// The number of "failed" and "error" results in the cluster.
+	Count int `json:"count"`
 
-			if countJobs >= countBuilds {
-				return io.EOF
-			}
+	// The start time of the first and last builds in which one of the errors
+	// was seen. Zero when the build IDs don't tell when the builds started.
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
 
-			return nil
-		})
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to recurse into %s: %w", prDir, err)
-		}
-		if countJobs >= countBuilds {
-			break
-		}
-	}
-	return artifacts, nil
+	// The number of errors per day (in --timezone), from the day of FirstSeen
+	// to the day of LastSeen. The days without errors are included.
+	Daily []DayCount `json:"daily"`
+
+	// The jobs and the tests affected by the errors, the most affected first.
+	Jobs  []NameCount `json:"jobs"`
+	Tests []NameCount `json:"tests"`
 }
 
-type StatsMaxDuration struct {
-	Name              string `json:"name"`
-	MaxDurationPassed int    `json:"maxDurationPassed"` // in seconds
-	MaxDurationFailed int    `json:"maxDurationFailed"`
+// DayCount is the number of occurrences of something on a given day.
+type DayCount struct {
+	Day   string `json:"day"` // e.g. "2022-07-01".
+	Count int    `json:"count"`
 }
 
-func computeStatsMaxDuration(results []GinkgoResult) []StatsMaxDuration {
-	type max struct {
-		success int
-		failed  int
+// NameCount is the number of occurrences of something, e.g. of a job name.
+type NameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// errorHistory gathers the "failed" and "error" results whose error message
+// is similar to the message that has the given fingerprint, using the same
+// similarity as clusterErrors. The boolean is false when no error message has
+// this fingerprint.
+func errorHistory(results []GinkgoResult, fp string, threshold float64) (ErrorHistory, bool) {
+	history := ErrorHistory{Fingerprint: fp, Daily: []DayCount{}, Jobs: []NameCount{}, Tests: []NameCount{}}
+	for _, res := range results {
+		if res.Status != statusPassed && res.Err != "" && fingerprint(res.Err) == fp {
+			history.Err = res.Err
+			break
+		}
+	}
+	if history.Err == "" {
+		return history, false
 	}
 
-	// The key is the test name.
-	maxMap := make(map[string]max)
+	toks := tokens(history.Err)
+	perDay := make(map[string]int)
+	perJob := make(map[string]int)
+	perTest := make(map[string]int)
+	for _, res := range results {
+		if res.Status == statusPassed || res.Err == "" {
+			continue
+		}
+		if jaccard(tokens(res.Err), toks) < threshold {
+			continue
+		}
+		history.Count++
+		perJob[res.Job]++
+		perTest[res.Name]++
 
-	var testNames []string
-	for _, test := range results {
-		if _, ok := maxMap[test.Name]; !ok {
-			testNames = append(testNames, test.Name)
-			maxMap[test.Name] = max{success: 0, failed: 0}
+		if res.Started.IsZero() {
+			continue
 		}
-		cur := maxMap[test.Name]
-		switch test.Status {
-		case statusPassed:
-			if cur.success < test.Duration {
-				cur.success = test.Duration
-			}
-		case statusFailed:
-			if cur.failed < test.Duration {
-				cur.failed = test.Duration
-			}
+		perDay[res.Started.In(timezone).Format("2006-01-02")]++
+		if history.FirstSeen.IsZero() || res.Started.Before(history.FirstSeen) {
+			history.FirstSeen = res.Started
+		}
+		if res.Started.After(history.LastSeen) {
+			history.LastSeen = res.Started
 		}
-		maxMap[test.Name] = cur
 	}
 
-	// If there has been no failure, then we cannot say anything about the
-	// timeout. So we filter out the tests that have no failure.
-	var testNamesFiltered []string
-	for _, name := range testNames {
-		if maxMap[name].failed == 0 {
-			continue
+	if !history.FirstSeen.IsZero() {
+		last := history.LastSeen.In(timezone).Format("2006-01-02")
+		for day := history.FirstSeen.In(timezone); ; day = day.AddDate(0, 0, 1) {
+			key := day.Format("2006-01-02")
+			history.Daily = append(history.Daily, DayCount{Day: key, Count: perDay[key]})
+			if key == last {
+				break
+			}
 		}
-		testNamesFiltered = append(testNamesFiltered, name)
 	}
-	testNames = testNamesFiltered
 
-	// We want to see the test cases for which the
-	sort.Slice(testNames, func(i, j int) bool {
-		return maxMap[testNames[i]].failed-maxMap[testNames[i]].success < maxMap[testNames[j]].failed-maxMap[testNames[j]].success
+	history.Jobs = sortNameCounts(perJob)
+	history.Tests = sortNameCounts(perTest)
+	return history, true
+}
+
+// sortNameCounts returns the counts sorted in descending order, and then by
+// name.
+func sortNameCounts(counts map[string]int) []NameCount {
+	sorted := []NameCount{}
+	for name, count := range counts {
+		sorted = append(sorted, NameCount{Name: name, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
 	})
+	return sorted
+}
 
-	var stats []StatsMaxDuration
-	for _, name := range testNames {
-		stats = append(stats, StatsMaxDuration{
-			Name:              name,
-			MaxDurationPassed: maxMap[name].success,
-			MaxDurationFailed: maxMap[name].failed,
-		})
+// printErrorHistory shows the history of an error cluster. It looks like this:
+//
+//	timed out waiting for the condition
+//	fingerprint: 1f2e3d4c, count: 4, first seen: 5d ago, last seen: 2d ago
+//
+//	2022-06-27 3
+//	2022-06-28 0
+//	2022-06-29 1
+//
+//	3 ci-cert-manager-e2e-v1-24
+//	1 ci-cert-manager-e2e-v1-23
+//
+//	2 [cert-manager] Vault Issuer should be ready with a valid AppRole
+//	2 [cert-manager] Vault ClusterIssuer should be ready with a valid AppRole
+func printErrorHistory(out io.Writer, history ErrorHistory) error {
+	fmt.Fprintf(out, "%s\n", history.Err)
+	fmt.Fprintf(out, "fingerprint: %s, count: %s, first seen: %s, last seen: %s\n", history.Fingerprint, red(history.Count), formatTime(history.FirstSeen), formatTime(history.LastSeen))
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	if len(history.Daily) > 0 {
+		fmt.Fprintln(w)
 	}
-	return stats
+	for _, day := range history.Daily {
+		count := gray(day.Count)
+		if day.Count > 0 {
+			count = red(day.Count)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", day.Day, count)
+	}
+	fmt.Fprintln(w)
+	for _, job := range history.Jobs {
+		fmt.Fprintf(w, "%s\t%s\n", red(job.Count), job.Name)
+	}
+	fmt.Fprintln(w)
+	for _, test := range history.Tests {
+		fmt.Fprintf(w, "%s\t%s\n", red(test.Count), test.Name)
+	}
+	return w.Flush()
 }
 
-type StatsMostFailures struct {
-	Name        string         `json:"name"`
-	CountPassed int            `json:"countPassed"`
-	CountFailed int            `json:"countFailed"`
-	Errors      []GinkgoResult `json:"errors"`
+// tokens returns the set of lowercased alphanumeric tokens of the given
+// message. Splitting on the punctuation means that a namespace such as
+// "e2e-tests-certificate-abcde" only differs by one token from
+// "e2e-tests-certificate-xyzzy".
+func tokens(msg string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, tok := range reNonAlnum.Split(strings.ToLower(msg), -1) {
+		if tok == "" {
+			continue
+		}
+		set[tok] = struct{}{}
+	}
+	return set
 }
 
-// Sorted by ascending order of count of failures. Tests with no failures
-// are skipped.
-func computeStatsMostFailures(results []GinkgoResult) []StatsMostFailures {
-	type count struct {
-		passed int
-		failed []GinkgoResult
+var reNonAlnum = regexp.MustCompile(`[^a-z0-9]+`)
+
+// jaccard returns the size of the intersection over the size of the union of
+// the two sets. Two empty sets are considered identical.
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	inter := 0
+	for k := range a {
+		if _, ok := b[k]; ok {
+			inter++
+		}
 	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
 
-	// The key is the test name. The value is a list of failure messages.
-	countMap := make(map[string]count)
+// TestHistory is the detail view of a single test.
+type TestHistory struct {
+	Name        string `json:"name"`
+	CountPassed int    `json:"countPassed"`
+	CountFailed int    `json:"countFailed"`
+	CountError  int    `json:"countError"`
 
-	var testNames []string
-	for _, test := range results {
-		if test.Status != statusFailed && test.Status != statusPassed {
+	// The runs of the test, most recent build first.
+	Runs []GinkgoResult `json:"runs"`
+}
+
+// testHistory gathers the results of the given test name. The name must
+// match exactly.
+func testHistory(results []GinkgoResult, name string) TestHistory {
+	history := TestHistory{Name: name, Runs: []GinkgoResult{}}
+	for _, res := range results {
+		if res.Name != name {
 			continue
 		}
+		switch res.Status {
+		case statusPassed:
+			history.CountPassed++
+		case statusFailed, statusTimedOut, statusPanicked:
+			history.CountFailed++
+		case statusError:
+			history.CountError++
+		}
+		history.Runs = append(history.Runs, res)
+	}
 
-		if _, ok := countMap[test.Name]; !ok {
-			testNames = append(testNames, test.Name)
-			countMap[test.Name] = count{}
+	// Build numbers increase over time, so the most recent builds come first.
+	sort.SliceStable(history.Runs, func(i, j int) bool {
+		if history.Runs[i].Build != history.Runs[j].Build {
+			return history.Runs[i].Build > history.Runs[j].Build
 		}
+		return lessResult(history.Runs[i], history.Runs[j])
+	})
 
-		cur := countMap[test.Name]
-		switch test.Status {
+	return history
+}
+
+// printTestHistory shows the history of one test. It looks like this:
+//
+//	[cert-manager] Vault Issuer should be ready with a valid AppRole
+//	passed: 12, failed: 2, error: 0
+//
+//	✅ 5s     ci-cert-manager-e2e-v1-24 1542977259508338688
+//	❌ 1m11s  ci-cert-manager-e2e-v1-23 1542947060200771584: timed out waiting for the condition
+func printTestHistory(out io.Writer, history TestHistory) error {
+	fmt.Fprintf(out, "%s\n", history.Name)
+	fmt.Fprintf(out, "passed: %s, failed: %s, error: %s\n\n", green(history.CountPassed), red(history.CountFailed), blue(history.CountError))
+
+	// The runs are ordered from newest to oldest. Each error message is
+	// diffed against the error message of the previous (i.e., older) failure
+	// so that you can tell whether a recurring failure is identical or
+	// subtly different.
+	errs := make([]string, len(history.Runs))
+	prev := -1
+	for i := len(history.Runs) - 1; i >= 0; i-- {
+		res := history.Runs[i]
+		if res.Status == statusPassed {
+			continue
+		}
+		switch {
+		case prev == -1:
+			errs[i] = gray(res.Err)
+		case res.Err == history.Runs[prev].Err:
+			errs[i] = gray(res.Err + " (same as previous)")
+		default:
+			errs[i] = formatWordDiff(wordDiff(history.Runs[prev].Err, res.Err))
+		}
+		prev = i
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	for i, res := range history.Runs {
+		duration := formatDuration(res.Duration)
+		switch res.Status {
 		case statusPassed:
-			cur.passed += 1
-		case statusFailed:
-			cur.failed = append(cur.failed, test)
+			fmt.Fprintf(w, "%s %s\t%s\t%d%s\n", icon(statusPassed), green(duration), res.Job, res.Build, link(res.Source))
+		case statusFailed, statusTimedOut, statusPanicked:
+			fmt.Fprintf(w, "%s %s\t%s\t%d: %s%s\n", icon(res.Status), red(duration), res.Job, res.Build, errs[i], link(res.Source))
+		case statusError:
+			fmt.Fprintf(w, "%s %s\t%s\t%d: %s%s\n", icon(statusError), blue(duration), res.Job, res.Build, errs[i], link(res.Source))
+		default:
+			panic("developer mistake: unknown status: " + res.Status)
 		}
-		countMap[test.Name] = cur
 	}
+	return w.Flush()
+}
 
-	sort.Slice(testNames, func(i, j int) bool {
-		return len(countMap[testNames[i]].failed) < len(countMap[testNames[j]].failed)
-	})
+type diffOp struct {
+	// Either ' ' (unchanged), '-' (removed), or '+' (added).
+	Kind byte
+	Word string
+}
 
-	var stats []StatsMostFailures
-	for _, name := range testNames {
-		if len(countMap[name].failed) == 0 {
-			continue
+// wordDiff returns the word-level diff between old and new using the longest
+// common subsequence of their words. The words are split on whitespace.
+func wordDiff(old, new string) []diffOp {
+	a, b := strings.Fields(old), strings.Fields(new)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
 		}
+	}
 
-		stats = append(stats, StatsMostFailures{
-			Name:        name,
-			CountPassed: countMap[name].passed,
-			CountFailed: len(countMap[name].failed),
-			Errors:      countMap[name].failed,
-		})
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
 	}
-	return stats
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// formatWordDiff shows the removed words as [-word-] and the added words as
+// {+word+}, the same way as 'git diff --word-diff=plain' does. The markers
+// are kept even when the colors are enabled so that the diff can still be
+// read once copy-pasted.
+func formatWordDiff(ops []diffOp) string {
+	var words []string
+	for _, op := range ops {
+		switch op.Kind {
+		case ' ':
+			words = append(words, gray(op.Word))
+		case '-':
+			words = append(words, red("[-"+op.Word+"-]"))
+		case '+':
+			words = append(words, green("{+"+op.Word+"+}"))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// fuzzyPick lets the user pick one of the given choices with fzf. An empty
+// string is returned when the user aborts.
+func fuzzyPick(choices []string) (string, error) {
+	_, err := exec.LookPath("fzf")
+	if err != nil {
+		return "", fmt.Errorf("fzf is needed for picking a test, see https://github.com/junegunn/fzf#installation: %w", err)
+	}
+
+	cmd := exec.Command("fzf", "--prompt=test> ", "--no-multi")
+	cmd.Stdin = strings.NewReader(strings.Join(choices, "\n"))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+
+	// fzf exits with 130 when the user presses Escape or Ctrl+C, and with 1
+	// when nothing matched.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 130 || exitErr.ExitCode() == 1) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("while running fzf: %w", err)
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
 }
 
-// The "skipped", "failed", and "error" tests are not taken into account. Only
-// the and "passed" are dealt with. The "failed" and "error" results are to be
-// fetched from build-log.txt files.
+// parseJunit returns the "passed", "failed", and "error" test cases of the
+// given junit file; the "skipped" ones are not taken into account. The error
+// message and location of the failures are read from the <failure> and
+// <error> elements, see parseJunitFailure, so that the builds that don't
+// print the failures to build-log.txt still tell why their tests failed.
 func parseJunit(bytes []byte) ([]parsedGinkgoBlock, error) {
 	suites, err := junit.Ingest(bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to ingest junit XML: %w", err)
 	}
 
+	// When a test is re-run, e.g. with gotestsum's --rerun-fails or Ginkgo's
+	// --flake-attempts, the junit file contains one test case per attempt.
+	attempts := make(map[string]int)
+	for _, suite := range suites {
+		for _, test := range suite.Tests {
+			if test.Status != "skipped" {
+				attempts[test.Name]++
+			}
+		}
+	}
+
 	var results []parsedGinkgoBlock
 	for _, suite := range suites {
 		for _, test := range suite.Tests {
 			var s status
+			var errStr, errLoc string
 			switch test.Status {
 			case "passed":
 				s = statusPassed
-			case "skipped", "failed", "error":
+			case "failed", "error":
+				failure, _ := test.Error.(junit.Error)
+				s, errStr, errLoc = parseJunitFailure(status(test.Status), failure)
+			case "skipped":
 				continue
 			}
 
-			results = append(results, parsedGinkgoBlock{
-				name: test.Name,
-				// Anything lower than 1s should appear as "0s" since we don't
-				// care about fast tests.
-				duration: int(math.Floor(test.Duration.Seconds())),
-				status:   s,
-				errStr:   "",
-				errLoc:   "",
-			})
+			// Ginkgo v2 prefixes the test names with the leaf node, which
+			// the names found in build-log.txt don't have.
+			results = append(results, parsedGinkgoBlock{
+				name:      strings.TrimPrefix(test.Name, "[It] "),
+				duration:  test.Duration.Round(time.Millisecond),
+				status:    s,
+				errStr:    errStr,
+				errLoc:    errLoc,
+				systemOut: test.SystemOut,
+				systemErr: test.SystemErr,
+				attempts:  attempts[test.Name],
+				// The properties of the test suite, such as the Ginkgo
+				// seed, apply to each of its tests.
+				properties: suite.Properties,
+			})
+		}
+	}
+	return results, nil
+}
+
+var (
+	// The line that tells where a Ginkgo v2 failure happened, e.g.:
+	//
+	//	In [It] at: /home/prow/go/src/github.com/cert-manager/cert-manager/test/e2e/suite/conformance/certificates/tests.go:153 @ 07/01/22 10:32:01.123
+	reJunitV2Location = regexp.MustCompile(`(?m)^\s*In \[([^\]]+)\] at: (\S+)`)
+
+	// A Go file and line number alone on its line, as found in the Ginkgo v1
+	// failures.
+	reJunitLocation = regexp.MustCompile(`^\S+\.go:\d+$`)
+)
+
+// parseJunitFailure returns the status, error message, and error location of
+// a <failure> or <error> element. Ginkgo v2 gives the message in the
+// "message" attribute and the location in the body:
+//
+//	<failure message="failed to create issuer" type="failed">[FAILED] failed to create issuer
+//	In [It] at: test/e2e/suite/conformance/tests.go:149 @ 07/01/22 10:32:01.123
+//	</failure>
+//
+// The failures that happen in a setup node, e.g. "In [BeforeEach]", are shown
+// as "error" like parseGinkgoV2Summary does. Ginkgo v1 gives the location of
+// the container, the message, and the location of the failure in the body:
+//
+//	<failure type="Failure">test/e2e/framework/framework.go:287
+//	failed to create issuer
+//	test/e2e/suite/conformance/tests.go:149
+//	</failure>
+//
+// For the other junit files, e.g. the ones written by gotestsum, the message
+// is the whole body, or the "message" attribute when the body is empty.
+func parseJunitFailure(s status, failure junit.Error) (status, string, string) {
+	switch strings.ToLower(failure.Type) {
+	case "timeout", "timedout":
+		s = statusTimedOut
+	case "panic", "panicked":
+		s = statusPanicked
+	}
+
+	body := strings.TrimSpace(failure.Body)
+	if m := reJunitV2Location.FindStringSubmatch(body); m != nil {
+		if m[1] != "It" && s == statusFailed {
+			s = statusError
+		}
+		errStr := failure.Message
+		if errStr == "" {
+			errStr = strings.TrimSpace(body[:strings.Index(body, m[0])])
+			errStr = strings.TrimPrefix(errStr, "[FAILED] ")
+		}
+		return s, errStr, m[2]
+	}
+
+	lines := strings.Split(body, "\n")
+	errLoc := ""
+	if len(lines) > 1 && reJunitLocation.MatchString(strings.TrimSpace(lines[len(lines)-1])) {
+		errLoc = strings.TrimSpace(lines[len(lines)-1])
+		lines = lines[:len(lines)-1]
+		if reJunitLocation.MatchString(strings.TrimSpace(lines[0])) {
+			lines = lines[1:]
+		}
+	}
+	errStr := strings.TrimSpace(strings.Join(lines, "\n"))
+	if errStr == "" {
+		errStr = failure.Message
+	}
+	return s, errStr, errLoc
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJunit writes the results as a junit XML document made of a single
+// test suite named after the given suite name (usually the file or URL the
+// results were parsed from). The "failed" results become <failure> elements,
+// and the "error" results become <error> elements. The failure message is the
+// first line of Err, and the failure body contains the whole Err followed by
+// ErrLoc.
+func writeJunit(w io.Writer, suiteName string, results []GinkgoResult) error {
+	suite := junitTestSuite{Name: suiteName}
+	for _, res := range results {
+		testCase := junitTestCase{
+			Name:      res.Name,
+			ClassName: "prowdig",
+			Time:      res.Duration.Seconds(),
+		}
+
+		failure := &junitFailure{
+			Message: strings.SplitN(res.Err, "\n", 2)[0],
+			Text:    strings.TrimSpace(res.Err + "\n" + res.ErrLoc),
+		}
+		switch res.Status {
+		case statusFailed, statusTimedOut, statusPanicked:
+			testCase.Failure = failure
+			suite.Failures++
+		case statusError:
+			testCase.Error = failure
+			suite.Errors++
+		}
+
+		suite.Tests++
+		suite.Time += res.Duration.Seconds()
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}})
+	if err != nil {
+		return fmt.Errorf("while encoding junit XML: %w", err)
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// The test names contain spaces and brackets, which is why each candidate is
+// quoted with printf %q. Both "--name foo" and "--name=foo" are completed; with
+// the latter, bash splits "=" into its own word due to COMP_WORDBREAKS. The
+// candidates are the ones that contain the word being completed, which is the
+// way --name matches test names. The flags that select the cache directory,
+// such as --profile, are passed on to 'prowdig complete' so that the names
+// come from the same cache as the command being completed.
+//
+// Kong v0.2 has no completion predictors, which is why the script is written
+// by hand rather than generated by kong.
+const bashCompletion = `_prowdig() {
+	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" kind
+	if [[ $cur == "=" ]]; then
+		cur=""
+	elif [[ $prev == "=" ]]; then
+		prev="${COMP_WORDS[COMP_CWORD-2]}"
+	fi
+	case "$prev" in
+	--name) kind=names ;;
+	--job) kind=jobs ;;
+	*) return ;;
+	esac
+
+	local i word flags=()
+	for ((i = 1; i < COMP_CWORD - 1; i++)); do
+		word="${COMP_WORDS[i]}"
+		case "$word" in
+		--profile | --cache-dir | --config | --bucket | --storage | --ci-prefixes | --pr-prefixes)
+			if [[ ${COMP_WORDS[i+1]} == "=" ]]; then
+				flags+=("$word=${COMP_WORDS[i+2]}")
+				((i += 2))
+			else
+				flags+=("$word=${COMP_WORDS[i+1]}")
+				((i++))
+			fi
+			;;
+		esac
+	done
+
+	local IFS=$'\n' candidate
+	COMPREPLY=()
+	for candidate in $(prowdig "${flags[@]}" complete "$kind" 2>/dev/null | grep -F -- "$cur"); do
+		COMPREPLY+=("$(printf '%q' "$candidate")")
+	done
+}
+complete -o default -F _prowdig prowdig
+`
+
+// Returns the sorted and deduplicated test names (kind "names") or job names
+// (kind "jobs") found in the given results.
+func completionCandidates(results []GinkgoResult, kind string) []string {
+	var candidates []string
+	seen := make(map[string]struct{})
+	for _, res := range results {
+		var candidate string
+		switch kind {
+		case "names":
+			candidate = res.Name
+		case "jobs":
+			candidate = res.Job
+		default:
+			panic("developer mistake: unknown completion kind: " + kind)
+		}
+		if candidate == "" {
+			continue
 		}
+		if _, ok := seen[candidate]; ok {
+			continue
+		}
+		seen[candidate] = struct{}{}
+		candidates = append(candidates, candidate)
 	}
-	return results, nil
+	sort.Strings(candidates)
+	return candidates
 }
 
 // Returns the numerically ordered pull request prefixes in decreasing order.
@@ -1853,17 +11838,19 @@ func parseJunit(bytes []byte) ([]parsedGinkgoBlock, error) {
 //	pr-logs/pull/jetstack_cert-manager/2/
 //	pr-logs/pull/jetstack_cert-manager/1/
 //	<----------- prefix ------------->
-func listPRPrefixes(bucket *storage.BucketHandle, prefixes []string) ([]string, error) {
+func listPRPrefixes(bucket objectStore, prefixes []string) ([]string, error) {
 	for i := range prefixes {
 		if !strings.HasSuffix(prefixes[i], "/") {
 			prefixes[i] += "/"
 		}
 	}
 
-	var prPrefixes []string
-	for _, prefix := range prefixes {
-		prIter := bucket.Objects(context.Background(), &storage.Query{
-			Prefix: prefix, Delimiter: "/", Projection: storage.ProjectionNoACL,
+	// Each prefix is listed in its own goroutine; perPrefix[i] holds the PR
+	// prefixes found under prefixes[i].
+	perPrefix := make([][]string, len(prefixes))
+	err := forEachConcurrently(len(prefixes), func(i int) error {
+		prIter := bucket.List(context.Background(), objectQuery{
+			Prefix: prefixes[i], Delimiter: "/",
 		})
 
 		for {
@@ -1872,18 +11859,27 @@ func listPRPrefixes(bucket *storage.BucketHandle, prefixes []string) ([]string,
 				break
 			}
 			if err != nil {
-				return nil, fmt.Errorf("failed to iterate over GCS objects: %v", err)
+				return fmt.Errorf("failed to iterate over the objects: %v", err)
 			}
 
 			if !endsWithPRNumber.MatchString(pr.Prefix) {
 				continue
 			}
 
-			prPrefixes = append(prPrefixes, pr.Prefix)
+			perPrefix[i] = append(perPrefix[i], pr.Prefix)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var prPrefixes []string
+	for _, found := range perPrefix {
+		prPrefixes = append(prPrefixes, found...)
 	}
 
-	prPrefixes, err := sortNumericDesc(prPrefixes)
+	prPrefixes, err = sortNumericDesc(prPrefixes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sort PR prefixes: %w", err)
 	}
@@ -1935,6 +11931,124 @@ func sortNumericDesc(prPrefixes []string) ([]string, error) {
 	return prPrefixes, nil
 }
 
+// ensureCacheDir creates the cache directory if it doesn't exist yet. A
+// read-only cache is never created: a missing read-only cache is just empty.
+func ensureCacheDir(readOnly bool) error {
+	info, err := os.Stat(cacheDir)
+	switch {
+	case err == nil && !info.IsDir():
+		return fmt.Errorf("the cache directory %s is not a directory", cacheDir)
+	case err == nil:
+		return nil
+	case !os.IsNotExist(err):
+		return fmt.Errorf("while reading the cache directory: %w", err)
+	case readOnly:
+		return nil
+	}
+
+	err = os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return fmt.Errorf("while creating the cache directory: %w", err)
+	}
+	return nil
+}
+
+// The lock file lives in the cache directory, e.g.
+// ~/.cache/prowdig/jetstack-logs/.lock. Like the blob store, its name starts
+// with a dot so that it can't be mistaken for a bucket prefix. It is never
+// removed, since a process that locked a removed file wouldn't be seen by the
+// processes that lock the new one.
+const lockFileName = ".lock"
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// lockCache locks the given cache directory so that the prowdig processes
+// running at the same time don't step on each other. The lock is exclusive
+// for the commands that write to the cache and shared otherwise. A read-only
+// cache is locked with a shared lock when its lock file exists, and isn't
+// locked at all otherwise. When another process holds the lock, lockCache
+// fails unless wait is true. The lock is released by calling the returned
+// function, or when the process exits.
+func lockCache(dir string, exclusive, readOnly, wait bool) (func(), error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flags = os.O_RDONLY
+		exclusive = false
+	}
+	f, err := os.OpenFile(dir+"/"+lockFileName, flags, 0644)
+	if readOnly && os.IsNotExist(err) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while locking the cache directory: %w", err)
+	}
+
+	err = lockFile(f, exclusive, false)
+	if err == errLocked && wait {
+		fmt.Fprintf(os.Stderr, "waiting for another prowdig process to finish using the cache directory %s\n", dir)
+		err = lockFile(f, exclusive, true)
+	}
+	switch {
+	case err == errLocked:
+		f.Close()
+		return nil, fmt.Errorf("the cache directory %s is being used by another prowdig process, use --wait-for-lock to wait for it to finish", dir)
+	case err != nil:
+		f.Close()
+		return nil, fmt.Errorf("while locking the cache directory: %w", err)
+	}
+
+	return func() { f.Close() }, nil
+}
+
+// writesCache tells whether the given command may write to the cache
+// directory, in which case it needs the cache for itself. The commands that
+// analyze the cache without downloading only write the index of the parsed
+// results, which is replaced atomically.
+func writesCache(cmd string) bool {
+	switch cmd {
+	case "cache info", "cache size", "cache export <file>", "complete <kind>":
+		return false
+	}
+	return !CLI.NoDownload || !readsCache(cmd)
+}
+
+// readsCache tells whether the given command analyzes the artifacts found in
+// the cache directory.
+func readsCache(cmd string) bool {
+	switch {
+	case cmd == "tests parse-logs <file-or-url>":
+		return false
+	case strings.HasPrefix(cmd, "tests "):
+		return true
+	}
+	switch cmd {
+	case "builds list", "builds most-failures", "builds durations", "builds retests", "builds storms", "builds analyze <url>", "jobs coverage", "jobs suites", "jobs health", "export series", "snapshot", "errors history <fingerprint>", "parse-errors":
+		return true
+	}
+	return false
+}
+
+// isCacheEmpty tells whether nothing was downloaded to the cache directory
+// yet. A missing cache directory is empty. The blobs directory doesn't count
+// since the artifacts are hard links to the blobs, and neither do the index of
+// the parsed results and the metadata and lock file of the cache.
+func isCacheEmpty() (bool, error) {
+	entries, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.Name() != blobsDirName && entry.Name() != indexDirName && entry.Name() != legacyIndexFileName && entry.Name() != cacheMetaFileName && entry.Name() != lockFileName {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // Get an object from the cache. No checksum is performed. It is assumed that
 // downloadToCache was previously run. The name is expected to look like this:
 //
@@ -1950,71 +12064,638 @@ func loadFromCache(filePath string) ([]byte, error) {
 	return bytes, nil
 }
 
-// downloadToCache fetches the object from GCS and stores it in ~/.cache/prowdig/.
-// If the object is already in the cache and its CRC32 sum matches the one in
-// GCS, the cached object is returned. If the CRC32 sum does not match, the
-// object is re-downloaded.
-func downloadToCache(object *storage.ObjectAttrs, bucket *storage.BucketHandle) error {
+// downloadToCache fetches the object from the bucket and stores it in
+// ~/.cache/prowdig/. If the object is already in the cache and its checksum
+// matches the one in the bucket (the CRC32 sum with GCS, the ETag with S3),
+// nothing is downloaded. If the checksum does not match, the object is
+// re-downloaded. The whole objects are streamed to disk and resumed when a
+// previous download was interrupted, see streamToCache. The returned boolean
+// tells whether the object was downloaded.
+func downloadToCache(object *objectAttrs, bucket objectStore) (bool, error) {
 	filePath := cacheDir + "/" + object.Name
+	tail := isTailDownload(object)
 	if _, err := os.Stat(filePath); err == nil {
 		bytes, err := ioutil.ReadFile(filePath)
 		if err != nil {
-			return fmt.Errorf("failed to read from cache: %s: %w", object.Name, err)
+			return false, fmt.Errorf("failed to read from cache: %s: %w", object.Name, err)
 		}
 
-		if crc32.Checksum(bytes, crc32.MakeTable(crc32.Castagnoli)) == object.CRC32C {
+		truncatedSize, truncated := parseTruncatedMarker(bytes)
+		switch {
+		case object.sameContent(bytes), tail && truncated && truncatedSize == object.Size:
 			// We have hit the cache!
-			return nil
+			touchBuildDir(object.Name)
+			return false, nil
+		case !truncated:
+			fmt.Fprintf(os.Stderr, "warning: checksum for cache file %s does not match, it will be re-downloaded\n", filePath)
 		}
+	}
 
-		fmt.Fprintf(os.Stderr, "warning: checksum for cache file %s does not match, it will be re-downloaded\n", filePath)
+	if !tail {
+		err := streamToCache(object, bucket, filePath)
+		if err != nil {
+			return false, err
+		}
+		touchBuildDir(object.Name)
+		return true, nil
 	}
 
-	reader, err := bucket.Object(object.Name).NewReader(context.Background())
+	reader, err := bucket.ReadTail(context.Background(), object.Name, tailBytes)
 	if err != nil {
-		return fmt.Errorf("failed to read GCS object: %s: %w", object.Name, err)
+		return false, fmt.Errorf("failed to read object: %s: %w", object.Name, err)
 	}
+	defer reader.Close()
 
 	bytes, err := ioutil.ReadAll(reader)
 	if err != nil {
-		return fmt.Errorf("failed to read GCS object: %s: %w", object.Name, err)
+		return false, fmt.Errorf("failed to read object: %s: %w", object.Name, err)
 	}
+	bytes = truncateToTail(bytes, object.Size)
 
-	err = os.MkdirAll(path.Dir(filePath), 0755)
+	err = writeToCache(filePath, bytes)
 	if err != nil {
-		return fmt.Errorf("failed to create cache dir: %w", err)
+		return false, fmt.Errorf("failed to write to cache: %s: %w", object.Name, err)
+	}
+	touchBuildDir(object.Name)
+
+	return true, nil
+}
+
+// The objects being downloaded are written to the blob store first, e.g.
+// ~/.cache/prowdig/jetstack-logs/.blobs/partial/5d41402abc4b2a76..., see
+// streamToCache. Since the partial files aren't linked from any artifact,
+// pruneBlobs removes the ones left behind by the interrupted downloads.
+const partialDirName = "partial"
+
+// streamToCache downloads the whole object to a partial file in the blob
+// store while computing its checksum, moves it to the blob store once
+// verified, and hard links filePath to the blob like writeToCache does. The
+// object is never held in memory. When a previous download of the same object
+// was interrupted, e.g. by a flaky connection, only the missing end of the
+// object is downloaded. The partial file is named after the object name, size,
+// and checksum so that a download is never resumed over a different object.
+func streamToCache(object *objectAttrs, bucket objectStore, filePath string) error {
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%s", object.Name, object.Size, object.CRC32C, object.ETag)))
+	partial := cacheDir + "/" + blobsDirName + "/" + partialDirName + "/" + hex.EncodeToString(key[:])
+	err := os.MkdirAll(filepath.Dir(partial), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create the partial dir: %w", err)
+	}
+
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write to cache: %s: %w", object.Name, err)
+	}
+	defer f.Close()
+
+	// The bytes downloaded previously are hashed again since the state of
+	// the hashes isn't saved. The size of the objects listed by the HTTP
+	// artifact servers isn't known, so they are never resumed.
+	sum := object.newHash()
+	blobSum := sha256.New()
+	offset, err := io.Copy(io.MultiWriter(sum, blobSum), f)
+	if err != nil {
+		return fmt.Errorf("failed to read the partial download of %s: %w", object.Name, err)
+	}
+	if offset > 0 && (object.Unchecked || offset >= object.Size) {
+		err = f.Truncate(0)
+		if err != nil {
+			return fmt.Errorf("failed to write to cache: %s: %w", object.Name, err)
+		}
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("failed to write to cache: %s: %w", object.Name, err)
+		}
+		sum.Reset()
+		blobSum.Reset()
+		offset = 0
+	}
+	if offset > 0 && CLI.Debug {
+		fmt.Fprintf(os.Stderr, "debug: resuming the download of %s at %s out of %s\n", object.Name, ByteCountSI(offset), ByteCountSI(object.Size))
+	}
+
+	var reader io.ReadCloser
+	if offset > 0 {
+		reader, err = bucket.ReadTail(context.Background(), object.Name, object.Size-offset)
+	} else {
+		reader, err = bucket.ReadObject(context.Background(), object.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read object: %s: %w", object.Name, err)
 	}
+	defer reader.Close()
 
-	err = ioutil.WriteFile(filePath, bytes, 0644)
+	// When the download is interrupted, what was written so far is kept for
+	// the next attempt.
+	n, err := io.Copy(io.MultiWriter(f, sum, blobSum), reader)
+	if err != nil {
+		return fmt.Errorf("failed to read object: %s: %w", object.Name, err)
+	}
+	err = f.Close()
 	if err != nil {
 		return fmt.Errorf("failed to write to cache: %s: %w", object.Name, err)
 	}
 
+	if !object.sameSum(sum.Sum(nil), offset+n) {
+		_ = os.Remove(partial)
+		return fmt.Errorf("failed to read object: %s: the checksum of the downloaded content doesn't match the one of the object", object.Name)
+	}
+
+	hash := hex.EncodeToString(blobSum.Sum(nil))
+	blob := cacheDir + "/" + blobsDirName + "/" + hash[:2] + "/" + hash
+	err = os.MkdirAll(filepath.Dir(blob), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create the blob dir: %w", err)
+	}
+	err = os.Rename(partial, blob)
+	if err != nil {
+		return fmt.Errorf("failed to write the blob %s: %w", hash, err)
+	}
+
+	err = linkToBlob(filePath, blob)
+	if err != nil {
+		return fmt.Errorf("failed to write to cache: %s: %w", object.Name, err)
+	}
 	return nil
 }
 
-//	 pr-logs/pull/jetstack_cert-manager/4664/pull-cert-manager-e2e-v1-13/14356/artifacts/junit__01.xml
-//	                                    <--> <-------------------------> <--->
-//										 pr number        job name       build number
+// isTailDownload tells whether only the end of the object is to be
+// downloaded, see --tail-bytes.
+func isTailDownload(object *objectAttrs) bool {
+	return tailBytes > 0 && object.Size > tailBytes && isBuildLogFile.MatchString(object.Name)
+}
+
+// downloadSize is the number of bytes that downloadToCache downloads for the
+// given object.
+func downloadSize(object *objectAttrs) int64 {
+	if isTailDownload(object) {
+		return tailBytes
+	}
+	return object.Size
+}
+
+// The first line of the build-log.txt files of which only the end was
+// downloaded with --tail-bytes, e.g.:
+//
+//	prowdig: truncated, only the last 5000000 of the 64231052 bytes were downloaded
+var reTruncatedMarker = regexp.MustCompile(`^prowdig: truncated, only the last (\d+) of the (\d+) bytes were downloaded\n`)
+
+// truncateToTail turns the end of an object downloaded with --tail-bytes into
+// the content of the cached file: the first line, which is likely cut in the
+// middle, is replaced with the truncation marker.
+func truncateToTail(tail []byte, size int64) []byte {
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	marker := fmt.Sprintf("prowdig: truncated, only the last %d of the %d bytes were downloaded\n", len(tail), size)
+	return append([]byte(marker), tail...)
+}
+
+// parseTruncatedMarker returns the size of the whole object when the given
+// content was truncated by truncateToTail.
+func parseTruncatedMarker(content []byte) (int64, bool) {
+	m := reTruncatedMarker.FindSubmatch(content)
+	if m == nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(string(m[2]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
+
+// touchBuildDir bumps the modification time of the directory of the build
+// that the object belongs to, which tells evictCache when the build was last
+// used.
+func touchBuildDir(objectName string) {
+	dir, ok := buildDir(objectName)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	_ = os.Chtimes(cacheDir+"/"+dir, now, now)
+}
+
+// exportCache writes the content of the cache directory into a tarball. The
+// paths stored in the tarball are relative to the cache directory, e.g.:
+//
+//	pr-logs/pull/jetstack_cert-manager/1/pull-cert-manager-e2e-v1-13/245/build-log.txt
+//
+// The compression is picked from the file extension; see compressedWriter.
+// Returns the number of files and the number of (uncompressed) bytes written.
+func exportCache(file string) (int, int64, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	compressed, err := compressedWriter(file, f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tw := tar.NewWriter(compressed)
+	count, size := 0, int64(0)
+	err = filepath.Walk(cacheDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// The artifacts are hard links to the blobs, no need to export them
+		// twice.
+		if info.IsDir() && filePath == cacheDir+"/"+blobsDirName {
+			return filepath.SkipDir
+		}
+		// The index is rebuilt by the importer on its first analysis, and
+		// the importer's cache has its own layout.
+		if info.IsDir() && filePath == cacheDir+"/"+indexDirName {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() || filePath == cacheDir+"/"+legacyIndexFileName || filePath == cacheDir+"/"+cacheMetaFileName || filePath == cacheDir+"/"+lockFileName {
+			return nil
+		}
+
+		rel, err := filepath.Rel(cacheDir, filePath)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		content, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		n, err := io.Copy(tw, content)
+		if err != nil {
+			return fmt.Errorf("while writing %s: %w", rel, err)
+		}
+
+		count++
+		size += n
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, 0, fmt.Errorf("the cache %s does not exist, run 'prowdig download' first", cacheDir)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = compressed.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, size, f.Close()
+}
+
+// importCache extracts a tarball written by exportCache into the cache
+// directory. Returns the number of files and the number of (uncompressed)
+// bytes extracted.
+func importCache(file string) (int, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	decompressed, err := decompressedReader(file, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer decompressed.Close()
+
+	tr := tar.NewReader(decompressed)
+	count, size := 0, int64(0)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, size, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Let's make sure that a malicious tarball can't write outside of the
+		// cache directory with names such as "../../.bashrc".
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return count, size, fmt.Errorf("refusing to extract %s since it would be written outside of %s", header.Name, cacheDir)
+		}
+		if name == cacheMetaFileName || name == legacyIndexFileName || name == lockFileName || strings.HasPrefix(name, indexDirName+"/") {
+			continue
+		}
+		filePath := filepath.Join(cacheDir, filepath.FromSlash(name))
+
+		// The files go through the blob store like the downloaded ones. An
+		// existing file may be a hard link to a blob, which must not be
+		// overwritten in place.
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return count, size, fmt.Errorf("while extracting %s: %w", header.Name, err)
+		}
+		err = writeToCache(filePath, content)
+		if err != nil {
+			return count, size, err
+		}
+
+		count++
+		size += int64(len(content))
+	}
+
+	return count, size, nil
+}
+
+// compressedWriter wraps w with the compression that matches the extension
+// of the file name: ".tar.zst" uses zstd, ".tar.gz" and ".tgz" use gzip, and
+// ".tar" is not compressed.
+func compressedWriter(file string, w io.Writer) (io.WriteCloser, error) {
+	switch {
+	case strings.HasSuffix(file, ".tar.zst"):
+		return zstd.NewWriter(w)
+	case strings.HasSuffix(file, ".tar.gz"), strings.HasSuffix(file, ".tgz"):
+		return gzip.NewWriter(w), nil
+	case strings.HasSuffix(file, ".tar"):
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unknown extension for %s, expected .tar.zst, .tar.gz, .tgz, or .tar", file)
+	}
+}
+
+// decompressedReader is the counterpart of compressedWriter.
+func decompressedReader(file string, r io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(file, ".tar.zst"):
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case strings.HasSuffix(file, ".tar.gz"), strings.HasSuffix(file, ".tgz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(file, ".tar"):
+		return ioutil.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unknown extension for %s, expected .tar.zst, .tar.gz, .tgz, or .tar", file)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// parseObjectName finds the PR number, job name, and build number in the name
+// of an object stored by Prow. Prow's path strategies ("explicit", "legacy",
+// and "single") only differ in the way the repository shows in the path of
+// presubmits, and the path can be prefixed with a tenant-scoped path_prefix:
+//
+//	pr-logs/pull/jetstack_cert-manager/4664/pull-cert-manager-e2e-v1-13/14356/artifacts/junit__01.xml
+//	             <------------------> <--> <-------------------------> <--->
+//	              org_repo or repo    pr number       job name          build number
+//	              (none with legacy
+//	              and single for the
+//	              default repo)
+//
+//	pr-logs/pull/batch/pull-cert-manager-e2e-v1-13/14356/build-log.txt
+//	logs/ci-cert-manager-e2e-v1-24/1542977259508338688/build-log.txt
+//	<tenant>/logs/ci-cert-manager-e2e-v1-24/1542977259508338688/build-log.txt
+//
+// The PR number is 0 for batches, postsubmits, and periodics.
 func parseObjectName(objectName string) (pr int, job string, build int, err error) {
-	matches := reObjectName.FindStringSubmatch(objectName)
-	if len(matches) != 4 {
-		return 0, "", 0, fmt.Errorf("failed to parse object name, expected %s but got: %s", reObjectName.String(), os.Args[1])
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments {
+		var rest []string
+		switch {
+		case segment == "pr-logs" && i+1 < len(segments) && segments[i+1] == "pull":
+			rest = segments[i+2:]
+			switch {
+			case len(rest) > 0 && rest[0] == "batch":
+				rest = rest[1:]
+			case len(rest) > 0 && isNumber(rest[0]):
+				pr, _ = strconv.Atoi(rest[0])
+				rest = rest[1:]
+			case len(rest) > 1 && isNumber(rest[1]):
+				pr, _ = strconv.Atoi(rest[1])
+				rest = rest[2:]
+			default:
+				return 0, "", 0, fmt.Errorf("failed to parse object name, expected pr-logs/pull/[<org>_<repo>/]<pr>/<job>/<build>/ but got: %s", objectName)
+			}
+		case segment == "logs":
+			rest = segments[i+1:]
+		default:
+			continue
+		}
+
+		if len(rest) < 2 || !isNumber(rest[1]) {
+			return 0, "", 0, fmt.Errorf("failed to parse object name, expected <job>/<build>/ after %s but got: %s", segment, objectName)
+		}
+		build, err = strconv.Atoi(rest[1])
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("failed to parse the build number in %s: %w", objectName, err)
+		}
+		return pr, rest[0], build, nil
+	}
+
+	return 0, "", 0, fmt.Errorf("failed to parse object name, expected it to contain pr-logs/pull/ or logs/ but got: %s", objectName)
+}
+
+// isJunit tells whether the given object is a junit file, either because it
+// matches the junit pattern of the profile or because it is named junit*.xml
+// and lives somewhere under the artifacts/ directory of a build.
+func isJunit(objectName string) bool {
+	return isJunitFile.MatchString(objectName) || isArtifactsJunitFile.MatchString(objectName)
+}
+
+// isOutsideWindow tells whether the given object belongs to a build that
+// started outside of the window given with --days, --since, and --until. The
+// start time is taken from the build number; the objects for which it can't
+// be known are never outside of the window.
+func isOutsideWindow(objectName string) bool {
+	if since.IsZero() && until.IsZero() {
+		return false
+	}
+	_, _, build, err := parseObjectName(objectName)
+	if err != nil {
+		return false
+	}
+	return startedOutsideWindow(buildStarted(build))
+}
+
+// startedOutsideWindow tells whether the given start time is outside of the
+// window given with --days, --since, and --until. A zero time is never
+// outside of the window.
+func startedOutsideWindow(started time.Time) bool {
+	switch {
+	case started.IsZero():
+		return false
+	case !since.IsZero() && started.Before(since):
+		return true
+	case !until.IsZero() && !started.Before(until):
+		return true
+	}
+	return false
+}
+
+// isCachedBuildOutsideWindow tells whether the given cached directory is a
+// build directory whose build started outside of the window given with
+// --days, --since, and --until. Unlike isOutsideWindow, it also works with
+// the builds whose build number isn't a snowflake ID by reading the start
+// time from the prowjob.json or started.json file of the build.
+func isCachedBuildOutsideWindow(dir string) bool {
+	if since.IsZero() && until.IsZero() {
+		return false
+	}
+	_, _, build, err := parseObjectName(strings.TrimPrefix(dir, cacheDir+"/"))
+	if err != nil || filepath.Base(dir) != strconv.Itoa(build) {
+		return false
+	}
+	started := buildStarted(build)
+	if started.IsZero() {
+		started = readBuildStarted(dir)
+	}
+	return startedOutsideWindow(started)
+}
+
+// readBuildStarted returns the start time found in the prowjob.json file of
+// the given build directory, or in its started.json file when there is no
+// prowjob.json. A zero time is returned when neither can be read.
+func readBuildStarted(dir string) time.Time {
+	if bytes, err := os.ReadFile(dir + "/prowjob.json"); err == nil {
+		var prowjob struct {
+			Status struct {
+				StartTime time.Time `json:"startTime"`
+			} `json:"status"`
+		}
+		if json.Unmarshal(bytes, &prowjob) == nil && !prowjob.Status.StartTime.IsZero() {
+			return prowjob.Status.StartTime
+		}
+	}
+	if bytes, err := os.ReadFile(dir + "/started.json"); err == nil {
+		var started struct {
+			Timestamp int64 `json:"timestamp"`
+		}
+		if json.Unmarshal(bytes, &started) == nil && started.Timestamp > 0 {
+			return time.Unix(started.Timestamp, 0).UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// parseWhen parses the value of --since and --until. It is either an age
+// such as "7d" or "12h" (see parseAge) that is subtracted from now, a date
+// such as "2024-05-01" in UTC, or an RFC3339 time. When endOfDay is set, a
+// date stands for the end of that day so that the day is included.
+func parseWhen(s string, now time.Time, endOfDay bool) (time.Time, error) {
+	if day, err := time.Parse("2006-01-02", s); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if age, err := parseAge(s); err == nil {
+		return now.Add(-age), nil
 	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected an age such as '7d', a date such as '2024-05-01', or an RFC3339 time", s)
+}
 
-	pr, err = strconv.Atoi(matches[1])
+// isSelectedBuild tells whether the given object belongs to one of the builds
+// given with --build or --builds-file and to one of the jobs matching --job.
+// When neither is given, all the objects are selected. Otherwise, the objects
+// that don't belong to a build, such as latest-build.txt, aren't selected.
+func isSelectedBuild(objectName string) bool {
+	if onlyBuilds == nil && onlyJobs == nil {
+		return true
+	}
+	_, job, build, err := parseObjectName(objectName)
 	if err != nil {
-		return 0, "", 0, fmt.Errorf("developer mistake: 1st capture in %s got: %s", reObjectName.String(), os.Args[1])
+		return false
+	}
+	if onlyJobs != nil && !onlyJobs.MatchString(job) {
+		return false
+	}
+	if onlyBuilds == nil {
+		return true
 	}
+	_, ok := onlyBuilds[build]
+	return ok
+}
 
-	job = matches[2]
+// readBuildsFile reads the build IDs or the links to the builds listed in the
+// given file, one per line. The empty lines and the lines starting with # are
+// ignored.
+func readBuildsFile(file string) ([]int, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var builds []int
+	for i, line := range strings.Split(string(bytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		build, err := parseBuildArg(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, i+1, err)
+		}
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
 
-	build, err = strconv.Atoi(matches[3])
+// parseBuildArg parses the value of --build and the lines of --builds-file.
+// It is either a build ID or a link to the build or to one of its artifacts,
+// see parseArtifactURL.
+func parseBuildArg(s string) (int, error) {
+	if isNumber(s) {
+		return strconv.Atoi(s)
+	}
+	_, _, objectName, ok := parseArtifactURL(s)
+	if !ok {
+		return 0, fmt.Errorf("%q is not a build ID", s)
+	}
+	_, _, build, err := parseObjectName(objectName)
 	if err != nil {
-		return 0, "", 0, fmt.Errorf("developer mistake: 1st capture in %s got: %s", reObjectName.String(), os.Args[1])
+		return 0, fmt.Errorf("%q is not a link to a build: %w", s, err)
 	}
+	return build, nil
+}
 
-	return pr, job, build, nil
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 func ByteCountSI(b int64) string {